@@ -0,0 +1,235 @@
+package blocklist
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+type cidrEntry struct {
+	net   *net.IPNet
+	entry *Entry
+}
+
+// Blocklist 设备/ip屏蔽名单
+// 条目缓存在内存中, Store变更时(多节点通过pub/sub)刷新
+//
+//	bl := blocklist.New(blocklist.NewRedisStore(""))
+//	go bl.Run(ctx)
+//	pipeline.Intercept(bl.Interceptor())
+type Blocklist struct {
+	store Store
+	log   *logrus.Entry
+
+	mu    sync.RWMutex
+	marks map[string]*Entry
+	ips   map[string]*Entry
+	cidrs []cidrEntry
+}
+
+// New 创建屏蔽名单
+func New(s Store) *Blocklist {
+	return &Blocklist{
+		store: s,
+		log:   logrus.WithField("module", "blocklist"),
+		marks: make(map[string]*Entry),
+		ips:   make(map[string]*Entry),
+	}
+}
+
+// Reload 从Store重新加载, 过期条目从Store中清理(条目已被重新添加时不清理)
+func (b *Blocklist) Reload() error {
+	entries, err := b.store.List()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	marks := make(map[string]*Entry)
+	ips := make(map[string]*Entry)
+	var cidrs []cidrEntry
+	for _, e := range entries {
+		if e.Expired(now) {
+			if err := b.store.RemoveExpired(e); err != nil {
+				b.log.Warnf("remove expired %s: %v", e.Key(), err)
+			}
+			continue
+		}
+		switch e.Kind {
+		case KindMark:
+			marks[e.Value] = e
+		case KindIP:
+			ips[e.Value] = e
+		case KindCIDR:
+			if _, n, err := net.ParseCIDR(e.Value); err == nil {
+				cidrs = append(cidrs, cidrEntry{net: n, entry: e})
+			}
+		}
+	}
+
+	b.mu.Lock()
+	b.marks, b.ips, b.cidrs = marks, ips, cidrs
+	b.mu.Unlock()
+	return nil
+}
+
+// Add 添加屏蔽, ttl<=0表示永久
+func (b *Blocklist) Add(kind Kind, value, reason, operator string, ttl time.Duration) (*Entry, error) {
+	e := &Entry{
+		Kind:      kind,
+		Value:     value,
+		Reason:    reason,
+		Operator:  operator,
+		CreatedAt: time.Now(),
+	}
+	if ttl > 0 {
+		e.ExpireAt = e.CreatedAt.Add(ttl)
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	if err := b.store.Add(e); err != nil {
+		return nil, err
+	}
+	return e, b.Reload()
+}
+
+// Remove 解除屏蔽
+func (b *Blocklist) Remove(kind Kind, value string) error {
+	e := &Entry{Kind: kind, Value: value}
+	if err := e.Validate(); err != nil {
+		return err
+	}
+	if err := b.store.Remove(e.Kind, e.Value); err != nil {
+		return err
+	}
+	return b.Reload()
+}
+
+// List 所有未过期的条目, 按创建时间排序
+func (b *Blocklist) List() []*Entry {
+	now := time.Now()
+	b.mu.RLock()
+	ret := make([]*Entry, 0, len(b.marks)+len(b.ips)+len(b.cidrs))
+	for _, e := range b.marks {
+		ret = append(ret, e)
+	}
+	for _, e := range b.ips {
+		ret = append(ret, e)
+	}
+	for _, c := range b.cidrs {
+		ret = append(ret, c.entry)
+	}
+	b.mu.RUnlock()
+
+	n := 0
+	for _, e := range ret {
+		if !e.Expired(now) {
+			ret[n] = e
+			n++
+		}
+	}
+	ret = ret[:n]
+	sort.Slice(ret, func(i, j int) bool { return ret[i].CreatedAt.Before(ret[j].CreatedAt) })
+	return ret
+}
+
+// CheckMark 设备是否被屏蔽
+func (b *Blocklist) CheckMark(mark string) (*Entry, bool) {
+	if mark == "" {
+		return nil, false
+	}
+	b.mu.RLock()
+	e, ok := b.marks[mark]
+	b.mu.RUnlock()
+	if !ok || e.Expired(time.Now()) {
+		return nil, false
+	}
+	return e, true
+}
+
+// CheckIP ip是否被屏蔽(单个ip或所在网段), addr可以带端口
+func (b *Blocklist) CheckIP(addr string) (*Entry, bool) {
+	host := addr
+	if h, _, err := net.SplitHostPort(addr); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if e, ok := b.ips[ip.String()]; ok && !e.Expired(now) {
+		return e, true
+	}
+	for _, c := range b.cidrs {
+		if c.net.Contains(ip) && !c.entry.Expired(now) {
+			return c.entry, true
+		}
+	}
+	return nil, false
+}
+
+// Check 设备或对端ip是否被屏蔽
+func (b *Blocklist) Check(mark, addr string) (*Entry, bool) {
+	if e, ok := b.CheckMark(mark); ok {
+		return e, true
+	}
+	return b.CheckIP(addr)
+}
+
+// Interceptor 翻译拦截器, 屏蔽的设备/ip返回 driver.ErrBlock, 不进入协议翻译
+// 上下文中还没有mark时(登录报文), 翻译后再按协议解析出的mark检查一次
+func (b *Blocklist) Interceptor() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) ([]driver.Msg, []driver.Msg, error) {
+		if e, ok := b.Check(acctx.Mark, acctx.GetRemote()); ok {
+			return nil, nil, fmt.Errorf("%w: %s reason[%s]", driver.ErrBlock, e.Key(), e.Reason)
+		}
+		if acctx.Mark != "" {
+			return handler(ctx)
+		}
+
+		tos, rets, err := handler(ctx)
+		if e, ok := b.CheckMark(acctx.Mark); ok {
+			return nil, nil, fmt.Errorf("%w: %s reason[%s]", driver.ErrBlock, e.Key(), e.Reason)
+		}
+		return tos, rets, err
+	}
+}
+
+// Run 加载条目并监听变更, 定时清理过期条目, ctx结束后退出
+func (b *Blocklist) Run(ctx context.Context) error {
+	if err := b.Reload(); err != nil {
+		return err
+	}
+	w, err := b.store.Watch(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-w:
+			if !ok {
+				return nil
+			}
+		case <-t.C:
+		}
+		if err := b.Reload(); err != nil {
+			b.log.Errorf("reload: %v", err)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package blocklist
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/internal/httputil"
+)
+
+// addRequest 添加屏蔽请求
+type addRequest struct {
+	Kind     Kind   `json:"kind"`
+	Value    string `json:"value"`
+	Reason   string `json:"reason"`
+	Operator string `json:"operator"`
+	TTL      string `json:"ttl"` // time.ParseDuration格式, 空表示永久
+}
+
+// Handler 屏蔽名单管理接口
+//
+//	GET    /                          列出所有条目
+//	POST   /   {"kind":"mark","value":"xx","reason":"","operator":"","ttl":"24h"}
+//	DELETE /?kind=mark&value=xx       解除屏蔽
+func (b *Blocklist) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			httputil.WriteJSON(w, http.StatusOK, b.List())
+		case http.MethodPost:
+			req := &addRequest{}
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			var ttl time.Duration
+			if req.TTL != "" {
+				var err error
+				if ttl, err = time.ParseDuration(req.TTL); err != nil {
+					httputil.WriteError(w, http.StatusBadRequest, err)
+					return
+				}
+			}
+			e, err := b.Add(req.Kind, req.Value, req.Reason, req.Operator, ttl)
+			if err != nil {
+				httputil.WriteError(w, errStatus(err), err)
+				return
+			}
+			httputil.WriteJSON(w, http.StatusOK, e)
+		case http.MethodDelete:
+			q := r.URL.Query()
+			if err := b.Remove(Kind(q.Get("kind")), q.Get("value")); err != nil {
+				httputil.WriteError(w, errStatus(err), err)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// errStatus 条目不合法返回400, 存储错误返回500
+func errStatus(err error) int {
+	return httputil.ErrStatus(err, http.StatusInternalServerError,
+		httputil.Status{Err: ErrInvalid, Code: http.StatusBadRequest})
+}
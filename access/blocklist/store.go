@@ -0,0 +1,170 @@
+package blocklist
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// ErrInvalid 屏蔽条目不合法
+var ErrInvalid = errors.New("blocklist: invalid entry")
+
+// Kind 屏蔽类型
+type Kind string
+
+const (
+	KindMark Kind = "mark" // 设备标记
+	KindIP   Kind = "ip"   // 单个ip
+	KindCIDR Kind = "cidr" // 网段
+)
+
+// Entry 屏蔽条目
+type Entry struct {
+	Kind      Kind      `json:"kind"`
+	Value     string    `json:"value"`
+	Reason    string    `json:"reason"`
+	Operator  string    `json:"operator"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpireAt  time.Time `json:"expire_at"` // 零值表示永久
+}
+
+// Key 条目唯一键
+func (e *Entry) Key() string {
+	return string(e.Kind) + ":" + e.Value
+}
+
+// Expired 是否已经过期
+func (e *Entry) Expired(now time.Time) bool {
+	return !e.ExpireAt.IsZero() && now.After(e.ExpireAt)
+}
+
+// Validate 校验并规范化条目
+func (e *Entry) Validate() error {
+	switch e.Kind {
+	case KindMark:
+		if e.Value == "" {
+			return fmt.Errorf("%w: empty mark", ErrInvalid)
+		}
+	case KindIP:
+		ip := net.ParseIP(e.Value)
+		if ip == nil {
+			return fmt.Errorf("%w: ip [%s]", ErrInvalid, e.Value)
+		}
+		e.Value = ip.String()
+	case KindCIDR:
+		_, n, err := net.ParseCIDR(e.Value)
+		if err != nil {
+			return fmt.Errorf("%w: cidr [%s]: %v", ErrInvalid, e.Value, err)
+		}
+		e.Value = n.String()
+	default:
+		return fmt.Errorf("%w: unknown kind [%s]", ErrInvalid, e.Kind)
+	}
+	return nil
+}
+
+// Store 屏蔽条目持久化
+type Store interface {
+	// Add 添加或覆盖条目
+	Add(e *Entry) error
+
+	// Remove 删除条目
+	Remove(kind Kind, value string) error
+
+	// RemoveExpired 清理过期条目, 条目已被重新添加(过期时间不同)时不删除
+	RemoveExpired(e *Entry) error
+
+	// List 所有条目(含已过期但未清理的)
+	List() ([]*Entry, error)
+
+	// Watch 条目变更通知, 用于多节点缓存失效, ctx结束后关闭返回的chan
+	Watch(ctx context.Context) (<-chan struct{}, error)
+}
+
+// MemoryStore 内存存储, 只在进程内生效
+type MemoryStore struct {
+	mu       sync.Mutex
+	entries  map[string]*Entry
+	watchers []chan struct{}
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*Entry)}
+}
+
+func (s *MemoryStore) notify() {
+	for _, w := range s.watchers {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Add 添加或覆盖条目
+func (s *MemoryStore) Add(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp := *e
+	s.entries[e.Key()] = &cp
+	s.notify()
+	return nil
+}
+
+// Remove 删除条目
+func (s *MemoryStore) Remove(kind Kind, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, (&Entry{Kind: kind, Value: value}).Key())
+	s.notify()
+	return nil
+}
+
+// RemoveExpired 清理过期条目
+func (s *MemoryStore) RemoveExpired(e *Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if cur, ok := s.entries[e.Key()]; ok && cur.ExpireAt.Equal(e.ExpireAt) {
+		delete(s.entries, e.Key())
+		s.notify()
+	}
+	return nil
+}
+
+// List 所有条目
+func (s *MemoryStore) List() ([]*Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*Entry, 0, len(s.entries))
+	for _, e := range s.entries {
+		cp := *e
+		ret = append(ret, &cp)
+	}
+	return ret, nil
+}
+
+// Watch 条目变更通知
+func (s *MemoryStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	w := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.watchers = append(s.watchers, w)
+	s.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for i, v := range s.watchers {
+			if v == w {
+				s.watchers = append(s.watchers[:i], s.watchers[i+1:]...)
+				break
+			}
+		}
+		close(w)
+	}()
+	return w, nil
+}
@@ -0,0 +1,166 @@
+package blocklist
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// KEYS: entries
+// ARGV: key expire_at
+var removeExpiredScript = redis.NewScript(1, `
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if cur and cjson.decode(cur)['expire_at'] == ARGV[2] then
+	redis.call('HDEL', KEYS[1], ARGV[1])
+	return 1
+end
+return 0
+`)
+
+// RedisStore redis存储, 使用 redigo 连接池
+// 条目变更通过pub/sub通知所有节点刷新缓存
+//
+//	{prefix}:entries     hash     kind:value -> entry json
+//	{prefix}:invalidate  channel  变更通知
+type RedisStore struct {
+	prefix string
+}
+
+// NewRedisStore 创建redis存储, prefix为空时使用 access:blocklist
+func NewRedisStore(prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "access:blocklist"
+	}
+	return &RedisStore{prefix: prefix}
+}
+
+func (s *RedisStore) entriesKey() string {
+	return s.prefix + ":entries"
+}
+
+func (s *RedisStore) channel() string {
+	return s.prefix + ":invalidate"
+}
+
+// Add 添加或覆盖条目
+func (s *RedisStore) Add(e *Entry) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	if _, err = rd.Do("HSET", s.entriesKey(), e.Key(), b); err != nil {
+		return err
+	}
+	_, err = rd.Do("PUBLISH", s.channel(), e.Key())
+	return err
+}
+
+// Remove 删除条目
+func (s *RedisStore) Remove(kind Kind, value string) error {
+	key := (&Entry{Kind: kind, Value: value}).Key()
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	if _, err := rd.Do("HDEL", s.entriesKey(), key); err != nil {
+		return err
+	}
+	_, err := rd.Do("PUBLISH", s.channel(), key)
+	return err
+}
+
+// RemoveExpired 清理过期条目, 比较过期时间后删除, 避免删掉其它节点刚重新添加的条目
+func (s *RedisStore) RemoveExpired(e *Entry) error {
+	expireAt, err := e.ExpireAt.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	removed, err := redis.Bool(removeExpiredScript.Do(rd, s.entriesKey(), e.Key(), strings.Trim(string(expireAt), `"`)))
+	if err != nil || !removed {
+		return err
+	}
+	_, err = rd.Do("PUBLISH", s.channel(), e.Key())
+	return err
+}
+
+// List 所有条目
+func (s *RedisStore) List() ([]*Entry, error) {
+	vals, err := redis.ByteSlices(redigo.Do("HVALS", s.entriesKey()))
+	if err != nil && err != redis.ErrNil {
+		return nil, err
+	}
+	ret := make([]*Entry, 0, len(vals))
+	for _, v := range vals {
+		e := &Entry{}
+		if err := json.Unmarshal(v, e); err != nil {
+			logrus.WithField("module", "blocklist").Errorf("decode entry[%s]: %v", v, err)
+			continue
+		}
+		ret = append(ret, e)
+	}
+	return ret, nil
+}
+
+// Watch 订阅变更通知, 订阅断开后自动重连
+func (s *RedisStore) Watch(ctx context.Context) (<-chan struct{}, error) {
+	w := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case w <- struct{}{}:
+		default:
+		}
+	}
+
+	go func() {
+		defer close(w)
+		log := logrus.WithField("module", "blocklist")
+		for {
+			psc := redis.PubSubConn{Conn: redigo.GetRedis()}
+			if err := psc.Subscribe(s.channel()); err != nil {
+				log.Errorf("subscribe: %v", err)
+				psc.Close()
+			} else {
+				stop := make(chan struct{})
+				go func() {
+					select {
+					case <-ctx.Done():
+						psc.Unsubscribe()
+					case <-stop:
+					}
+				}()
+				// 重新订阅期间可能错过了通知, 订阅成功后刷新一次
+				notify()
+			loop:
+				for {
+					switch v := psc.Receive().(type) {
+					case redis.Message:
+						notify()
+					case redis.Subscription:
+						if v.Count == 0 {
+							break loop
+						}
+					case error:
+						log.Warnf("receive: %v", v)
+						break loop
+					}
+				}
+				close(stop)
+				psc.Close()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(3 * time.Second):
+			}
+		}
+	}()
+	return w, nil
+}
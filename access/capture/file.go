@@ -0,0 +1,232 @@
+package capture
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var captureCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_capture_frames_total",
+	Help: "captured frames by result(written/dropped/filtered)",
+}, []string{"result"})
+
+// FileSink 把报文写入按大小滚动的jsonl文件, 实现 driver.FrameSink
+// 写文件在后台协程中进行, 队列满时丢弃报文, 不阻塞报文处理
+//
+//	sink, err := capture.NewFileSink("/data/capture/ykc.jsonl", 100<<20, 10, capture.Filter{Marks: []string{"xx"}})
+//	pipeline.Intercept(interceptor.Capture(sink))
+//	defer sink.Close()
+type FileSink struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu     sync.RWMutex
+	filter *compiledFilter
+
+	ch      chan *driver.Frame
+	closing chan struct{} // Close时关闭, ch不关闭, 避免WriteFrame向已关闭的chan发送
+	once    sync.Once
+	done    chan struct{}
+	log     *logrus.Entry
+
+	file *os.File
+	buf  *bufio.Writer
+	size int64
+}
+
+// NewFileSink 创建文件抓包
+// maxSize 单个文件最大字节数, 超过后滚动为 path.时间戳; maxFiles 保留的历史文件数, <=0表示不清理
+func NewFileSink(path string, maxSize int64, maxFiles int, filter Filter) (*FileSink, error) {
+	s := &FileSink{
+		path:     path,
+		maxSize:  maxSize,
+		maxFiles: maxFiles,
+		filter:   filter.compile(),
+		ch:       make(chan *driver.Frame, 4096),
+		closing:  make(chan struct{}),
+		done:     make(chan struct{}),
+		log:      logrus.WithField("module", "capture").WithField("path", path),
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	go s.loop()
+	return s, nil
+}
+
+// SetFilter 运行中修改过滤条件
+func (s *FileSink) SetFilter(f Filter) {
+	cf := f.compile()
+	s.mu.Lock()
+	s.filter = cf
+	s.mu.Unlock()
+}
+
+// WriteFrame 写入报文, Close之后的报文丢弃
+func (s *FileSink) WriteFrame(f *driver.Frame) {
+	select {
+	case <-s.closing:
+		captureCounter.WithLabelValues("dropped").Inc()
+		return
+	default:
+	}
+	s.mu.RLock()
+	ok := s.filter.match(f)
+	s.mu.RUnlock()
+	if !ok {
+		captureCounter.WithLabelValues("filtered").Inc()
+		return
+	}
+	select {
+	case s.ch <- f:
+	default:
+		captureCounter.WithLabelValues("dropped").Inc()
+	}
+}
+
+// Close 写完队列中的报文后关闭文件, 可以重复调用
+func (s *FileSink) Close() error {
+	s.once.Do(func() { close(s.closing) })
+	<-s.done
+	return nil
+}
+
+func (s *FileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.buf, s.size = f, bufio.NewWriterSize(f, 64<<10), st.Size()
+	return nil
+}
+
+func (s *FileSink) closeFile() {
+	if s.file == nil {
+		return
+	}
+	s.buf.Flush()
+	s.file.Close()
+	s.file, s.buf = nil, nil
+}
+
+func (s *FileSink) rotate() error {
+	s.closeFile()
+	backup := fmt.Sprintf("%s.%s", s.path, time.Now().Format("20060102150405.000"))
+	if err := os.Rename(s.path, backup); err != nil {
+		return err
+	}
+	if s.maxFiles > 0 {
+		backups, _ := filepath.Glob(s.path + ".*")
+		sort.Strings(backups)
+		for len(backups) > s.maxFiles {
+			if err := os.Remove(backups[0]); err != nil {
+				s.log.Warnf("remove old capture: %v", err)
+			}
+			backups = backups[1:]
+		}
+	}
+	return s.open()
+}
+
+func (s *FileSink) write(f *driver.Frame) error {
+	b, err := json.Marshal(NewRecord(f))
+	if err != nil {
+		return err
+	}
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.maxSize > 0 && s.size+int64(len(b))+1 > s.maxSize && s.size > 0 {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := s.buf.Write(append(b, '\n'))
+	s.size += int64(n)
+	return err
+}
+
+func (s *FileSink) loop() {
+	defer close(s.done)
+	defer s.closeFile()
+
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case f := <-s.ch:
+			s.writeFrame(f)
+		case <-s.closing:
+			for {
+				select {
+				case f := <-s.ch:
+					s.writeFrame(f)
+				default:
+					return
+				}
+			}
+		case <-t.C:
+			if s.buf != nil {
+				s.buf.Flush()
+			}
+		}
+	}
+}
+
+func (s *FileSink) writeFrame(f *driver.Frame) {
+	if err := s.write(f); err != nil {
+		captureCounter.WithLabelValues("dropped").Inc()
+		s.log.Errorf("write: %v", err)
+		return
+	}
+	captureCounter.WithLabelValues("written").Inc()
+}
+
+// ReadFile 读取抓包文件, 按文件中的顺序回调, f返回错误时停止
+func ReadFile(path string, f func(r *Record) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" {
+			continue
+		}
+		r := &Record{}
+		if err := json.Unmarshal([]byte(text), r); err != nil {
+			return fmt.Errorf("capture: %s line %d: %w", path, line, err)
+		}
+		if err := f(r); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
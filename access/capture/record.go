@@ -0,0 +1,129 @@
+package capture
+
+import (
+	"encoding/hex"
+	"net"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Record 抓包文件中的一条记录(jsonl的一行)
+type Record struct {
+	Time   time.Time `json:"time"`
+	Mark   string    `json:"mark"`
+	Driver string    `json:"driver,omitempty"`
+	Remote string    `json:"remote,omitempty"`
+	Cmd    string    `json:"cmd,omitempty"`
+	Dir    string    `json:"dir"`  // in/out
+	Data   string    `json:"data"` // hex
+}
+
+// NewRecord 报文转成记录
+func NewRecord(f *driver.Frame) *Record {
+	return &Record{
+		Time:   f.Time,
+		Mark:   f.Mark,
+		Driver: f.Driver,
+		Remote: f.Remote,
+		Cmd:    f.Cmd,
+		Dir:    f.Dir.String(),
+		Data:   hex.EncodeToString(f.Data),
+	}
+}
+
+// Frame 记录转成报文
+func (r *Record) Frame() (*driver.Frame, error) {
+	data, err := hex.DecodeString(r.Data)
+	if err != nil {
+		return nil, err
+	}
+	f := &driver.Frame{
+		Time:   r.Time,
+		Mark:   r.Mark,
+		Driver: r.Driver,
+		Remote: r.Remote,
+		Cmd:    r.Cmd,
+		Data:   data,
+	}
+	switch r.Dir {
+	case driver.DirIn.String():
+		f.Dir = driver.DirIn
+	case driver.DirOut.String():
+		f.Dir = driver.DirOut
+	}
+	return f, nil
+}
+
+// Filter 抓包过滤, 各条件为空表示不限制, 多个条件同时满足才抓取
+type Filter struct {
+	Marks []string // 设备标记
+	IPs   []string // ip或网段(cidr)
+	Cmds  []string // 命令码, 只对上行报文生效(应答报文没有命令码)
+}
+
+type compiledFilter struct {
+	marks map[string]struct{}
+	ips   map[string]struct{}
+	nets  []*net.IPNet
+	cmds  map[string]struct{}
+}
+
+func toSet(vs []string) map[string]struct{} {
+	if len(vs) == 0 {
+		return nil
+	}
+	m := make(map[string]struct{}, len(vs))
+	for _, v := range vs {
+		m[v] = struct{}{}
+	}
+	return m
+}
+
+func (f Filter) compile() *compiledFilter {
+	cf := &compiledFilter{marks: toSet(f.Marks), cmds: toSet(f.Cmds)}
+	for _, v := range f.IPs {
+		if _, n, err := net.ParseCIDR(v); err == nil {
+			cf.nets = append(cf.nets, n)
+		} else if ip := net.ParseIP(v); ip != nil {
+			if cf.ips == nil {
+				cf.ips = make(map[string]struct{})
+			}
+			cf.ips[ip.String()] = struct{}{}
+		}
+	}
+	return cf
+}
+
+func (cf *compiledFilter) match(f *driver.Frame) bool {
+	if cf.marks != nil {
+		if _, ok := cf.marks[f.Mark]; !ok {
+			return false
+		}
+	}
+	if cf.cmds != nil && f.Dir == driver.DirIn {
+		if _, ok := cf.cmds[f.Cmd]; !ok {
+			return false
+		}
+	}
+	if cf.ips == nil && cf.nets == nil {
+		return true
+	}
+	host := f.Remote
+	if h, _, err := net.SplitHostPort(f.Remote); err == nil {
+		host = h
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	if _, ok := cf.ips[ip.String()]; ok {
+		return true
+	}
+	for _, n := range cf.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package capture
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Result 一条上行报文的回放结果
+type Result struct {
+	In       *driver.Frame // 回放的上行报文
+	Expected [][]byte      // 抓包中该报文之后记录的下行报文
+	Got      [][]byte      // 回放时协议返回的rets
+	Sent     [][]byte      // 回放时协议通过注入的驱动Send的报文
+	Tos      int           // 回放时协议返回的tos数
+	Err      error         // 协议返回的错误
+}
+
+// Output 回放产生的下行报文: rets在前, 翻译过程中Send的报文在后
+func (r *Result) Output() [][]byte {
+	out := make([][]byte, 0, len(r.Got)+len(r.Sent))
+	return append(append(out, r.Got...), r.Sent...)
+}
+
+// Match 回放产生的下行报文(rets和Send的报文)和抓包一致
+func (r *Result) Match() bool {
+	out := r.Output()
+	if len(r.Expected) != len(out) {
+		return false
+	}
+	for i := range r.Expected {
+		if !bytes.Equal(r.Expected[i], out[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replayer 把抓包文件中的上行报文按原始时序送入接入链路, 并对比产生的下行报文和抓包中记录的下行报文
+// 回放使用 access.Pipeline 组装: 接入端和转发端都是记录报文的回放驱动, 协议和拦截器的注入方式和线上一致
+//
+//	p, _ := access.NewProtocol("ykc", nil)
+//	r := capture.NewReplayer("ykc", p).Intercept(interceptor.Recovery()).SetSpeed(10)
+//	total, diff, err := r.Replay(ctx, "ykc.jsonl", func(res *capture.Result) {...})
+type Replayer struct {
+	pipeline *access.Pipeline
+	speed    float64
+	rec      *recorder
+	listen   *replayDriver
+}
+
+// NewReplayer 创建回放, driverName 为链路名称(Ctx.DriverName)
+func NewReplayer(driverName string, p driver.Protocol) *Replayer {
+	rec := &recorder{online: make(map[string]struct{})}
+	r := &Replayer{
+		speed:  1,
+		rec:    rec,
+		listen: &replayDriver{rec: rec},
+	}
+	r.pipeline = access.NewPipeline(driverName).Listen(r.listen).Protocol(p).Forward(&replayDriver{rec: rec})
+	return r
+}
+
+// SetSpeed 回放速度倍数, 1为原始速度, <=0表示不等待
+func (r *Replayer) SetSpeed(speed float64) *Replayer {
+	r.speed = speed
+	return r
+}
+
+// Intercept 回放时给协议加上拦截器, 和线上链路的拦截器保持一致
+func (r *Replayer) Intercept(ints ...driver.TranslateInterceptor) *Replayer {
+	r.pipeline.Intercept(ints...)
+	return r
+}
+
+// Route 协议需要的额外转发驱动(ProtoBase.GetDrivers()[name]), Send的报文同样记录
+func (r *Replayer) Route(names ...string) *Replayer {
+	for _, name := range names {
+		r.pipeline.Route(name, &replayDriver{rec: r.rec})
+	}
+	return r
+}
+
+// Load 读取抓包文件, 把每条上行报文和其后同一设备的下行报文组合成待回放的结果
+func Load(path string) ([]*Result, error) {
+	var (
+		results []*Result
+		last    = make(map[string]*Result)
+	)
+	err := ReadFile(path, func(rec *Record) error {
+		f, err := rec.Frame()
+		if err != nil {
+			return fmt.Errorf("capture: decode frame at %s: %w", rec.Time, err)
+		}
+		switch f.Dir {
+		case driver.DirIn:
+			res := &Result{In: f}
+			results = append(results, res)
+			last[f.Mark] = res
+		case driver.DirOut:
+			// 第一条上行之前的下行报文(平台主动下发)不参与对比
+			if res, ok := last[f.Mark]; ok {
+				res.Expected = append(res.Expected, f.Data)
+			}
+		}
+		return nil
+	})
+	return results, err
+}
+
+// Replay 回放抓包文件, 每条上行报文回放后回调f
+// 返回回放的报文数和应答不一致的报文数
+func (r *Replayer) Replay(ctx context.Context, path string, f func(res *Result)) (total, mismatched int, err error) {
+	results, err := Load(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(results) == 0 {
+		return 0, 0, nil
+	}
+	if err = r.pipeline.Run(); err != nil {
+		return 0, 0, err
+	}
+	defer r.pipeline.Stop()
+
+	start, origin := time.Now(), results[0].In.Time
+	for _, res := range results {
+		if r.speed > 0 {
+			offset := time.Duration(float64(res.In.Time.Sub(origin)) / r.speed)
+			if d := time.Until(start.Add(offset)); d > 0 {
+				select {
+				case <-ctx.Done():
+					return total, mismatched, ctx.Err()
+				case <-time.After(d):
+				}
+			}
+		} else if err := ctx.Err(); err != nil {
+			return total, mismatched, err
+		}
+
+		r.translate(res)
+		total++
+		if !res.Match() {
+			mismatched++
+		}
+		if f != nil {
+			f(res)
+		}
+	}
+	return total, mismatched, nil
+}
+
+func (r *Replayer) translate(res *Result) {
+	acctx := driver.NewACCtx(res.In.Mark, r.listen.GetDriverName(), res.In.Data)
+	driver.KeyRemote.Set(acctx, res.In.Remote)
+	r.rec.begin(res.In.Mark)
+
+	defer func() {
+		if e := recover(); e != nil {
+			res.Err = fmt.Errorf("capture: protocol panic: %v", e)
+		}
+		res.Sent = r.rec.end()
+	}()
+
+	// 和接入端驱动一样, 使用链路注入的协议(带拦截器)翻译
+	p, ok := r.listen.GetTranslate().(driver.Protocol)
+	if !ok {
+		res.Err = errors.New("capture: no protocol injected")
+		return
+	}
+	tos, rets, err := p.Translate(driver.NewContext(context.Background(), acctx))
+	if aerr := acctx.RunAfterFuncs(); err == nil {
+		err = aerr
+	}
+	res.Tos, res.Err = len(tos), err
+	for _, ret := range rets {
+		res.Got = append(res.Got, driver.MsgBytes(ret)...)
+	}
+}
+
+// recorder 回放驱动共享的记录, 接入端/转发端/额外转发驱动Send的报文按顺序记录
+type recorder struct {
+	mu     sync.Mutex
+	online map[string]struct{}
+	sent   [][]byte
+}
+
+func (r *recorder) begin(mark string) {
+	r.mu.Lock()
+	r.online[mark] = struct{}{}
+	r.sent = nil
+	r.mu.Unlock()
+}
+
+func (r *recorder) end() (sent [][]byte) {
+	r.mu.Lock()
+	sent, r.sent = r.sent, nil
+	r.mu.Unlock()
+	return
+}
+
+// replayDriver 回放驱动, 作为接入端时保存链路注入的协议, Send只记录报文
+type replayDriver struct {
+	driver.NDBase
+	rec *recorder
+}
+
+func (d *replayDriver) Run() error { return nil }
+func (d *replayDriver) Stop()      {}
+
+func (d *replayDriver) CheckOnline(mark string) bool {
+	d.rec.mu.Lock()
+	_, ok := d.rec.online[mark]
+	d.rec.mu.Unlock()
+	return ok
+}
+
+func (d *replayDriver) Disconnector(mark, reason string) {
+	d.rec.mu.Lock()
+	delete(d.rec.online, mark)
+	d.rec.mu.Unlock()
+}
+
+func (d *replayDriver) Send(msg driver.Msg) error {
+	d.rec.mu.Lock()
+	d.rec.sent = append(d.rec.sent, driver.MsgBytes(msg)...)
+	d.rec.mu.Unlock()
+	return nil
+}
+
+func (d *replayDriver) TraficSize(mark string) (recv, send int32, err error) {
+	return 0, 0, errors.New("capture: replay driver has no trafic")
+}
+
+func (d *replayDriver) Debug(t string) {}
@@ -0,0 +1,337 @@
+package charging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var transitionCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_charging_transitions_total",
+	Help: "charging state machine triggers by machine/trigger/result",
+}, []string{"machine", "trigger", "result"})
+
+// Event 状态变化事件
+type Event struct {
+	Mark    string    `json:"mark"`
+	Gun     int       `json:"gun"`
+	TxID    string    `json:"tx_id"`
+	From    State     `json:"from"`
+	To      State     `json:"to"`
+	Trigger Trigger   `json:"trigger"`
+	Reason  string    `json:"reason,omitempty"`
+	Energy  float64   `json:"energy"`
+	Time    time.Time `json:"time"`
+}
+
+// Option 触发参数
+type Option func(in *input)
+
+type input struct {
+	txID   string
+	energy *float64
+	reason string
+}
+
+// WithTxID 交易号, 进入充电流程时记录, 之后的触发交易号不一致时返回 ErrTxMismatch
+func WithTxID(id string) Option {
+	return func(in *input) { in.txID = id }
+}
+
+// WithEnergy 已充电量(kWh)
+func WithEnergy(kwh float64) Option {
+	return func(in *input) { in.energy = &kwh }
+}
+
+// WithReason 原因, 记录到事件中(如停止原因/鉴权失败原因)
+func WithReason(reason string) Option {
+	return func(in *input) { in.reason = reason }
+}
+
+// Machine 充电流程状态机, 每把枪(Ctx.Mark+枪号)一个状态
+// 协议解析报文后调用 Fire, 根据返回的错误决定应答(如重复的账单只应答不转发)
+//
+//	m := charging.New("ykc", charging.NewRedisStore("")).OnEvent(publish)
+//	go m.Run(ctx)
+//
+//	// 协议中
+//	if _, err := m.FireCtx(acctx, gun, charging.Bill, charging.WithTxID(tx)); errors.Is(err, charging.ErrDuplicate) {
+//		return nil, rets, nil
+//	}
+type Machine struct {
+	name     string
+	store    Store
+	table    Transitions
+	timeouts map[State]time.Duration
+	interval time.Duration
+	log      *logrus.Entry
+
+	locks    sync.Map // mark/gun -> *sync.Mutex
+	mu       sync.RWMutex
+	handlers []func(Event)
+}
+
+// New 创建状态机, name用于指标标签
+// 默认超时: 鉴权60s, 鉴权通过后等待启动5m, 启动确认60s, 停止确认60s, 等待账单30m
+func New(name string, s Store) *Machine {
+	return &Machine{
+		name:  name,
+		store: s,
+		table: DefaultTransitions(),
+		timeouts: map[State]time.Duration{
+			StateAuthorizing: time.Minute,
+			StateAuthorized:  5 * time.Minute,
+			StateStarting:    time.Minute,
+			StateStopping:    time.Minute,
+			StateStopped:     30 * time.Minute,
+		},
+		interval: time.Second,
+		log:      logrus.WithField("module", "charging").WithField("machine", name),
+	}
+}
+
+// SetTransitions 替换状态转换表
+func (m *Machine) SetTransitions(t Transitions) *Machine {
+	m.table = t
+	return m
+}
+
+// Allow 增加一条状态转换
+func (m *Machine) Allow(from State, t Trigger, to State) *Machine {
+	if m.table[from] == nil {
+		m.table[from] = make(map[Trigger]State)
+	}
+	m.table[from][t] = to
+	return m
+}
+
+// SetTimeout 状态超时时间, <=0表示不超时
+// 充电状态设置超时时, 超过该时间没有 Meter 则认为已停止
+func (m *Machine) SetTimeout(s State, d time.Duration) *Machine {
+	if d <= 0 {
+		delete(m.timeouts, s)
+	} else {
+		m.timeouts[s] = d
+	}
+	return m
+}
+
+// SetInterval 扫描超时的间隔
+func (m *Machine) SetInterval(d time.Duration) *Machine {
+	m.interval = d
+	return m
+}
+
+// OnEvent 注册状态变化回调, 回调在 Fire 的协程中同步执行
+// 只有状态变化时回调, Meter 等不改变状态的触发不回调
+func (m *Machine) OnEvent(f func(Event)) *Machine {
+	m.mu.Lock()
+	m.handlers = append(m.handlers, f)
+	m.mu.Unlock()
+	return m
+}
+
+func (m *Machine) lock(mark string, gun int) func() {
+	v, _ := m.locks.LoadOrStore(member(mark, gun), &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+// Get 获取枪状态, 不存在时返回空闲状态
+func (m *Machine) Get(mark string, gun int) (*Gun, error) {
+	g, err := m.store.Load(mark, gun)
+	if err != nil || g != nil {
+		return g, err
+	}
+	return &Gun{Mark: mark, Gun: gun, State: StateIdle}, nil
+}
+
+// List 设备所有枪的状态
+func (m *Machine) List(mark string) ([]*Gun, error) {
+	return m.store.List(mark)
+}
+
+// FireCtx 同 Fire, 设备标记取 acctx.Mark, 错误记录到上下文日志
+func (m *Machine) FireCtx(acctx *driver.Ctx, gun int, t Trigger, opts ...Option) (*Gun, error) {
+	g, err := m.Fire(acctx.Mark, gun, t, opts...)
+	if err != nil {
+		acctx.Log.WithField("gun", gun).WithField("trigger", t).Warnf("charging: %v", err)
+	}
+	return g, err
+}
+
+// Fire 触发状态转换, 返回转换后的枪状态
+// 当前状态不允许该触发时: 本次充电已经处理过返回 ErrDuplicate, 否则返回 ErrTransition
+// 插枪/拔枪不引起状态变化时只更新插枪标记
+// 多个节点共享存储时, 保存冲突(ErrConflict)会重新加载状态后重试
+func (m *Machine) Fire(mark string, gun int, t Trigger, opts ...Option) (*Gun, error) {
+	in := &input{}
+	for _, opt := range opts {
+		opt(in)
+	}
+
+	unlock := m.lock(mark, gun)
+	defer unlock()
+
+	for i := 1; ; i++ {
+		g, ev, err := m.fire(mark, gun, t, in)
+		if errors.Is(err, ErrConflict) && i < saveRetries {
+			continue
+		}
+		if ev != nil {
+			m.emit(*ev)
+		}
+		return g, err
+	}
+}
+
+// saveRetries 保存冲突时最多尝试的次数
+const saveRetries = 3
+
+// fire 加载状态并转换, 状态变化时返回事件
+func (m *Machine) fire(mark string, gun int, t Trigger, in *input) (*Gun, *Event, error) {
+	g, err := m.Get(mark, gun)
+	if err != nil {
+		transitionCounter.WithLabelValues(m.name, string(t), "error").Inc()
+		return nil, nil, err
+	}
+	now := time.Now()
+	from, prev := g.State, g.UpdatedAt
+
+	var to State
+	switch t {
+	case Reset:
+		to = StateIdle
+	case Timeout:
+		// 超时扫描和触发之间状态可能已经变化(包括其它节点已经处理)
+		if g.Deadline.IsZero() || g.Deadline.After(now) {
+			return g, nil, nil
+		}
+		fallthrough
+	default:
+		var ok bool
+		if to, ok = m.table[from][t]; !ok {
+			switch t {
+			case Plug, Unplug:
+				g.Plugged = t == Plug
+				g.UpdatedAt = now
+				return g, nil, m.save(g, prev, t)
+			case Timeout: // 设置了超时但转换表中没有超时转换
+				g.Deadline = time.Time{}
+				g.UpdatedAt = now
+				return g, nil, m.save(g, prev, t)
+			}
+			result, err := "invalid", ErrTransition
+			if g.fired(t) {
+				result, err = "duplicate", ErrDuplicate
+			}
+			transitionCounter.WithLabelValues(m.name, string(t), result).Inc()
+			return g, nil, fmt.Errorf("%w: [%s] %s on %s", err, member(mark, gun), t, from)
+		}
+	}
+
+	if in.txID != "" && g.TxID != "" && in.txID != g.TxID && !from.rest() {
+		transitionCounter.WithLabelValues(m.name, string(t), "tx_mismatch").Inc()
+		return g, nil, fmt.Errorf("%w: [%s] %s tx[%s], current tx[%s]", ErrTxMismatch, member(mark, gun), t, in.txID, g.TxID)
+	}
+
+	if from.rest() && !to.rest() { // 开始新的充电
+		g.TxID, g.Energy, g.Fired = "", 0, nil
+		g.StartedAt, g.StoppedAt = time.Time{}, time.Time{}
+	}
+	if in.txID != "" {
+		g.TxID = in.txID
+	}
+	if in.energy != nil {
+		g.Energy = *in.energy
+	}
+	switch t {
+	case Plug:
+		g.Plugged = true
+	case Unplug:
+		g.Plugged = false
+	case StartOK:
+		g.StartedAt = now
+	case Stopped, Bill:
+		if g.StoppedAt.IsZero() {
+			g.StoppedAt = now
+		}
+	}
+	if to == StateIdle && g.Plugged {
+		to = StatePlugged
+	}
+	if t.once() && !g.fired(t) {
+		g.Fired = append(g.Fired, t)
+	}
+	if to != from {
+		g.Since = now
+	}
+	g.State, g.Trigger, g.UpdatedAt = to, t, now
+	g.Deadline = time.Time{}
+	if d, ok := m.timeouts[to]; ok {
+		g.Deadline = now.Add(d)
+	}
+
+	if err = m.save(g, prev, t); err != nil {
+		return g, nil, err
+	}
+	if to == from {
+		return g, nil, nil
+	}
+	return g, &Event{Mark: mark, Gun: gun, TxID: g.TxID, From: from, To: to, Trigger: t, Reason: in.reason, Energy: g.Energy, Time: now}, nil
+}
+
+func (m *Machine) save(g *Gun, prev time.Time, t Trigger) error {
+	if err := m.store.Save(g, prev); err != nil {
+		result := "error"
+		if errors.Is(err, ErrConflict) {
+			result = "conflict"
+		}
+		transitionCounter.WithLabelValues(m.name, string(t), result).Inc()
+		return fmt.Errorf("charging: save [%s]: %w", member(g.Mark, g.Gun), err)
+	}
+	transitionCounter.WithLabelValues(m.name, string(t), "ok").Inc()
+	return nil
+}
+
+func (m *Machine) emit(ev Event) {
+	m.mu.RLock()
+	handlers := m.handlers
+	m.mu.RUnlock()
+	for _, f := range handlers {
+		f(ev)
+	}
+}
+
+// Run 定时扫描超时的枪并触发 Timeout, ctx结束后退出
+// 多个节点可以同时运行, 同一把枪的超时只有一个节点保存成功, 其它节点重新加载后发现已经处理过
+func (m *Machine) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		guns, err := m.store.Expired(time.Now(), 100)
+		if err != nil {
+			m.log.Errorf("load expired: %v", err)
+			continue
+		}
+		for _, g := range guns {
+			if _, err := m.Fire(g.Mark, g.Gun, Timeout, WithReason(fmt.Sprintf("%s timeout", g.State))); err != nil {
+				m.log.WithField("mark", g.Mark).WithField("gun", g.Gun).Warnf("timeout: %v", err)
+			}
+		}
+	}
+}
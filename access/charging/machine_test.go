@@ -0,0 +1,258 @@
+package charging
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type step struct {
+	t         Trigger
+	opts      []Option
+	wantState State
+	wantErr   error
+}
+
+func TestFire(t *testing.T) {
+	cases := []struct {
+		name       string
+		steps      []step
+		wantEvents int
+	}{
+		{
+			name: "full flow",
+			steps: []step{
+				{t: Plug, wantState: StatePlugged},
+				{t: Authorize, wantState: StateAuthorizing},
+				{t: AuthOK, wantState: StateAuthorized},
+				{t: Start, opts: []Option{WithTxID("tx1")}, wantState: StateStarting},
+				{t: StartOK, wantState: StateCharging},
+				{t: Meter, opts: []Option{WithEnergy(1.5)}, wantState: StateCharging},
+				{t: Stop, wantState: StateStopping},
+				{t: Stopped, wantState: StateStopped},
+				{t: Bill, opts: []Option{WithTxID("tx1"), WithEnergy(2)}, wantState: StatePlugged},
+				{t: Unplug, wantState: StateIdle},
+			},
+			wantEvents: 9,
+		},
+		{
+			name: "local start without plug",
+			steps: []step{
+				{t: StartOK, opts: []Option{WithTxID("tx1")}, wantState: StateCharging},
+				{t: Bill, wantState: StateIdle},
+			},
+			wantEvents: 2,
+		},
+		{
+			name: "duplicate stop and bill",
+			steps: []step{
+				{t: Start, opts: []Option{WithTxID("tx1")}, wantState: StateStarting},
+				{t: StartOK, wantState: StateCharging},
+				{t: Stop, wantState: StateStopping},
+				{t: Stop, wantState: StateStopping, wantErr: ErrDuplicate},
+				{t: Bill, opts: []Option{WithTxID("tx1")}, wantState: StateIdle},
+				{t: Bill, opts: []Option{WithTxID("tx1")}, wantState: StateIdle, wantErr: ErrDuplicate},
+			},
+			wantEvents: 4,
+		},
+		{
+			name: "bill of previous charge",
+			steps: []step{
+				{t: Start, opts: []Option{WithTxID("tx2")}, wantState: StateStarting},
+				{t: StartOK, wantState: StateCharging},
+				{t: Bill, opts: []Option{WithTxID("tx1")}, wantState: StateCharging, wantErr: ErrTxMismatch},
+			},
+			wantEvents: 2,
+		},
+		{
+			name: "invalid transition",
+			steps: []step{
+				{t: Meter, wantState: StateIdle, wantErr: ErrTransition},
+				{t: AuthOK, wantState: StateIdle, wantErr: ErrTransition},
+			},
+		},
+		{
+			name: "plug during charging keeps state",
+			steps: []step{
+				{t: StartOK, wantState: StateCharging},
+				{t: Unplug, wantState: StateCharging},
+				{t: Plug, wantState: StateCharging},
+				{t: Bill, wantState: StatePlugged},
+			},
+			wantEvents: 2,
+		},
+		{
+			name: "reset from any state",
+			steps: []step{
+				{t: Plug, wantState: StatePlugged},
+				{t: StartOK, wantState: StateCharging},
+				{t: Reset, wantState: StatePlugged},
+			},
+			wantEvents: 3,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var evs []Event
+			m := New("test", NewMemoryStore()).OnEvent(func(e Event) { evs = append(evs, e) })
+			for i, s := range c.steps {
+				g, err := m.Fire("A", 1, s.t, s.opts...)
+				if s.wantErr == nil && err != nil || s.wantErr != nil && !errors.Is(err, s.wantErr) {
+					t.Fatalf("step %d %s: err = %v, want %v", i, s.t, err, s.wantErr)
+				}
+				if g.State != s.wantState {
+					t.Fatalf("step %d %s: state = %s, want %s", i, s.t, g.State, s.wantState)
+				}
+			}
+			if len(evs) != c.wantEvents {
+				t.Fatalf("events = %d, want %d (%+v)", len(evs), c.wantEvents, evs)
+			}
+		})
+	}
+}
+
+func TestNewChargeResets(t *testing.T) {
+	m := New("test", NewMemoryStore())
+	m.Fire("A", 1, StartOK, WithTxID("tx1"), WithEnergy(3))
+	m.Fire("A", 1, Bill)
+	g, err := m.Fire("A", 1, Start, WithTxID("tx2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g.TxID != "tx2" || g.Energy != 0 || len(g.Fired) != 1 || !g.StoppedAt.IsZero() {
+		t.Fatalf("new charge not reset: %+v", g)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	cases := []struct {
+		name      string
+		state     Trigger
+		plugged   bool
+		wait      time.Duration
+		wantState State
+		wantEvent bool
+	}{
+		{name: "starting timeout", state: Start, wait: 20 * time.Millisecond, wantState: StateIdle, wantEvent: true},
+		{name: "starting timeout plugged", state: Start, plugged: true, wait: 20 * time.Millisecond, wantState: StatePlugged, wantEvent: true},
+		{name: "not expired", state: Start, wantState: StateStarting},
+		{name: "no deadline", state: StartOK, wait: 20 * time.Millisecond, wantState: StateCharging},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			events := 0
+			m := New("test", NewMemoryStore()).SetTimeout(StateStarting, 10*time.Millisecond).SetTimeout(StateCharging, 0)
+			if c.plugged {
+				m.Fire("A", 1, Plug)
+			}
+			if _, err := m.Fire("A", 1, c.state); err != nil {
+				t.Fatal(err)
+			}
+			m.OnEvent(func(Event) { events++ })
+			time.Sleep(c.wait)
+			g, err := m.Fire("A", 1, Timeout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g.State != c.wantState {
+				t.Fatalf("state = %s, want %s", g.State, c.wantState)
+			}
+			if (events == 1) != c.wantEvent {
+				t.Fatalf("events = %d, want event %v", events, c.wantEvent)
+			}
+		})
+	}
+}
+
+func TestTimeoutOnce(t *testing.T) {
+	st := NewMemoryStore()
+	a := New("a", st).SetTimeout(StateAuthorizing, time.Millisecond)
+	b := New("b", st).SetTimeout(StateAuthorizing, time.Millisecond)
+	if _, err := a.Fire("A", 1, Authorize); err != nil {
+		t.Fatal(err)
+	}
+	events := 0
+	a.OnEvent(func(Event) { events++ })
+	b.OnEvent(func(Event) { events++ })
+	time.Sleep(5 * time.Millisecond)
+
+	// 两个节点扫描到同一把超时的枪
+	for _, m := range []*Machine{a, b} {
+		if _, err := m.Fire("A", 1, Timeout); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if events != 1 {
+		t.Fatalf("events = %d, want 1", events)
+	}
+}
+
+func TestMemoryStoreConflict(t *testing.T) {
+	t0 := time.Now()
+	t1 := t0.Add(time.Second)
+	cases := []struct {
+		name    string
+		exists  bool
+		prev    time.Time
+		wantErr error
+	}{
+		{name: "create", exists: false, prev: time.Time{}},
+		{name: "create over existing", exists: true, prev: time.Time{}, wantErr: ErrConflict},
+		{name: "update", exists: true, prev: t0},
+		{name: "stale update", exists: true, prev: t1, wantErr: ErrConflict},
+		{name: "update missing", exists: false, prev: t0, wantErr: ErrConflict},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			if c.exists {
+				if err := s.Save(&Gun{Mark: "A", Gun: 1, UpdatedAt: t0}, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Save(&Gun{Mark: "A", Gun: 1, UpdatedAt: t1}, c.prev); err != c.wantErr {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+// conflictStore 前n次保存时模拟其它节点已经修改
+type conflictStore struct {
+	*MemoryStore
+	n int
+}
+
+func (s *conflictStore) Save(g *Gun, prev time.Time) error {
+	if s.n > 0 {
+		s.n--
+		return ErrConflict
+	}
+	return s.MemoryStore.Save(g, prev)
+}
+
+func TestFireRetriesConflict(t *testing.T) {
+	cases := []struct {
+		name       string
+		conflicts  int
+		wantErr    error
+		wantEvents int
+	}{
+		{name: "no conflict", conflicts: 0, wantEvents: 1},
+		{name: "retry once", conflicts: 1, wantEvents: 1},
+		{name: "give up", conflicts: saveRetries, wantErr: ErrConflict},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			events := 0
+			m := New("test", &conflictStore{MemoryStore: NewMemoryStore(), n: c.conflicts}).OnEvent(func(Event) { events++ })
+			_, err := m.Fire("A", 1, StartOK)
+			if c.wantErr == nil && err != nil || c.wantErr != nil && !errors.Is(err, c.wantErr) {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+			if events != c.wantEvents {
+				t.Fatalf("events = %d, want %d", events, c.wantEvents)
+			}
+		})
+	}
+}
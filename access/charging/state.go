@@ -0,0 +1,124 @@
+package charging
+
+import "errors"
+
+var (
+	// ErrTransition 当前状态不允许该触发
+	ErrTransition = errors.New("charging: invalid transition")
+
+	// ErrDuplicate 本次充电已经处理过该触发(如重复的停止/账单)
+	ErrDuplicate = errors.New("charging: duplicate trigger")
+
+	// ErrTxMismatch 交易号和当前充电的交易号不一致(如上一次充电遗留的账单)
+	ErrTxMismatch = errors.New("charging: transaction mismatch")
+
+	// ErrConflict 保存时枪状态已经被修改(如其它节点同时触发), Fire 会重新加载后重试
+	ErrConflict = errors.New("charging: concurrent update")
+)
+
+// State 枪状态
+type State string
+
+const (
+	StateIdle        State = "idle"        // 空闲, 未插枪
+	StatePlugged     State = "plugged"     // 已插枪
+	StateAuthorizing State = "authorizing" // 鉴权中
+	StateAuthorized  State = "authorized"  // 鉴权通过, 等待启动
+	StateStarting    State = "starting"    // 已下发启动, 等待设备确认
+	StateCharging    State = "charging"    // 充电中
+	StateStopping    State = "stopping"    // 已下发停止, 等待设备确认
+	StateStopped     State = "stopped"     // 已停止, 等待账单
+)
+
+// rest 空闲状态, 不在充电流程中
+func (s State) rest() bool {
+	return s == StateIdle || s == StatePlugged
+}
+
+// Trigger 触发状态变化的事件, 由协议解析报文后调用 Machine.Fire
+type Trigger string
+
+const (
+	Plug      Trigger = "plug"       // 插枪
+	Unplug    Trigger = "unplug"     // 拔枪
+	Authorize Trigger = "authorize"  // 刷卡/扫码请求鉴权
+	AuthOK    Trigger = "auth_ok"    // 鉴权通过
+	AuthFail  Trigger = "auth_fail"  // 鉴权失败
+	Start     Trigger = "start"      // 下发启动
+	StartOK   Trigger = "start_ok"   // 设备确认启动(含设备本地启动)
+	StartFail Trigger = "start_fail" // 设备启动失败
+	Meter     Trigger = "meter"      // 充电实时数据
+	Stop      Trigger = "stop"       // 下发停止
+	Stopped   Trigger = "stopped"    // 设备确认停止(含设备自行停止)
+	Bill      Trigger = "bill"       // 设备上传账单, 充电结束
+	Timeout   Trigger = "timeout"    // 状态超时, 由 Machine.Run 触发
+	Reset     Trigger = "reset"      // 强制回到空闲(设备重启/人工处理), 任何状态都允许
+)
+
+// once 一次充电中只应该出现一次的触发, 再次出现为重复
+func (t Trigger) once() bool {
+	switch t {
+	case Plug, Unplug, Meter, Timeout, Reset:
+		return false
+	}
+	return true
+}
+
+// Transitions 状态转换表: 当前状态 -> 触发 -> 目标状态
+// 目标为idle时, 枪未拔出则为plugged
+type Transitions map[State]map[Trigger]State
+
+// DefaultTransitions 默认状态转换表
+// 兼容没有插枪/鉴权上报的协议: 空闲状态可以直接启动, 设备本地启动直接进入充电
+func DefaultTransitions() Transitions {
+	return Transitions{
+		StateIdle: {
+			Plug:      StatePlugged,
+			Authorize: StateAuthorizing,
+			Start:     StateStarting,
+			StartOK:   StateCharging,
+		},
+		StatePlugged: {
+			Unplug:    StateIdle,
+			Authorize: StateAuthorizing,
+			Start:     StateStarting,
+			StartOK:   StateCharging,
+		},
+		StateAuthorizing: {
+			AuthOK:   StateAuthorized,
+			AuthFail: StateIdle,
+			Unplug:   StateIdle,
+			Timeout:  StateIdle,
+		},
+		StateAuthorized: {
+			Start:   StateStarting,
+			StartOK: StateCharging,
+			Stop:    StateIdle,
+			Unplug:  StateIdle,
+			Timeout: StateIdle,
+		},
+		StateStarting: {
+			StartOK:   StateCharging,
+			StartFail: StateIdle,
+			Stop:      StateStopping,
+			Timeout:   StateIdle,
+		},
+		StateCharging: {
+			Meter:   StateCharging,
+			Stop:    StateStopping,
+			Stopped: StateStopped,
+			Bill:    StateIdle,
+			Timeout: StateStopped,
+		},
+		StateStopping: {
+			Meter:   StateStopping,
+			Stopped: StateStopped,
+			Bill:    StateIdle,
+			Timeout: StateStopped,
+		},
+		StateStopped: {
+			Bill:    StateIdle,
+			Timeout: StateIdle,
+		},
+	}
+}
@@ -0,0 +1,48 @@
+package charging
+
+import "time"
+
+// Gun 一把枪的充电状态
+type Gun struct {
+	Mark      string    `json:"mark"`
+	Gun       int       `json:"gun"`
+	State     State     `json:"state"`
+	Trigger   Trigger   `json:"trigger"`    // 进入当前状态的触发
+	Plugged   bool      `json:"plugged"`    // 是否插枪
+	TxID      string    `json:"tx_id"`      // 交易号/订单号
+	Energy    float64   `json:"energy"`     // 已充电量(kWh), 由 Meter/Bill 更新
+	Fired     []Trigger `json:"fired"`      // 本次充电已经处理的触发, 用于识别重复, 不含可重复的触发
+	Since     time.Time `json:"since"`      // 进入当前状态的时间
+	Deadline  time.Time `json:"deadline"`   // 当前状态的超时时间, 零值表示不超时
+	StartedAt time.Time `json:"started_at"` // 设备确认启动时间
+	StoppedAt time.Time `json:"stopped_at"` // 设备停止时间
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (g *Gun) fired(t Trigger) bool {
+	for _, v := range g.Fired {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Store 枪状态持久化
+type Store interface {
+	// Load 获取枪状态, 不存在时返回nil, nil
+	Load(mark string, gun int) (*Gun, error)
+
+	// Save 保存枪状态, 已保存的 UpdatedAt 和prev不一致时不保存, 返回 ErrConflict
+	// prev为零值表示枪状态还不存在, 多个节点共享存储时防止互相覆盖
+	Save(g *Gun, prev time.Time) error
+
+	// Delete 删除枪状态
+	Delete(mark string, gun int) error
+
+	// List 设备所有枪的状态
+	List(mark string) ([]*Gun, error)
+
+	// Expired 超时时间(Deadline)<=now的枪, 最多limit个
+	Expired(now time.Time, limit int) ([]*Gun, error)
+}
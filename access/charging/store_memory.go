@@ -0,0 +1,94 @@
+package charging
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存存储, 进程重启后状态丢失, 用于测试和单机部署
+type MemoryStore struct {
+	mu   sync.Mutex
+	guns map[string]map[int]*Gun // mark -> gun -> state
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{guns: make(map[string]map[int]*Gun)}
+}
+
+// Load 获取枪状态
+func (s *MemoryStore) Load(mark string, gun int) (*Gun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.guns[mark][gun]; ok {
+		return copyGun(g), nil
+	}
+	return nil, nil
+}
+
+// Save 保存枪状态
+func (s *MemoryStore) Save(g *Gun, prev time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	guns, ok := s.guns[g.Mark]
+	if !ok {
+		guns = make(map[int]*Gun)
+		s.guns[g.Mark] = guns
+	}
+	if cur, ok := guns[g.Gun]; ok && !cur.UpdatedAt.Equal(prev) || !ok && !prev.IsZero() {
+		return ErrConflict
+	}
+	guns[g.Gun] = copyGun(g)
+	return nil
+}
+
+// Delete 删除枪状态
+func (s *MemoryStore) Delete(mark string, gun int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if guns, ok := s.guns[mark]; ok {
+		delete(guns, gun)
+		if len(guns) == 0 {
+			delete(s.guns, mark)
+		}
+	}
+	return nil
+}
+
+// List 设备所有枪的状态, 按枪号排序
+func (s *MemoryStore) List(mark string) ([]*Gun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*Gun, 0, len(s.guns[mark]))
+	for _, g := range s.guns[mark] {
+		ret = append(ret, copyGun(g))
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Gun < ret[j].Gun })
+	return ret, nil
+}
+
+// Expired 超时的枪
+func (s *MemoryStore) Expired(now time.Time, limit int) ([]*Gun, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*Gun, 0)
+	for _, guns := range s.guns {
+		for _, g := range guns {
+			if !g.Deadline.IsZero() && !g.Deadline.After(now) {
+				ret = append(ret, copyGun(g))
+			}
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Deadline.Before(ret[j].Deadline) })
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+func copyGun(g *Gun) *Gun {
+	cp := *g
+	cp.Fired = append([]Trigger(nil), g.Fired...)
+	return &cp
+}
@@ -0,0 +1,172 @@
+package charging
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// KEYS: guns deadlines
+// ARGV: gun state member deadline(毫秒, 0表示不超时) prev(updated_at, 空表示不存在)
+var saveScript = redis.NewScript(2, `
+local cur = redis.call('HGET', KEYS[1], ARGV[1])
+if cur then
+	if cjson.decode(cur)['updated_at'] ~= ARGV[5] then
+		return 0
+	end
+elseif ARGV[5] ~= '' then
+	return 0
+end
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+if ARGV[4] == '0' then
+	redis.call('ZREM', KEYS[2], ARGV[3])
+else
+	redis.call('ZADD', KEYS[2], ARGV[4], ARGV[3])
+end
+return 1
+`)
+
+// RedisStore redis存储, 使用 redigo 连接池
+//
+//	{prefix}:{mark}       hash  gun -> state json
+//	{prefix}:deadlines    zset  {mark}/{gun} -> Deadline(毫秒)
+type RedisStore struct {
+	prefix string
+}
+
+// NewRedisStore 创建redis存储, prefix为空时使用 access:charging
+func NewRedisStore(prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "access:charging"
+	}
+	return &RedisStore{prefix: prefix}
+}
+
+func (s *RedisStore) key(mark string) string {
+	return s.prefix + ":" + mark
+}
+
+func (s *RedisStore) deadlines() string {
+	return s.prefix + ":deadlines"
+}
+
+func member(mark string, gun int) string {
+	return fmt.Sprintf("%s/%d", mark, gun)
+}
+
+func parseMember(m string) (mark string, gun int, err error) {
+	idx := strings.LastIndexByte(m, '/')
+	if idx < 0 {
+		return "", 0, fmt.Errorf("charging: invalid deadline member [%s]", m)
+	}
+	gun, err = strconv.Atoi(m[idx+1:])
+	return m[:idx], gun, err
+}
+
+// Load 获取枪状态
+func (s *RedisStore) Load(mark string, gun int) (*Gun, error) {
+	b, err := redis.Bytes(redigo.Do("HGET", s.key(mark), gun))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	g := &Gun{}
+	if err = json.Unmarshal(b, g); err != nil {
+		return nil, fmt.Errorf("charging: decode gun[%s]: %w", member(mark, gun), err)
+	}
+	return g, nil
+}
+
+// Save 保存枪状态, 和已保存的 updated_at 比较后写入
+func (s *RedisStore) Save(g *Gun, prev time.Time) error {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	var deadline int64
+	if !g.Deadline.IsZero() {
+		deadline = g.Deadline.UnixNano() / int64(time.Millisecond)
+	}
+	var updatedAt string
+	if !prev.IsZero() {
+		pb, err := prev.MarshalJSON()
+		if err != nil {
+			return err
+		}
+		updatedAt = strings.Trim(string(pb), `"`)
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	saved, err := redis.Bool(saveScript.Do(rd, s.key(g.Mark), s.deadlines(), g.Gun, b, member(g.Mark, g.Gun), deadline, updatedAt))
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Delete 删除枪状态
+func (s *RedisStore) Delete(mark string, gun int) error {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	rd.Send("MULTI")
+	rd.Send("HDEL", s.key(mark), gun)
+	rd.Send("ZREM", s.deadlines(), member(mark, gun))
+	_, err := rd.Do("EXEC")
+	return err
+}
+
+// List 设备所有枪的状态
+func (s *RedisStore) List(mark string) ([]*Gun, error) {
+	vals, err := redis.ByteSlices(redigo.Do("HVALS", s.key(mark)))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Gun, 0, len(vals))
+	for _, v := range vals {
+		g := &Gun{}
+		if err := json.Unmarshal(v, g); err != nil {
+			return nil, fmt.Errorf("charging: decode gun of [%s]: %w", mark, err)
+		}
+		ret = append(ret, g)
+	}
+	return ret, nil
+}
+
+// Expired 超时的枪
+func (s *RedisStore) Expired(now time.Time, limit int) ([]*Gun, error) {
+	args := redis.Args{}.Add(s.deadlines(), "-inf", now.UnixNano()/int64(time.Millisecond))
+	if limit > 0 {
+		args = args.Add("LIMIT", 0, limit)
+	}
+	members, err := redis.Strings(redigo.Do("ZRANGEBYSCORE", args...))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Gun, 0, len(members))
+	for _, m := range members {
+		mark, gun, err := parseMember(m)
+		if err != nil {
+			redigo.Do("ZREM", s.deadlines(), m)
+			continue
+		}
+		g, err := s.Load(mark, gun)
+		if err != nil {
+			return nil, err
+		}
+		if g == nil { // 数据不一致, 清理掉
+			redigo.Do("ZREM", s.deadlines(), m)
+			continue
+		}
+		ret = append(ret, g)
+	}
+	return ret, nil
+}
@@ -0,0 +1,190 @@
+package cipher
+
+import (
+	"bytes"
+	"crypto/aes"
+	stdcipher "crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrPadding 解密后填充错误(密钥不对或报文损坏)
+	ErrPadding = errors.New("cipher: invalid padding")
+	// ErrBlockSize 密文长度不是分组长度的整数倍
+	ErrBlockSize = errors.New("cipher: input not multiple of block size")
+	// ErrNoSession 密钥交换得到了密钥, 但 Ctx 中没有会话可以保存
+	ErrNoSession = errors.New("cipher: no session in ctx to store the key")
+	// ErrPlaintext 需要加密的命令收到了标志位为明文的报文(降级攻击)
+	ErrPlaintext = errors.New("cipher: plaintext frame for encrypted cmd")
+)
+
+// Algorithm 分组算法
+type Algorithm string
+
+const (
+	AES Algorithm = "aes" // 密钥16/24/32字节
+	SM4 Algorithm = "sm4" // 密钥16字节
+)
+
+// Mode 分组模式
+type Mode string
+
+const (
+	ECB Mode = "ecb"
+	CBC Mode = "cbc"
+)
+
+// Padding 填充方式
+type Padding string
+
+const (
+	PKCS7       Padding = "pkcs7"
+	ZeroPadding Padding = "zero" // 补0, 解密时去掉末尾的0
+	NoPadding   Padding = "none" // 明文需要是分组长度的整数倍
+)
+
+// Suite 加密套件
+// CBC模式下IV为空时, 每次加密随机生成IV并放在密文前面, 解密时从密文前面取IV
+type Suite struct {
+	Algorithm Algorithm
+	Mode      Mode
+	Padding   Padding
+	IV        []byte
+}
+
+// Cipher 对报文负载加解密
+type Cipher interface {
+	Encrypt(plain []byte) ([]byte, error)
+	Decrypt(data []byte) ([]byte, error)
+}
+
+// New 用密钥创建加解密
+func (s Suite) New(key []byte) (Cipher, error) {
+	var (
+		block stdcipher.Block
+		err   error
+	)
+	switch s.Algorithm {
+	case AES:
+		block, err = aes.NewCipher(key)
+	case SM4:
+		block, err = NewSM4(key)
+	default:
+		return nil, fmt.Errorf("cipher: unknown algorithm %q", s.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch s.Mode {
+	case ECB, CBC:
+	default:
+		return nil, fmt.Errorf("cipher: unknown mode %q", s.Mode)
+	}
+	if s.Mode == CBC && s.IV != nil && len(s.IV) != block.BlockSize() {
+		return nil, fmt.Errorf("cipher: iv length %d, want %d", len(s.IV), block.BlockSize())
+	}
+	padding := s.Padding
+	if padding == "" {
+		padding = PKCS7
+	}
+	return &blockCipher{block: block, mode: s.Mode, padding: padding, iv: s.IV}, nil
+}
+
+type blockCipher struct {
+	block   stdcipher.Block
+	mode    Mode
+	padding Padding
+	iv      []byte
+}
+
+func (c *blockCipher) pad(b []byte) ([]byte, error) {
+	bs := c.block.BlockSize()
+	switch c.padding {
+	case PKCS7:
+		n := bs - len(b)%bs
+		return append(append([]byte(nil), b...), bytes.Repeat([]byte{byte(n)}, n)...), nil
+	case ZeroPadding:
+		n := (bs - len(b)%bs) % bs
+		return append(append([]byte(nil), b...), make([]byte, n)...), nil
+	}
+	if len(b)%bs != 0 {
+		return nil, ErrBlockSize
+	}
+	return append([]byte(nil), b...), nil
+}
+
+func (c *blockCipher) unpad(b []byte) ([]byte, error) {
+	switch c.padding {
+	case PKCS7:
+		if len(b) == 0 {
+			return nil, ErrPadding
+		}
+		n := int(b[len(b)-1])
+		if n == 0 || n > c.block.BlockSize() || n > len(b) {
+			return nil, ErrPadding
+		}
+		for _, v := range b[len(b)-n:] {
+			if int(v) != n {
+				return nil, ErrPadding
+			}
+		}
+		return b[:len(b)-n], nil
+	case ZeroPadding:
+		return bytes.TrimRight(b, "\x00"), nil
+	}
+	return b, nil
+}
+
+// Encrypt 加密
+func (c *blockCipher) Encrypt(plain []byte) ([]byte, error) {
+	buf, err := c.pad(plain)
+	if err != nil {
+		return nil, err
+	}
+	bs := c.block.BlockSize()
+	if c.mode == ECB {
+		for i := 0; i < len(buf); i += bs {
+			c.block.Encrypt(buf[i:i+bs], buf[i:i+bs])
+		}
+		return buf, nil
+	}
+
+	iv, prefix := c.iv, false
+	if iv == nil {
+		iv, prefix = make([]byte, bs), true
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+	}
+	stdcipher.NewCBCEncrypter(c.block, iv).CryptBlocks(buf, buf)
+	if prefix {
+		return append(iv, buf...), nil
+	}
+	return buf, nil
+}
+
+// Decrypt 解密
+func (c *blockCipher) Decrypt(data []byte) ([]byte, error) {
+	bs := c.block.BlockSize()
+	iv := c.iv
+	if c.mode == CBC && iv == nil {
+		if len(data) < bs {
+			return nil, ErrBlockSize
+		}
+		iv, data = data[:bs], data[bs:]
+	}
+	if len(data)%bs != 0 {
+		return nil, ErrBlockSize
+	}
+	buf := append([]byte(nil), data...)
+	if c.mode == ECB {
+		for i := 0; i < len(buf); i += bs {
+			c.block.Decrypt(buf[i:i+bs], buf[i:i+bs])
+		}
+	} else {
+		stdcipher.NewCBCDecrypter(c.block, iv).CryptBlocks(buf, buf)
+	}
+	return c.unpad(buf)
+}
@@ -0,0 +1,325 @@
+package cipher
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// sessionValueCipher 会话私有数据中保存加解密的key
+const sessionValueCipher = "cipher"
+
+var cipherCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_cipher_total",
+	Help: "cipher layer count by driver/op(decrypt/encrypt/exchange)/result",
+}, []string{"driver", "op", "result"})
+
+// Codec 协议报文结构, 由协议提供
+type Codec interface {
+	// Split 解析报文, 返回命令码和需要加解密的负载位置 frame[start:end]
+	Split(frame []byte) (cmd string, start, end int, err error)
+	// Join 替换负载后重新组帧(更新长度/校验/加密标志位)
+	Join(frame []byte, start, end int, payload []byte, encrypted bool) ([]byte, error)
+}
+
+// Flagged 报文头中有加密标志位的协议实现, 上行报文以标志位为准判断是否加密
+// 需要加密的命令标志位为明文时拒绝, 防止设备或中间人降级为明文
+type Flagged interface {
+	Encrypted(frame []byte) bool
+}
+
+// KeyFunc 会话还没有密钥时使用的密钥(如设备预置密钥/协议约定的固定密钥), 返回nil表示明文
+type KeyFunc func(acctx *driver.Ctx) ([]byte, error)
+
+// KeyExchange 登录报文翻译后调用, 返回会话密钥, 可以修改登录应答(如把密钥写入应答)
+// 登录应答用交换前的密钥加密, 之后的报文使用新密钥
+type KeyExchange func(acctx *driver.Ctx, rets []driver.Msg) (key []byte, newRets []driver.Msg, err error)
+
+// Layer 会话加解密层, 位于分帧和协议翻译之间
+// 上行报文解密后交给协议, 协议返回的应答按命令码加密; 驱动主动下发的报文用 Seal 加密
+// 会话密钥保存在 driver.Session 的私有数据中, 需要驱动把会话写入 Ctx
+//
+//	l := cipher.NewLayer("ykc", cipher.Suite{Algorithm: cipher.SM4, Mode: cipher.ECB}, ykcCodec).
+//		SetKeyFunc(presharedKey).
+//		OnLogin("01", exchange).
+//		SetEncrypted(false, "01", "02", "03")
+//	pipeline.Intercept(l.Interceptor())
+type Layer struct {
+	name    string
+	suite   Suite
+	codec   Codec
+	keyFunc KeyFunc
+
+	mu     sync.RWMutex
+	logins map[string]KeyExchange
+	cmds   map[string]bool
+	def    bool
+}
+
+// NewLayer 创建加解密层, 默认所有命令加密
+func NewLayer(name string, s Suite, c Codec) *Layer {
+	return &Layer{
+		name:   name,
+		suite:  s,
+		codec:  c,
+		logins: make(map[string]KeyExchange),
+		cmds:   make(map[string]bool),
+		def:    true,
+	}
+}
+
+// SetKeyFunc 会话没有密钥时使用的密钥
+func (l *Layer) SetKeyFunc(f KeyFunc) *Layer {
+	l.keyFunc = f
+	return l
+}
+
+// OnLogin 登录命令的密钥交换
+func (l *Layer) OnLogin(cmd string, f KeyExchange) *Layer {
+	l.mu.Lock()
+	l.logins[cmd] = f
+	l.mu.Unlock()
+	return l
+}
+
+// SetEncrypted 设置命令是否加密
+func (l *Layer) SetEncrypted(encrypted bool, cmds ...string) *Layer {
+	l.mu.Lock()
+	for _, cmd := range cmds {
+		l.cmds[cmd] = encrypted
+	}
+	l.mu.Unlock()
+	return l
+}
+
+// SetDefault 没有设置过的命令是否加密
+func (l *Layer) SetDefault(encrypted bool) *Layer {
+	l.mu.Lock()
+	l.def = encrypted
+	l.mu.Unlock()
+	return l
+}
+
+func (l *Layer) encrypted(cmd string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if v, ok := l.cmds[cmd]; ok {
+		return v
+	}
+	return l.def
+}
+
+// SetSessionKey 设置会话密钥, key为nil表示清除(之后使用KeyFunc)
+func (l *Layer) SetSessionKey(s *driver.Session, key []byte) error {
+	if key == nil {
+		s.SetValue(sessionValueCipher, nil)
+		return nil
+	}
+	c, err := l.suite.New(key)
+	if err != nil {
+		return err
+	}
+	s.SetValue(sessionValueCipher, c)
+	return nil
+}
+
+// cipher 当前使用的加解密, 返回nil表示明文
+func (l *Layer) cipher(acctx *driver.Ctx) (Cipher, error) {
+	s := acctx.GetSession()
+	if s != nil {
+		if v, ok := s.Value(sessionValueCipher); ok && v != nil {
+			return v.(Cipher), nil
+		}
+	}
+	if l.keyFunc == nil {
+		return nil, nil
+	}
+	key, err := l.keyFunc(acctx)
+	if err != nil || key == nil {
+		return nil, err
+	}
+	c, err := l.suite.New(key)
+	if err != nil {
+		return nil, err
+	}
+	if s != nil {
+		s.SetValue(sessionValueCipher, c)
+	}
+	return c, nil
+}
+
+// open 解密上行报文, 返回明文报文和命令码
+func (l *Layer) open(c Cipher, frame []byte) ([]byte, string, error) {
+	cmd, start, end, err := l.codec.Split(frame)
+	if err != nil {
+		return nil, "", err
+	}
+	enc := l.encrypted(cmd)
+	if f, ok := l.codec.(Flagged); ok {
+		flag := f.Encrypted(frame)
+		if enc && !flag {
+			cipherCounter.WithLabelValues(l.name, "decrypt", "plaintext").Inc()
+			return nil, cmd, fmt.Errorf("%w: cmd %s", ErrPlaintext, cmd)
+		}
+		enc = flag
+	}
+	if !enc {
+		return frame, cmd, nil
+	}
+	if c == nil {
+		cipherCounter.WithLabelValues(l.name, "decrypt", "no_key").Inc()
+		return nil, cmd, fmt.Errorf("cipher: cmd %s encrypted but session has no key", cmd)
+	}
+	plain, err := c.Decrypt(frame[start:end])
+	if err != nil {
+		cipherCounter.WithLabelValues(l.name, "decrypt", "error").Inc()
+		return nil, cmd, fmt.Errorf("cipher: decrypt cmd %s: %w", cmd, err)
+	}
+	cipherCounter.WithLabelValues(l.name, "decrypt", "ok").Inc()
+	frame, err = l.codec.Join(frame, start, end, plain, false)
+	return frame, cmd, err
+}
+
+// seal 加密下行报文
+func (l *Layer) seal(c Cipher, frame []byte) ([]byte, error) {
+	cmd, start, end, err := l.codec.Split(frame)
+	if err != nil {
+		return nil, err
+	}
+	if !l.encrypted(cmd) {
+		return frame, nil
+	}
+	if c == nil {
+		cipherCounter.WithLabelValues(l.name, "encrypt", "no_key").Inc()
+		return nil, fmt.Errorf("cipher: cmd %s encrypted but session has no key", cmd)
+	}
+	data, err := c.Encrypt(frame[start:end])
+	if err != nil {
+		cipherCounter.WithLabelValues(l.name, "encrypt", "error").Inc()
+		return nil, fmt.Errorf("cipher: encrypt cmd %s: %w", cmd, err)
+	}
+	cipherCounter.WithLabelValues(l.name, "encrypt", "ok").Inc()
+	return l.codec.Join(frame, start, end, data, true)
+}
+
+func (l *Layer) sealMsgs(c Cipher, msgs []driver.Msg) ([]driver.Msg, error) {
+	for i, m := range msgs {
+		frames := driver.MsgBytes(m)
+		if frames == nil {
+			continue
+		}
+		sealed := make([][]byte, len(frames))
+		for j, f := range frames {
+			b, err := l.seal(c, f)
+			if err != nil {
+				return nil, err
+			}
+			sealed[j] = b
+		}
+		var raw interface{} = sealed
+		if _, ok := m.GetMsg().([]byte); ok {
+			raw = sealed[0]
+		}
+		sm, err := withRaw(m, raw)
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = sm
+	}
+	return msgs, nil
+}
+
+// withRaw 复制消息并替换负载, 保留消息的具体类型(mqtt/延时等)
+func withRaw(m driver.Msg, raw interface{}) (driver.Msg, error) {
+	switch v := m.(type) {
+	case *driver.DefMsg:
+		c := *v
+		c.Raw = raw
+		return &c, nil
+	case *driver.DefaultMqttMsg:
+		c := *v
+		dm := *v.DefMsg
+		dm.Raw = raw
+		c.DefMsg = &dm
+		return &c, nil
+	case *driver.DefDelayMsg:
+		inner, err := withRaw(v.Msg, raw)
+		if err != nil {
+			return nil, err
+		}
+		c := *v
+		c.Msg = inner
+		return &c, nil
+	}
+	return nil, fmt.Errorf("cipher: cannot replace payload of %T", m)
+}
+
+// Seal 加密驱动主动下发的报文(如远程启动), 使用会话当前的密钥
+func (l *Layer) Seal(s *driver.Session, frame []byte) ([]byte, error) {
+	acctx := driver.NewACCtx(s.Mark(), l.name, frame)
+	driver.KeySession.Set(acctx, s)
+	c, err := l.cipher(acctx)
+	if err != nil {
+		return nil, err
+	}
+	return l.seal(c, frame)
+}
+
+// Interceptor 翻译拦截器, 放在需要看到明文的拦截器(日志/抓包)之后
+func (l *Layer) Interceptor() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		raw := acctx.RawBytes()
+		if raw == nil {
+			return handler(ctx)
+		}
+		c, err := l.cipher(acctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		plain, cmd, err := l.open(c, raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		acctx.Raw = plain
+
+		tos, rets, err = handler(ctx)
+		if err != nil {
+			return
+		}
+
+		l.mu.RLock()
+		exchange, ok := l.logins[cmd]
+		l.mu.RUnlock()
+		if ok {
+			var key []byte
+			if key, rets, err = exchange(acctx, rets); err != nil {
+				cipherCounter.WithLabelValues(l.name, "exchange", "error").Inc()
+				return nil, nil, fmt.Errorf("cipher: key exchange: %w", err)
+			}
+			s := acctx.GetSession()
+			if s == nil && key != nil {
+				// 没有会话无法保存密钥, 之后的报文会用错误的密钥加解密
+				cipherCounter.WithLabelValues(l.name, "exchange", "no_session").Inc()
+				return nil, nil, ErrNoSession
+			}
+			// 登录应答使用交换前的密钥
+			if rets, err = l.sealMsgs(c, rets); err != nil {
+				return nil, nil, err
+			}
+			if key != nil {
+				if err = l.SetSessionKey(s, key); err != nil {
+					return nil, nil, err
+				}
+				cipherCounter.WithLabelValues(l.name, "exchange", "ok").Inc()
+			}
+			return tos, rets, nil
+		}
+
+		rets, err = l.sealMsgs(c, rets)
+		return tos, rets, err
+	}
+}
@@ -0,0 +1,91 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// ErrNoFrames 转发的消息内容不是 []byte 或者 [][]byte
+var ErrNoFrames = errors.New("cluster: msg has no frames")
+
+// Forwarder 把设备操作转发到设备所在节点
+// 只转发 Msg 的 mark 和报文字节(driver.MsgBytes), GetSource 不会转发
+type Forwarder interface {
+	Send(ctx context.Context, node Node, msg driver.Msg) error
+	CheckOnline(ctx context.Context, node Node, mark string) (bool, error)
+	Disconnect(ctx context.Context, node Node, mark, reason string) error
+}
+
+// framesMsg 转发后在目标节点重建的消息
+func framesMsg(mark string, frames [][]byte) driver.Msg {
+	var raw interface{} = frames
+	if len(frames) == 1 {
+		raw = frames[0]
+	}
+	return driver.NewMsg(mark, raw)
+}
+
+// LocalForwarder 进程内转发, 用于测试和单进程多节点模拟
+// 和 GRPCForwarder 一样只转发报文字节
+type LocalForwarder struct {
+	mu    sync.RWMutex
+	nodes map[string]driver.Driver
+}
+
+// NewLocalForwarder 创建进程内转发
+func NewLocalForwarder() *LocalForwarder {
+	return &LocalForwarder{nodes: make(map[string]driver.Driver)}
+}
+
+// Register 登记节点的本地驱动
+func (f *LocalForwarder) Register(nodeID string, d driver.Driver) {
+	f.mu.Lock()
+	f.nodes[nodeID] = d
+	f.mu.Unlock()
+}
+
+func (f *LocalForwarder) get(node Node) (driver.Driver, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	d, ok := f.nodes[node.ID]
+	if !ok {
+		return nil, fmt.Errorf("cluster: node[%s] not registered", node.ID)
+	}
+	return d, nil
+}
+
+// Send 转发消息
+func (f *LocalForwarder) Send(ctx context.Context, node Node, msg driver.Msg) error {
+	frames := driver.MsgBytes(msg)
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	d, err := f.get(node)
+	if err != nil {
+		return err
+	}
+	return d.Send(framesMsg(msg.GetMark(), frames))
+}
+
+// CheckOnline 检查设备是否在节点上在线
+func (f *LocalForwarder) CheckOnline(ctx context.Context, node Node, mark string) (bool, error) {
+	d, err := f.get(node)
+	if err != nil {
+		return false, err
+	}
+	return d.CheckOnline(mark), nil
+}
+
+// Disconnect 断开节点上的设备
+func (f *LocalForwarder) Disconnect(ctx context.Context, node Node, mark, reason string) error {
+	d, err := f.get(node)
+	if err != nil {
+		return err
+	}
+	d.Disconnector(mark, reason)
+	return nil
+}
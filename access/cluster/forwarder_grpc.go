@@ -0,0 +1,178 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+)
+
+// 节点间转发使用json编码, 不依赖proto生成代码
+const (
+	codecName   = "access-cluster-json"
+	serviceName = "access.cluster.Router"
+)
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return codecName }
+
+type forwardRequest struct {
+	Mark   string   `json:"mark"`
+	Frames [][]byte `json:"frames,omitempty"`
+	Reason string   `json:"reason,omitempty"`
+}
+
+type forwardReply struct {
+	Online bool   `json:"online,omitempty"`
+	Err    string `json:"err,omitempty"`
+}
+
+// routerServer 节点间转发服务端, 操作本地驱动
+type routerServer struct {
+	local driver.Driver
+}
+
+func (s *routerServer) send(ctx context.Context, req *forwardRequest) (*forwardReply, error) {
+	if err := s.local.Send(framesMsg(req.Mark, req.Frames)); err != nil {
+		return &forwardReply{Err: err.Error()}, nil
+	}
+	return &forwardReply{}, nil
+}
+
+func (s *routerServer) checkOnline(ctx context.Context, req *forwardRequest) (*forwardReply, error) {
+	return &forwardReply{Online: s.local.CheckOnline(req.Mark)}, nil
+}
+
+func (s *routerServer) disconnect(ctx context.Context, req *forwardRequest) (*forwardReply, error) {
+	s.local.Disconnector(req.Mark, req.Reason)
+	return &forwardReply{}, nil
+}
+
+type routerHandler func(s *routerServer, ctx context.Context, req *forwardRequest) (*forwardReply, error)
+
+func methodDesc(name string, h routerHandler) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			req := &forwardRequest{}
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+			if interceptor == nil {
+				return h(srv.(*routerServer), ctx, req)
+			}
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + serviceName + "/" + name}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return h(srv.(*routerServer), ctx, req.(*forwardRequest))
+			})
+		},
+	}
+}
+
+var routerServiceDesc = grpc.ServiceDesc{
+	ServiceName: serviceName,
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{
+		methodDesc("Send", (*routerServer).send),
+		methodDesc("CheckOnline", (*routerServer).checkOnline),
+		methodDesc("Disconnect", (*routerServer).disconnect),
+	},
+	Metadata: "access/cluster",
+}
+
+// RegisterRouterServer 在grpc服务上注册节点间转发服务
+// local为本节点的接入驱动, 不能传 Router, 避免节点间循环转发
+func RegisterRouterServer(s *grpc.Server, local driver.Driver) {
+	s.RegisterService(&routerServiceDesc, &routerServer{local: local})
+}
+
+// GRPCForwarder 通过grpc转发到其它节点
+type GRPCForwarder struct {
+	opts  []grpc.DialOption
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewGRPCForwarder 创建grpc转发, 没有指定DialOption时使用非加密连接
+func NewGRPCForwarder(opts ...grpc.DialOption) *GRPCForwarder {
+	if len(opts) == 0 {
+		opts = []grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}
+	}
+	return &GRPCForwarder{opts: opts, conns: make(map[string]*grpc.ClientConn)}
+}
+
+func (f *GRPCForwarder) conn(addr string) (*grpc.ClientConn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if cc, ok := f.conns[addr]; ok {
+		return cc, nil
+	}
+	cc, err := grpc.Dial(addr, f.opts...)
+	if err != nil {
+		return nil, err
+	}
+	f.conns[addr] = cc
+	return cc, nil
+}
+
+func (f *GRPCForwarder) invoke(ctx context.Context, node Node, method string, req *forwardRequest) (*forwardReply, error) {
+	cc, err := f.conn(node.Addr)
+	if err != nil {
+		return nil, err
+	}
+	reply := &forwardReply{}
+	if err := cc.Invoke(ctx, "/"+serviceName+"/"+method, req, reply, grpc.CallContentSubtype(codecName)); err != nil {
+		return nil, err
+	}
+	if reply.Err != "" {
+		return nil, errors.New(reply.Err)
+	}
+	return reply, nil
+}
+
+// Send 转发消息, 报文内容不是 []byte 或者 [][]byte 时返回 ErrNoFrames
+func (f *GRPCForwarder) Send(ctx context.Context, node Node, msg driver.Msg) error {
+	frames := driver.MsgBytes(msg)
+	if len(frames) == 0 {
+		return ErrNoFrames
+	}
+	_, err := f.invoke(ctx, node, "Send", &forwardRequest{Mark: msg.GetMark(), Frames: frames})
+	return err
+}
+
+// CheckOnline 检查设备是否在节点上在线
+func (f *GRPCForwarder) CheckOnline(ctx context.Context, node Node, mark string) (bool, error) {
+	reply, err := f.invoke(ctx, node, "CheckOnline", &forwardRequest{Mark: mark})
+	if err != nil {
+		return false, err
+	}
+	return reply.Online, nil
+}
+
+// Disconnect 断开节点上的设备
+func (f *GRPCForwarder) Disconnect(ctx context.Context, node Node, mark, reason string) error {
+	_, err := f.invoke(ctx, node, "Disconnect", &forwardRequest{Mark: mark, Reason: reason})
+	return err
+}
+
+// Close 关闭所有连接
+func (f *GRPCForwarder) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for addr, cc := range f.conns {
+		cc.Close()
+		delete(f.conns, addr)
+	}
+}
@@ -0,0 +1,149 @@
+package cluster
+
+import (
+	"sync"
+	"time"
+)
+
+// Node 网关节点
+type Node struct {
+	ID   string `json:"id"`
+	Addr string `json:"addr"` // 节点间转发地址(grpc)
+}
+
+// Registry 设备归属登记
+// 节点通过心跳维持租约, 租约过期的节点上登记的设备视为离线
+// 设备归属也有租约, 节点心跳时 Refresh 续约, 节点异常退出后归属记录自动过期
+type Registry interface {
+	// Heartbeat 续约节点租约
+	Heartbeat(node Node, ttl time.Duration) error
+
+	// Leave 节点下线, 立即释放租约
+	Leave(nodeID string) error
+
+	// Claim 设备连接到节点, 归属租约为ttl, 返回之前的归属节点(没有时为空)
+	Claim(mark, nodeID string, ttl time.Duration) (prev string, err error)
+
+	// Refresh 续约节点上设备的归属, 已经归属其它节点的设备不变, 归属已过期的重新登记
+	Refresh(nodeID string, marks []string, ttl time.Duration) error
+
+	// Release 设备从节点断开, 只有归属节点仍是nodeID时才释放
+	Release(mark, nodeID string) error
+
+	// Owner 设备当前归属的存活节点, 没有或节点租约已过期时ok为false
+	Owner(mark string) (node Node, ok bool, err error)
+
+	// Node 存活节点信息, 节点租约已过期时ok为false
+	Node(id string) (node Node, ok bool, err error)
+}
+
+type ownerLease struct {
+	nodeID   string
+	expireAt time.Time
+}
+
+// MemoryRegistry 内存登记, 用于测试和单进程多节点模拟
+type MemoryRegistry struct {
+	mu     sync.Mutex
+	nodes  map[string]Node
+	leases map[string]time.Time
+	owners map[string]ownerLease
+}
+
+// NewMemoryRegistry 创建内存登记
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		nodes:  make(map[string]Node),
+		leases: make(map[string]time.Time),
+		owners: make(map[string]ownerLease),
+	}
+}
+
+// Heartbeat 续约节点租约
+func (r *MemoryRegistry) Heartbeat(node Node, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.nodes[node.ID] = node
+	r.leases[node.ID] = time.Now().Add(ttl)
+	return nil
+}
+
+// Leave 节点下线
+func (r *MemoryRegistry) Leave(nodeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.nodes, nodeID)
+	delete(r.leases, nodeID)
+	return nil
+}
+
+// owner 未过期的归属节点
+func (r *MemoryRegistry) owner(mark string, now time.Time) string {
+	o, ok := r.owners[mark]
+	if !ok {
+		return ""
+	}
+	if now.After(o.expireAt) {
+		delete(r.owners, mark)
+		return ""
+	}
+	return o.nodeID
+}
+
+// Claim 设备连接到节点
+func (r *MemoryRegistry) Claim(mark, nodeID string, ttl time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	prev := r.owner(mark, now)
+	r.owners[mark] = ownerLease{nodeID: nodeID, expireAt: now.Add(ttl)}
+	return prev, nil
+}
+
+// Refresh 续约节点上设备的归属
+func (r *MemoryRegistry) Refresh(nodeID string, marks []string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	for _, mark := range marks {
+		if id := r.owner(mark, now); id == "" || id == nodeID {
+			r.owners[mark] = ownerLease{nodeID: nodeID, expireAt: now.Add(ttl)}
+		}
+	}
+	return nil
+}
+
+// Release 设备从节点断开
+func (r *MemoryRegistry) Release(mark, nodeID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.owner(mark, time.Now()) == nodeID {
+		delete(r.owners, mark)
+	}
+	return nil
+}
+
+// Owner 设备当前归属的存活节点
+func (r *MemoryRegistry) Owner(mark string) (Node, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id := r.owner(mark, time.Now())
+	if id == "" {
+		return Node{}, false, nil
+	}
+	return r.node(id)
+}
+
+// Node 存活节点信息
+func (r *MemoryRegistry) Node(id string) (Node, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.node(id)
+}
+
+func (r *MemoryRegistry) node(id string) (Node, bool, error) {
+	if lease, ok := r.leases[id]; !ok || time.Now().After(lease) {
+		return Node{}, false, nil
+	}
+	return r.nodes[id], true, nil
+}
@@ -0,0 +1,158 @@
+package cluster
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// KEYS: owner
+// ARGV: nodeID, ttl(ms)
+var claimScript = redis.NewScript(1, `
+local prev = redis.call('GET', KEYS[1])
+redis.call('SET', KEYS[1], ARGV[1], 'PX', ARGV[2])
+return prev
+`)
+
+// KEYS: owners
+// ARGV: nodeID, ttl(ms)
+var refreshScript = redis.NewScript(-1, `
+for _, key in ipairs(KEYS) do
+	local cur = redis.call('GET', key)
+	if cur == ARGV[1] or cur == false then
+		redis.call('SET', key, ARGV[1], 'PX', ARGV[2])
+	end
+end
+return #KEYS
+`)
+
+// refreshBatch 每次续约的设备数
+const refreshBatch = 500
+
+// KEYS: owner
+// ARGV: nodeID
+var releaseScript = redis.NewScript(1, `
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	return redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+// RedisRegistry redis登记, 使用 redigo 连接池
+//
+//	{prefix}:node:{id}    string  节点信息json, 过期时间为租约
+//	{prefix}:owner:{mark} string  归属节点id, 过期时间为归属租约
+type RedisRegistry struct {
+	prefix string
+}
+
+// NewRedisRegistry 创建redis登记, prefix为空时使用 access:cluster
+func NewRedisRegistry(prefix string) *RedisRegistry {
+	if prefix == "" {
+		prefix = "access:cluster"
+	}
+	return &RedisRegistry{prefix: prefix}
+}
+
+func (r *RedisRegistry) nodeKey(id string) string {
+	return r.prefix + ":node:" + id
+}
+
+func (r *RedisRegistry) ownerKey(mark string) string {
+	return r.prefix + ":owner:" + mark
+}
+
+// Heartbeat 续约节点租约
+func (r *RedisRegistry) Heartbeat(node Node, ttl time.Duration) error {
+	b, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+	_, err = redigo.Do("SET", r.nodeKey(node.ID), b, "PX", int64(ttl/time.Millisecond))
+	return err
+}
+
+// Leave 节点下线
+func (r *RedisRegistry) Leave(nodeID string) error {
+	_, err := redigo.Do("DEL", r.nodeKey(nodeID))
+	return err
+}
+
+// Claim 设备连接到节点
+func (r *RedisRegistry) Claim(mark, nodeID string, ttl time.Duration) (string, error) {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	prev, err := redis.String(claimScript.Do(rd, r.ownerKey(mark), nodeID, int64(ttl/time.Millisecond)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return prev, err
+}
+
+// Refresh 续约节点上设备的归属, 按 refreshBatch 分批执行
+func (r *RedisRegistry) Refresh(nodeID string, marks []string, ttl time.Duration) error {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	for len(marks) > 0 {
+		n := len(marks)
+		if n > refreshBatch {
+			n = refreshBatch
+		}
+		args := make([]interface{}, 0, n+3)
+		args = append(args, n) // 脚本key数量不固定, 第一个参数为key数量
+		for _, mark := range marks[:n] {
+			args = append(args, r.ownerKey(mark))
+		}
+		args = append(args, nodeID, int64(ttl/time.Millisecond))
+		if _, err := refreshScript.Do(rd, args...); err != nil {
+			return err
+		}
+		marks = marks[n:]
+	}
+	return nil
+}
+
+// Release 设备从节点断开
+func (r *RedisRegistry) Release(mark, nodeID string) error {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	_, err := releaseScript.Do(rd, r.ownerKey(mark), nodeID)
+	return err
+}
+
+// Owner 设备当前归属的存活节点
+func (r *RedisRegistry) Owner(mark string) (Node, bool, error) {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+
+	id, err := redis.String(rd.Do("GET", r.ownerKey(mark)))
+	if err == redis.ErrNil {
+		return Node{}, false, nil
+	} else if err != nil {
+		return Node{}, false, err
+	}
+	return r.node(rd, id)
+}
+
+// Node 存活节点信息
+func (r *RedisRegistry) Node(id string) (Node, bool, error) {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	return r.node(rd, id)
+}
+
+func (r *RedisRegistry) node(rd redis.Conn, id string) (Node, bool, error) {
+	b, err := redis.Bytes(rd.Do("GET", r.nodeKey(id)))
+	if err == redis.ErrNil { // 节点租约已过期
+		return Node{}, false, nil
+	} else if err != nil {
+		return Node{}, false, err
+	}
+	node := Node{}
+	if err := json.Unmarshal(b, &node); err != nil {
+		return Node{}, false, err
+	}
+	return node, true, nil
+}
@@ -0,0 +1,253 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var (
+	// ErrOffline 设备不在任何节点上
+	ErrOffline = errors.New("cluster: device offline")
+
+	routeCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_cluster_route_total",
+		Help: "cluster routing count by op/target(local/remote/offline)",
+	}, []string{"op", "target"})
+)
+
+// Router 多节点路由, 包装本节点的接入驱动
+// 设备不在本节点时, 按Registry中的归属把操作转发到设备所在节点, CheckOnline为集群范围
+//
+//	r := cluster.NewRouter(cluster.Node{ID: "gw-1", Addr: "10.0.0.1:9100"}, tcpDriver,
+//		cluster.NewRedisRegistry(""), cluster.NewGRPCForwarder())
+//	// 设备登录成功后 r.Connected(mark), 断开后 r.Disconnected(mark)
+type Router struct {
+	driver.Driver // 本节点驱动
+
+	self      Node
+	registry  Registry
+	forwarder Forwarder
+	ttl       time.Duration
+	timeout   time.Duration
+	log       *logrus.Entry
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+	owned  map[string]struct{} // 本节点登记的设备, 心跳时续约归属
+}
+
+// NewRouter 创建路由, 默认租约15秒, 转发超时5秒
+func NewRouter(self Node, local driver.Driver, r Registry, f Forwarder) *Router {
+	return &Router{
+		Driver:    local,
+		self:      self,
+		registry:  r,
+		forwarder: f,
+		ttl:       15 * time.Second,
+		timeout:   5 * time.Second,
+		log:       logrus.WithField("module", "cluster").WithField("node", self.ID),
+		owned:     make(map[string]struct{}),
+	}
+}
+
+// SetLease 节点和设备归属的租约时长, 心跳间隔为租约的1/3
+func (r *Router) SetLease(ttl time.Duration) *Router {
+	r.ttl = ttl
+	return r
+}
+
+// SetTimeout 转发超时
+func (r *Router) SetTimeout(t time.Duration) *Router {
+	r.timeout = t
+	return r
+}
+
+// Self 本节点
+func (r *Router) Self() Node {
+	return r.self
+}
+
+// Run 登记节点并开始心跳, 然后启动本节点驱动
+func (r *Router) Run() (err error) {
+	if err = r.registry.Heartbeat(r.self, r.ttl); err != nil {
+		return fmt.Errorf("cluster: heartbeat: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	r.mu.Lock()
+	r.cancel, r.done = cancel, done
+	r.mu.Unlock()
+	go r.heartbeat(ctx, done)
+
+	if err = r.Driver.Run(); err != nil {
+		r.stopHeartbeat()
+		return err
+	}
+	return nil
+}
+
+func (r *Router) heartbeat(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	t := time.NewTicker(r.ttl / 3)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := r.registry.Heartbeat(r.self, r.ttl); err != nil {
+				r.log.Errorf("heartbeat: %v", err)
+			}
+			if err := r.registry.Refresh(r.self.ID, r.ownedMarks(), r.ttl); err != nil {
+				r.log.Errorf("refresh owners: %v", err)
+			}
+		}
+	}
+}
+
+func (r *Router) ownedMarks() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	marks := make([]string, 0, len(r.owned))
+	for mark := range r.owned {
+		marks = append(marks, mark)
+	}
+	return marks
+}
+
+func (r *Router) stopHeartbeat() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+	if cancel != nil {
+		cancel()
+		<-done
+	}
+	if err := r.registry.Leave(r.self.ID); err != nil {
+		r.log.Errorf("leave: %v", err)
+	}
+}
+
+// Stop 停止心跳并下线节点, 然后停止本节点驱动
+func (r *Router) Stop() {
+	r.stopHeartbeat()
+	r.Driver.Stop()
+}
+
+// Connected 设备连接到本节点(登录成功后调用)
+// 设备之前在其它存活节点上时, 通知该节点断开旧连接
+func (r *Router) Connected(mark string) {
+	r.mu.Lock()
+	r.owned[mark] = struct{}{}
+	r.mu.Unlock()
+	prev, err := r.registry.Claim(mark, r.self.ID, r.ttl)
+	if err != nil {
+		// 下次心跳续约时重新登记
+		r.log.WithField("mark", mark).Errorf("claim: %v", err)
+		return
+	}
+	if prev == "" || prev == r.self.ID {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		defer cancel()
+		node, ok, err := r.registry.Node(prev)
+		if err != nil || !ok {
+			return
+		}
+		if err := r.forwarder.Disconnect(ctx, node, mark, "moved to "+r.self.ID); err != nil {
+			r.log.WithField("mark", mark).Warnf("disconnect on previous node[%s]: %v", prev, err)
+		}
+	}()
+}
+
+// Disconnected 设备从本节点断开
+func (r *Router) Disconnected(mark string) {
+	r.mu.Lock()
+	delete(r.owned, mark)
+	r.mu.Unlock()
+	if err := r.registry.Release(mark, r.self.ID); err != nil {
+		r.log.WithField("mark", mark).Errorf("release: %v", err)
+	}
+}
+
+// owner 设备归属的其它节点, local为true表示在本节点
+func (r *Router) owner(mark string) (node Node, local bool, err error) {
+	if r.Driver.CheckOnline(mark) {
+		return r.self, true, nil
+	}
+	node, ok, err := r.registry.Owner(mark)
+	if err != nil {
+		return Node{}, false, err
+	}
+	if !ok {
+		return Node{}, false, ErrOffline
+	}
+	return node, node.ID == r.self.ID, nil
+}
+
+// Send 发送消息, 设备不在本节点时转发
+func (r *Router) Send(msg driver.Msg) error {
+	node, local, err := r.owner(msg.GetMark())
+	if err != nil {
+		routeCounter.WithLabelValues("send", "offline").Inc()
+		return err
+	}
+	if local {
+		routeCounter.WithLabelValues("send", "local").Inc()
+		return r.Driver.Send(msg)
+	}
+	routeCounter.WithLabelValues("send", "remote").Inc()
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	return r.forwarder.Send(ctx, node, msg)
+}
+
+// CheckOnline 集群范围检查设备是否在线
+func (r *Router) CheckOnline(mark string) bool {
+	node, local, err := r.owner(mark)
+	if err != nil {
+		return false
+	}
+	if local {
+		// Registry登记在本节点但本地已经没有连接(如节点重启), 以本地为准
+		return r.Driver.CheckOnline(mark)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	ok, err := r.forwarder.CheckOnline(ctx, node, mark)
+	if err != nil {
+		r.log.WithField("mark", mark).Warnf("check online on node[%s]: %v", node.ID, err)
+		return false
+	}
+	return ok
+}
+
+// Disconnector 断开设备, 设备不在本节点时转发
+func (r *Router) Disconnector(mark, reason string) {
+	node, local, err := r.owner(mark)
+	if err != nil {
+		return
+	}
+	if local {
+		r.Driver.Disconnector(mark, reason)
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if err := r.forwarder.Disconnect(ctx, node, mark, reason); err != nil {
+		r.log.WithField("mark", mark).Warnf("disconnect on node[%s]: %v", node.ID, err)
+	}
+}
@@ -0,0 +1,125 @@
+package access
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+	"gopkg.in/yaml.v2"
+)
+
+// Config 链路配置
+//
+//	drivers:
+//	  tcp8080: {type: tcp, params: {addr: ":8080"}}
+//	  upstream: {type: grpc, params: {target: "core:9000"}}
+//	protocols:
+//	  ykc: {type: ykc}
+//	pipelines:
+//	  - name: ykc
+//	    listen: tcp8080
+//	    protocol: ykc
+//	    forward: upstream
+//
+// 多条链路引用同一个驱动名时共享同一个驱动实例, 只有转发端可以共享, 接入端每条链路独占
+type Config struct {
+	Drivers   map[string]ComponentConfig `yaml:"drivers"`
+	Protocols map[string]ComponentConfig `yaml:"protocols"`
+	Pipelines []PipelineConfig           `yaml:"pipelines"`
+}
+
+// ComponentConfig 驱动/协议配置
+type ComponentConfig struct {
+	Type   string `yaml:"type"`   // 注册的类型名
+	Params Params `yaml:"params"` // 创建参数
+}
+
+// PipelineConfig 单条链路配置, 字段值为 drivers/protocols 中的名称
+type PipelineConfig struct {
+	Name     string            `yaml:"name"`
+	Listen   string            `yaml:"listen"`
+	Protocol string            `yaml:"protocol"`
+	Forward  string            `yaml:"forward"`
+	Routes   map[string]string `yaml:"routes"`
+}
+
+// ParseConfig 解析yaml配置
+func ParseConfig(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.Unmarshal(b, c); err != nil {
+		return nil, fmt.Errorf("access: parse config: %w", err)
+	}
+	return c, nil
+}
+
+// LoadConfig 从文件加载yaml配置
+func LoadConfig(filename string) (*Config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseConfig(b)
+}
+
+// Build 根据配置创建所有组件, 并返回校验通过的链路组
+func (c *Config) Build() (*Group, error) {
+	ds := make(map[string]driver.Driver, len(c.Drivers))
+	getDriver := func(name string) (driver.Driver, error) {
+		if d, ok := ds[name]; ok {
+			return d, nil
+		}
+		cc, ok := c.Drivers[name]
+		if !ok {
+			return nil, fmt.Errorf("access: driver [%s] not defined", name)
+		}
+		d, err := NewDriver(cc.Type, cc.Params)
+		if err != nil {
+			return nil, fmt.Errorf("access: create driver [%s]: %w", name, err)
+		}
+		ds[name] = d
+		return d, nil
+	}
+
+	g := NewGroup()
+	for _, pc := range c.Pipelines {
+		p := NewPipeline(pc.Name)
+
+		listen, err := getDriver(pc.Listen)
+		if err != nil {
+			return nil, err
+		}
+		p.Listen(listen)
+
+		// 协议是有状态的翻译对象, 每条链路单独创建
+		cc, ok := c.Protocols[pc.Protocol]
+		if !ok {
+			return nil, fmt.Errorf("access: protocol [%s] not defined", pc.Protocol)
+		}
+		proto, err := NewProtocol(cc.Type, cc.Params)
+		if err != nil {
+			return nil, fmt.Errorf("access: create protocol [%s]: %w", pc.Protocol, err)
+		}
+		p.Protocol(proto)
+
+		if pc.Forward != "" {
+			forward, err := getDriver(pc.Forward)
+			if err != nil {
+				return nil, err
+			}
+			p.Forward(forward)
+		}
+		for name, dn := range pc.Routes {
+			d, err := getDriver(dn)
+			if err != nil {
+				return nil, err
+			}
+			p.Route(name, d)
+		}
+		g.Add(p)
+	}
+
+	if err := g.Validate(); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
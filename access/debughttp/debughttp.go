@@ -0,0 +1,212 @@
+package debughttp
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+	"github.com/zhuoqingbin/utils/access/internal/httputil"
+	"github.com/zhuoqingbin/utils/access/traffic"
+)
+
+// Source 驱动提供的调试数据, Sessions 必填, Traffic/Tail 可以为nil
+type Source struct {
+	Sessions *driver.SessionManager
+	Traffic  *traffic.Accounting
+	Tail     *Tail
+}
+
+var (
+	mu      sync.RWMutex
+	sources = make(map[string]Source)
+)
+
+// Register 注册驱动的调试数据, 重复注册会覆盖, Sessions 为nil时panic
+func Register(driverName string, src Source) {
+	if src.Sessions == nil {
+		panic("debughttp: nil Sessions for driver " + driverName)
+	}
+	mu.Lock()
+	sources[driverName] = src
+	mu.Unlock()
+}
+
+// Unregister 取消注册
+func Unregister(driverName string) {
+	mu.Lock()
+	delete(sources, driverName)
+	mu.Unlock()
+}
+
+func getSource(name string) (Source, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	src, ok := sources[name]
+	return src, ok
+}
+
+// Session 会话信息
+type Session struct {
+	driver.SessionInfo
+	Traffic *traffic.Snapshot `json:"traffic,omitempty"`
+}
+
+// SessionList 会话分页
+type SessionList struct {
+	Total int       `json:"total"`
+	Items []Session `json:"items"`
+}
+
+// frameJSON 实时报文
+type frameJSON struct {
+	Time   time.Time `json:"time"`
+	Mark   string    `json:"mark"`
+	Remote string    `json:"remote,omitempty"`
+	Cmd    string    `json:"cmd,omitempty"`
+	Dir    string    `json:"dir"`
+	Data   string    `json:"data"` // hex
+}
+
+// Handler 调试接口, 挂载到 prefix 下时需要配合 http.StripPrefix 使用
+//
+//	GET  /                                       已注册的驱动
+//	GET  /{driver}/sessions?mark=&remote=&authed=&proto=&offset=0&limit=50
+//	POST /{driver}/kick?mark=xx&reason=xx        踢下线
+//	GET  /{driver}/tail?mark=xx                  实时报文(每行一个json), 客户端断开后结束
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := strings.Trim(r.URL.Path, "/")
+		if path == "" {
+			mu.RLock()
+			names := make([]string, 0, len(sources))
+			for name := range sources {
+				names = append(names, name)
+			}
+			mu.RUnlock()
+			sort.Strings(names)
+			httputil.WriteJSON(w, http.StatusOK, names)
+			return
+		}
+
+		parts := strings.SplitN(path, "/", 2)
+		src, ok := getSource(parts[0])
+		if !ok || len(parts) != 2 {
+			http.NotFound(w, r)
+			return
+		}
+		switch parts[1] {
+		case "sessions":
+			handleSessions(w, r, src)
+		case "kick":
+			handleKick(w, r, src)
+		case "tail":
+			handleTail(w, r, src)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
+
+func handleSessions(w http.ResponseWriter, r *http.Request, src Source) {
+	q := r.URL.Query()
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	limit, err := strconv.Atoi(q.Get("limit"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 50
+	}
+	mark, remote, proto, authed := q.Get("mark"), q.Get("remote"), q.Get("proto"), q.Get("authed")
+
+	ret := SessionList{Items: make([]Session, 0)}
+	for _, s := range src.Sessions.Sessions() {
+		info := s.Info()
+		if mark != "" && !strings.Contains(info.Mark, mark) ||
+			remote != "" && !strings.Contains(info.Remote, remote) ||
+			proto != "" && info.ProtoVersion != proto ||
+			authed != "" && strconv.FormatBool(info.Authed) != authed {
+			continue
+		}
+		ret.Total++
+		if ret.Total <= offset || len(ret.Items) >= limit {
+			continue
+		}
+		item := Session{SessionInfo: info}
+		if src.Traffic != nil {
+			if snap, ok := src.Traffic.Get(info.Mark); ok {
+				item.Traffic = &snap
+			}
+		}
+		ret.Items = append(ret.Items, item)
+	}
+	httputil.WriteJSON(w, http.StatusOK, ret)
+}
+
+func handleKick(w http.ResponseWriter, r *http.Request, src Source) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	mark := r.URL.Query().Get("mark")
+	if mark == "" {
+		httputil.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "mark required"})
+		return
+	}
+	ss := src.Sessions.GetAll(mark)
+	if len(ss) == 0 {
+		httputil.WriteJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	reason := driver.ReasonKick
+	if v := r.URL.Query().Get("reason"); v != "" {
+		reason = driver.Reason(v)
+	}
+	for _, s := range ss {
+		s.Kick(reason)
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]int{"kicked": len(ss)})
+}
+
+func handleTail(w http.ResponseWriter, r *http.Request, src Source) {
+	mark := r.URL.Query().Get("mark")
+	if src.Tail == nil || mark == "" {
+		httputil.WriteJSON(w, http.StatusBadRequest, map[string]string{"error": "tail not enabled or mark required"})
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusNotImplemented)
+		return
+	}
+
+	frames, cancel := src.Tail.Subscribe(mark)
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case f := <-frames:
+			if err := enc.Encode(&frameJSON{
+				Time:   f.Time,
+				Mark:   f.Mark,
+				Remote: f.Remote,
+				Cmd:    f.Cmd,
+				Dir:    f.Dir.String(),
+				Data:   hex.EncodeToString(f.Data),
+			}); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package debughttp
+
+import (
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Tail 按设备实时查看报文, 实现 driver.FrameSink
+// 没有订阅者时 WriteFrame 几乎没有开销
+type Tail struct {
+	mu   sync.RWMutex
+	subs map[string]map[chan *driver.Frame]struct{} // mark -> subscribers
+}
+
+// NewTail 创建实时报文查看
+func NewTail() *Tail {
+	return &Tail{subs: make(map[string]map[chan *driver.Frame]struct{})}
+}
+
+// WriteFrame 分发报文给订阅者, 订阅者处理不过来时丢弃
+func (t *Tail) WriteFrame(f *driver.Frame) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	for ch := range t.subs[f.Mark] {
+		select {
+		case ch <- f:
+		default:
+		}
+	}
+}
+
+// Subscribe 订阅设备的报文, 返回取消订阅函数
+func (t *Tail) Subscribe(mark string) (<-chan *driver.Frame, func()) {
+	ch := make(chan *driver.Frame, 256)
+	t.mu.Lock()
+	if _, ok := t.subs[mark]; !ok {
+		t.subs[mark] = make(map[chan *driver.Frame]struct{})
+	}
+	t.subs[mark][ch] = struct{}{}
+	t.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			t.mu.Lock()
+			delete(t.subs[mark], ch)
+			if len(t.subs[mark]) == 0 {
+				delete(t.subs, mark)
+			}
+			t.mu.Unlock()
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package devconf
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhuoqingbin/utils/access/internal/httputil"
+)
+
+// Handler 参数管理接口, 挂载时需要 http.StripPrefix
+// 操作人取请求头 X-Operator, 没有时取参数 operator
+//
+//	GET  /devices/{mark}                 设备参数状态
+//	PUT  /devices/{mark}                 body为参数json, 修改设备期望参数, 值为空表示删除
+//	POST /devices/{mark}/group?name=g    修改设备分组
+//	POST /devices/{mark}/sync            立即同步
+//	GET  /devices/{mark}/audits?limit=n  审计记录
+//	GET  /groups/{name}                  分组参数
+//	PUT  /groups/{name}                  body为参数json, 修改分组参数
+//	GET  /groups/{name}/audits?limit=n   审计记录
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if len(parts) < 2 || parts[1] == "" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		operator := r.Header.Get("X-Operator")
+		if operator == "" {
+			operator = r.URL.Query().Get("operator")
+		}
+
+		kind, name, action := parts[0], parts[1], ""
+		if len(parts) == 3 {
+			action = parts[2]
+		} else if len(parts) > 3 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var err error
+		switch {
+		case action == "audits" && r.Method == http.MethodGet && (kind == "devices" || kind == "groups"):
+			target := name
+			if kind == "groups" {
+				target = GroupTarget(name)
+			}
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+			as, err := m.Audits(target, limit)
+			if err != nil {
+				httputil.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			httputil.WriteJSON(w, http.StatusOK, as)
+			return
+		case kind == "devices" && action == "" && r.Method == http.MethodGet:
+			st, err := m.Status(name)
+			if err != nil {
+				httputil.WriteError(w, errStatus(err), err)
+				return
+			}
+			httputil.WriteJSON(w, http.StatusOK, st)
+			return
+		case kind == "groups" && action == "" && r.Method == http.MethodGet:
+			p, err := m.Group(name)
+			if err != nil {
+				httputil.WriteError(w, http.StatusInternalServerError, err)
+				return
+			}
+			httputil.WriteJSON(w, http.StatusOK, p)
+			return
+		case action == "" && r.Method == http.MethodPut && (kind == "devices" || kind == "groups"):
+			if operator == "" {
+				httputil.WriteError(w, http.StatusBadRequest, errors.New("devconf: no operator"))
+				return
+			}
+			p := Params{}
+			if err = json.NewDecoder(r.Body).Decode(&p); err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+			if kind == "devices" {
+				err = m.SetDesired(operator, name, p)
+			} else {
+				err = m.SetGroup(operator, name, p)
+			}
+		case kind == "devices" && action == "group" && r.Method == http.MethodPost:
+			if operator == "" {
+				httputil.WriteError(w, http.StatusBadRequest, errors.New("devconf: no operator"))
+				return
+			}
+			err = m.Assign(operator, name, r.URL.Query().Get("name"))
+		case kind == "devices" && action == "sync" && r.Method == http.MethodPost:
+			err = m.Sync(name)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		switch {
+		case errors.Is(err, ErrOffline): // 参数已经保存, 上线后同步
+			w.WriteHeader(http.StatusAccepted)
+		case err != nil:
+			httputil.WriteError(w, errStatus(err), err)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+}
+
+func errStatus(err error) int {
+	return httputil.ErrStatus(err, http.StatusInternalServerError,
+		httputil.Status{Err: ErrNotFound, Code: http.StatusNotFound})
+}
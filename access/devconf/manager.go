@@ -0,0 +1,462 @@
+package devconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+	"github.com/zhuoqingbin/utils/access/interceptor"
+)
+
+var devconfCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_devconf_total",
+	Help: "device config sync by manager/result(read/push/verified/failed/error)",
+}, []string{"manager", "result"})
+
+var (
+	// ErrOffline 设备离线, 上线后同步
+	ErrOffline = errors.New("devconf: device offline")
+
+	// ErrNotFound 设备没有参数记录
+	ErrNotFound = errors.New("devconf: device not found")
+
+	// ErrConflict 保存时记录已经被修改(如其它节点同时处理), 会重新加载后重试
+	ErrConflict = errors.New("devconf: concurrent update")
+)
+
+// Codec 协议的参数报文, 由协议实现
+type Codec interface {
+	// Set 设置参数报文, changes为需要修改的参数
+	Set(mark string, changes Params) (driver.Msg, error)
+	// Read 读取参数报文, keys为需要读取的参数, 为空表示读取全部
+	Read(mark string, keys []string) (driver.Msg, error)
+	// Report 解析设备上行报文, 是参数上报(读取应答或设备主动上报)时返回true
+	Report(msg driver.Msg) (r Report, ok bool)
+}
+
+// Report 设备上报的参数, 可以只包含部分参数
+type Report struct {
+	Mark   string
+	Params Params
+}
+
+// Manager 设备参数管理
+// 期望参数按分组和设备保存, 设备参数覆盖分组参数; 和设备上报的参数不一致时下发设置,
+// 设置后立即下发读取做回读校验, 不一致或超时未上报时重试, 超过最大次数为失败,
+// 设备重新上线(Connect)时重新读取并同步
+//
+//	m := devconf.New("ykc", tcpDriver, ykcConfCodec, devconf.NewRedisStore(""))
+//	go m.Run(ctx)
+//	pipeline.Intercept(m.Interceptor(true))
+//	sm.OnEvent(func(ev driver.SessionEvent) { if ev.Type == driver.EventConnect { m.Connect(ev.Session.Mark) } })
+//	m.SetDesired("admin", mark, devconf.Params{"heartbeat": "30", "qrcode": "https://..."})
+type Manager struct {
+	name         string
+	driver       driver.Driver
+	codec        Codec
+	store        Store
+	replyTimeout time.Duration
+	maxAttempts  int
+	interval     time.Duration
+	log          *logrus.Entry
+
+	locks      sync.Map // mark -> *sync.Mutex
+	groupLocks sync.Map // group -> *sync.Mutex
+}
+
+// New 创建参数管理, name用于指标标签
+func New(name string, d driver.Driver, codec Codec, s Store) *Manager {
+	return &Manager{
+		name:         name,
+		driver:       d,
+		codec:        codec,
+		store:        s,
+		replyTimeout: 30 * time.Second,
+		maxAttempts:  3,
+		interval:     5 * time.Second,
+		log:          logrus.WithField("module", "devconf").WithField("manager", name),
+	}
+}
+
+// SetReplyTimeout 等待设备上报参数的超时时间
+func (m *Manager) SetReplyTimeout(d time.Duration) *Manager {
+	m.replyTimeout = d
+	return m
+}
+
+// SetMaxAttempts 一轮同步的最大下发次数(读取和设置)
+func (m *Manager) SetMaxAttempts(n int) *Manager {
+	m.maxAttempts = n
+	return m
+}
+
+// SetInterval 扫描待同步设备的间隔
+func (m *Manager) SetInterval(d time.Duration) *Manager {
+	m.interval = d
+	return m
+}
+
+func lockKey(locks *sync.Map, key string) func() {
+	v, _ := locks.LoadOrStore(key, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	return mu.Unlock
+}
+
+func (m *Manager) lock(mark string) func() {
+	return lockKey(&m.locks, mark)
+}
+
+// saveRetries 保存冲突时最多尝试的次数
+const saveRetries = 3
+
+// update 持有设备锁加载设备记录后执行f, 保存冲突(其它节点同时修改)时重新加载后重试
+// create为true时设备记录不存在则新建, 否则不处理
+func (m *Manager) update(mark string, create bool, f func(d *Device) error) error {
+	unlock := m.lock(mark)
+	defer unlock()
+
+	for i := 1; ; i++ {
+		d, err := m.store.Load(mark)
+		if err != nil {
+			return err
+		}
+		if d == nil {
+			if !create {
+				return nil
+			}
+			d = &Device{Mark: mark, State: StatePending}
+		}
+		if err = f(d); errors.Is(err, ErrConflict) && i < saveRetries {
+			continue
+		}
+		return err
+	}
+}
+
+// effective 分组参数和设备参数合并后的期望参数
+func (m *Manager) effective(d *Device) (Params, error) {
+	ret := Params{}
+	if d.Group != "" {
+		g, err := m.store.LoadGroup(d.Group)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil {
+			for k, v := range g.Params {
+				ret[k] = v
+			}
+		}
+	}
+	for k, v := range d.Desired {
+		ret[k] = v
+	}
+	return ret, nil
+}
+
+func (m *Manager) audit(a *Audit) {
+	a.Time = time.Now()
+	if err := m.store.AddAudit(a); err != nil {
+		m.log.WithField("target", a.Target).Errorf("add audit %s: %v", a.Action, err)
+	}
+}
+
+// SetDesired 修改设备的期望参数并同步, 值为空表示删除该参数(使用分组参数)
+// 设备离线时在上线后同步
+func (m *Manager) SetDesired(operator, mark string, p Params) error {
+	return m.update(mark, true, func(d *Device) error {
+		if d.Desired == nil {
+			d.Desired = Params{}
+		}
+		changes := d.Desired.Merge(p)
+		if len(changes) == 0 {
+			return nil
+		}
+		m.audit(&Audit{Target: mark, Operator: operator, Action: ActionDesired, Changes: changes})
+		d.State, d.Attempts, d.Error = StatePending, 0, ""
+		return m.sync(d)
+	})
+}
+
+// Assign 修改设备分组并同步, group为空表示不属于任何分组
+func (m *Manager) Assign(operator, mark, group string) error {
+	return m.update(mark, true, func(d *Device) error {
+		if d.Group == group {
+			return nil
+		}
+		m.audit(&Audit{Target: mark, Operator: operator, Action: ActionAssign, Note: d.Group + " -> " + group})
+		d.Group = group
+		d.State, d.Attempts, d.Error = StatePending, 0, ""
+		return m.sync(d)
+	})
+}
+
+// SetGroup 修改分组参数, 值为空表示删除该参数, 分组中的设备都会重新同步
+func (m *Manager) SetGroup(operator, group string, p Params) error {
+	unlock := lockKey(&m.groupLocks, group)
+	defer unlock()
+
+	var changes map[string]Change
+	for i := 1; ; i++ {
+		g, err := m.store.LoadGroup(group)
+		if err != nil {
+			return err
+		}
+		var prev time.Time
+		if g == nil {
+			g = &Group{Name: group}
+		} else {
+			prev = g.UpdatedAt
+		}
+		if g.Params == nil {
+			g.Params = Params{}
+		}
+		if changes = g.Params.Merge(p); len(changes) == 0 {
+			return nil
+		}
+		g.UpdatedAt = time.Now()
+		err = m.store.SaveGroup(g, prev)
+		if errors.Is(err, ErrConflict) && i < saveRetries {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		break
+	}
+	m.audit(&Audit{Target: GroupTarget(group), Operator: operator, Action: ActionGroup, Changes: changes})
+
+	marks, err := m.store.Members(group)
+	if err != nil {
+		return err
+	}
+	for _, mark := range marks {
+		if err := m.resync(mark, true); err != nil && !errors.Is(err, ErrOffline) {
+			m.log.WithField("mark", mark).Warnf("sync after group change: %v", err)
+		}
+	}
+	return nil
+}
+
+// Group 分组参数
+func (m *Manager) Group(name string) (Params, error) {
+	g, err := m.store.LoadGroup(name)
+	if err != nil {
+		return nil, err
+	}
+	if g == nil || g.Params == nil {
+		return Params{}, nil
+	}
+	return g.Params, nil
+}
+
+// Status 设备参数状态
+func (m *Manager) Status(mark string) (*Status, error) {
+	d, err := m.store.Load(mark)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return nil, fmt.Errorf("%w: [%s]", ErrNotFound, mark)
+	}
+	eff, err := m.effective(d)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{Device: d, Effective: eff, Diff: Diff(eff, d.Reported)}, nil
+}
+
+// Audits 最近的审计记录, target为设备标记或 GroupTarget(name)
+func (m *Manager) Audits(target string, limit int) ([]*Audit, error) {
+	return m.store.Audits(target, limit)
+}
+
+// Sync 立即同步设备参数, 设备离线时返回 ErrOffline
+func (m *Manager) Sync(mark string) error {
+	return m.resync(mark, false)
+}
+
+// Connect 设备上线时调用, 重新读取设备参数并同步, 包括之前同步失败的设备
+func (m *Manager) Connect(mark string) {
+	if err := m.resync(mark, true); err != nil && !errors.Is(err, ErrOffline) {
+		m.log.WithField("mark", mark).Warnf("sync on connect: %v", err)
+	}
+}
+
+// resync 开始新一轮同步, reread为true时重新读取设备参数
+// 没有参数记录的设备不处理
+func (m *Manager) resync(mark string, reread bool) error {
+	return m.update(mark, false, func(d *Device) error {
+		if reread {
+			d.Reported = nil
+		}
+		d.State, d.Attempts, d.Error = StatePending, 0, ""
+		return m.sync(d)
+	})
+}
+
+// sync 根据设备上报的参数推进同步, 调用方持有设备锁, 结束时保存设备记录
+// 保存时记录已经被其它节点修改返回 ErrConflict, 调用方重新加载后重试
+func (m *Manager) sync(d *Device) (err error) {
+	now, prev := time.Now(), d.UpdatedAt
+	defer func() {
+		d.UpdatedAt = now
+		if e := m.store.Save(d, prev); e != nil && (err == nil || errors.Is(err, ErrOffline)) {
+			err = fmt.Errorf("devconf: save [%s]: %w", d.Mark, e)
+		}
+	}()
+
+	if !m.driver.CheckOnline(d.Mark) {
+		if !d.State.synced() {
+			d.State, d.Deadline = StatePending, time.Time{}
+		}
+		return ErrOffline
+	}
+	eff, err := m.effective(d)
+	if err != nil {
+		return err
+	}
+
+	var diff Params
+	if d.Reported != nil {
+		if diff = Diff(eff, d.Reported); len(diff) == 0 {
+			if d.State == StateVerifying {
+				m.audit(&Audit{Target: d.Mark, Operator: OperatorSystem, Action: ActionVerified})
+				devconfCounter.WithLabelValues(m.name, "verified").Inc()
+			}
+			d.State, d.Attempts, d.Error, d.Deadline = StateSynced, 0, "", time.Time{}
+			return nil
+		}
+	}
+	if m.maxAttempts > 0 && d.Attempts >= m.maxAttempts {
+		if d.State != StateFailed {
+			d.Error = "mismatch: " + strings.Join(diff.Keys(), ",")
+			if d.Reported == nil {
+				d.Error = "no report"
+			}
+			m.audit(&Audit{Target: d.Mark, Operator: OperatorSystem, Action: ActionFailed, Note: d.Error})
+			devconfCounter.WithLabelValues(m.name, "failed").Inc()
+		}
+		d.State, d.Deadline = StateFailed, time.Time{}
+		return nil
+	}
+
+	// 没有读取过设备参数时只读取, 否则下发不一致的参数后回读
+	state, keys := StateReading, eff.Keys()
+	if d.Reported != nil {
+		msg, err := m.codec.Set(d.Mark, diff)
+		if err == nil {
+			err = m.driver.Send(msg)
+		}
+		if err != nil {
+			devconfCounter.WithLabelValues(m.name, "error").Inc()
+			d.State, d.Error = StatePending, err.Error()
+			return fmt.Errorf("devconf: [%s] push: %w", d.Mark, err)
+		}
+		changes := make(map[string]Change, len(diff))
+		for k, v := range diff {
+			changes[k] = Change{Old: d.Reported[k], New: v}
+		}
+		m.audit(&Audit{Target: d.Mark, Operator: OperatorSystem, Action: ActionPush, Changes: changes})
+		devconfCounter.WithLabelValues(m.name, "push").Inc()
+		state, keys = StateVerifying, diff.Keys()
+	}
+
+	msg, err := m.codec.Read(d.Mark, keys)
+	if err == nil {
+		err = m.driver.Send(msg)
+	}
+	if err != nil {
+		devconfCounter.WithLabelValues(m.name, "error").Inc()
+		d.State, d.Error = StatePending, err.Error()
+		return fmt.Errorf("devconf: [%s] read: %w", d.Mark, err)
+	}
+	devconfCounter.WithLabelValues(m.name, "read").Inc()
+	d.State, d.Error = state, ""
+	d.Attempts++
+	d.Deadline = now.Add(m.replyTimeout)
+	return nil
+}
+
+// Reply 处理设备上行报文, 是参数上报时更新设备参数并继续同步, 返回true
+// 已经同步的设备上报的参数和期望不一致(如设备本地修改)时重新下发
+func (m *Manager) Reply(msg driver.Msg) bool {
+	r, ok := m.codec.Report(msg)
+	if !ok {
+		return false
+	}
+	if r.Mark == "" {
+		r.Mark = msg.GetMark()
+	}
+
+	// 没有管理参数的设备, 不记录
+	err := m.update(r.Mark, false, func(d *Device) error {
+		if d.Reported == nil {
+			d.Reported = Params{}
+		}
+		for k, v := range r.Params {
+			d.Reported[k] = v
+		}
+		d.ReportedAt = time.Now()
+		if d.State == StateSynced {
+			d.Attempts = 0
+		}
+		return m.sync(d)
+	})
+	if err != nil && !errors.Is(err, ErrOffline) {
+		m.log.WithField("mark", r.Mark).Warnf("sync on report: %v", err)
+	}
+	return true
+}
+
+// Interceptor 翻译拦截器, 从协议转发的消息中识别参数上报
+// consume为true时参数上报不再转发
+func (m *Manager) Interceptor(consume bool) driver.TranslateInterceptor {
+	return interceptor.Reply(m.Reply, consume)
+}
+
+// Run 定时同步待同步的设备: 离线后上线的设备, 以及超时未上报参数的设备, ctx结束后退出
+func (m *Manager) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		marks, err := m.store.Unsynced()
+		if err != nil {
+			m.log.Errorf("load unsynced: %v", err)
+			continue
+		}
+		for _, mark := range marks {
+			if err := m.retry(mark); err != nil && !errors.Is(err, ErrOffline) {
+				m.log.WithField("mark", mark).Warnf("retry: %v", err)
+			}
+		}
+	}
+}
+
+func (m *Manager) retry(mark string) error {
+	if !m.driver.CheckOnline(mark) {
+		return nil
+	}
+	return m.update(mark, false, func(d *Device) error {
+		if d.State.synced() {
+			return nil
+		}
+		if d.State != StatePending && time.Now().Before(d.Deadline) {
+			return nil
+		}
+		return m.sync(d)
+	})
+}
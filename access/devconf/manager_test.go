@@ -0,0 +1,231 @@
+package devconf
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// testDevice 模拟设备: 记录下发的操作, 设置报文直接修改设备参数
+type testDevice struct {
+	online bool
+	stuck  bool // 不应用设置
+	params Params
+	sent   []string
+}
+
+func (d *testDevice) Run() error                              { return nil }
+func (d *testDevice) Stop()                                   {}
+func (d *testDevice) CheckOnline(string) bool                 { return d.online }
+func (d *testDevice) Disconnector(string, string)             {}
+func (d *testDevice) TraficSize(string) (int32, int32, error) { return 0, 0, nil }
+func (d *testDevice) Debug(string)                            {}
+
+func (d *testDevice) Send(msg driver.Msg) error {
+	op := msg.GetSource().(string)
+	d.sent = append(d.sent, op)
+	if op == "set" && !d.stuck {
+		for k, v := range msg.GetMsg().(Params) {
+			d.params[k] = v
+		}
+	}
+	return nil
+}
+
+// testCodec 设置/读取报文的内容为参数或key, 源数据为操作名
+type testCodec struct{}
+
+func (testCodec) Set(mark string, p Params) (driver.Msg, error) {
+	return driver.NewMsg(mark, p, "set"), nil
+}
+
+func (testCodec) Read(mark string, keys []string) (driver.Msg, error) {
+	return driver.NewMsg(mark, keys, "read"), nil
+}
+
+func (testCodec) Report(msg driver.Msg) (Report, bool) {
+	p, ok := msg.GetMsg().(Params)
+	return Report{Params: p}, ok && msg.GetSource() == "report"
+}
+
+func newTestManager(dev *testDevice, s Store) *Manager {
+	return New("test", dev, testCodec{}, s).SetReplyTimeout(time.Millisecond)
+}
+
+func report(m *Manager, dev *testDevice, mark string) {
+	m.Reply(driver.NewMsg(mark, dev.params.Clone(), "report"))
+}
+
+func mustState(t *testing.T, m *Manager, mark string, want State) *Status {
+	t.Helper()
+	st, err := m.Status(mark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.State != want {
+		t.Fatalf("state = %s, want %s (%+v)", st.State, want, st.Device)
+	}
+	return st
+}
+
+func TestSyncTransitions(t *testing.T) {
+	cases := []struct {
+		name    string
+		online  bool
+		stuck   bool
+		reports int
+		want    State
+		wantHB  string
+	}{
+		{name: "offline pending", online: false, want: StatePending, wantHB: "10"},
+		{name: "read before report", online: true, reports: 0, want: StateReading, wantHB: "10"},
+		{name: "push then verify", online: true, reports: 1, want: StateVerifying, wantHB: "30"},
+		{name: "verified", online: true, reports: 2, want: StateSynced, wantHB: "30"},
+		{name: "failed after max attempts", online: true, stuck: true, reports: 5, want: StateFailed, wantHB: "10"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dev := &testDevice{online: c.online, stuck: c.stuck, params: Params{"hb": "10"}}
+			m := newTestManager(dev, NewMemoryStore())
+			err := m.SetDesired("ops", "A", Params{"hb": "30"})
+			if !c.online && !errors.Is(err, ErrOffline) {
+				t.Fatalf("err = %v, want ErrOffline", err)
+			} else if c.online && err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < c.reports; i++ {
+				report(m, dev, "A")
+			}
+			mustState(t, m, "A", c.want)
+			if dev.params["hb"] != c.wantHB {
+				t.Fatalf("device hb = %s, want %s (sent %v)", dev.params["hb"], c.wantHB, dev.sent)
+			}
+		})
+	}
+}
+
+func TestConnectRetriesFailed(t *testing.T) {
+	dev := &testDevice{online: true, stuck: true, params: Params{"hb": "10"}}
+	m := newTestManager(dev, NewMemoryStore())
+	if err := m.SetDesired("ops", "A", Params{"hb": "30"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		report(m, dev, "A")
+	}
+	mustState(t, m, "A", StateFailed)
+
+	dev.stuck = false
+	m.Connect("A")
+	report(m, dev, "A")
+	report(m, dev, "A")
+	mustState(t, m, "A", StateSynced)
+}
+
+func TestGroupOverride(t *testing.T) {
+	dev := &testDevice{online: true, params: Params{"hb": "10", "qr": "x"}}
+	m := newTestManager(dev, NewMemoryStore())
+	if err := m.SetGroup("ops", "g1", Params{"hb": "30", "qr": "g"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Assign("ops", "A", "g1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetDesired("ops", "A", Params{"qr": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	report(m, dev, "A")
+	report(m, dev, "A")
+	st := mustState(t, m, "A", StateSynced)
+	if st.Effective["hb"] != "30" || st.Effective["qr"] != "a" {
+		t.Fatalf("effective = %v", st.Effective)
+	}
+
+	// 分组修改后成员重新同步
+	if err := m.SetGroup("ops", "g1", Params{"hb": "60"}); err != nil {
+		t.Fatal(err)
+	}
+	report(m, dev, "A")
+	report(m, dev, "A")
+	mustState(t, m, "A", StateSynced)
+	if dev.params["hb"] != "60" {
+		t.Fatalf("device hb = %s, want 60", dev.params["hb"])
+	}
+}
+
+func TestMemoryStoreConflict(t *testing.T) {
+	t0 := time.Now()
+	t1 := t0.Add(time.Second)
+	cases := []struct {
+		name    string
+		exists  bool
+		prev    time.Time
+		wantErr error
+	}{
+		{name: "create", exists: false, prev: time.Time{}},
+		{name: "create over existing", exists: true, prev: time.Time{}, wantErr: ErrConflict},
+		{name: "update", exists: true, prev: t0},
+		{name: "stale update", exists: true, prev: t1, wantErr: ErrConflict},
+		{name: "update missing", exists: false, prev: t0, wantErr: ErrConflict},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			if c.exists {
+				if err := s.Save(&Device{Mark: "A", UpdatedAt: t0}, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.SaveGroup(&Group{Name: "g", UpdatedAt: t0}, time.Time{}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := s.Save(&Device{Mark: "A", UpdatedAt: t1}, c.prev); err != c.wantErr {
+				t.Fatalf("save device err = %v, want %v", err, c.wantErr)
+			}
+			if err := s.SaveGroup(&Group{Name: "g", UpdatedAt: t1}, c.prev); err != c.wantErr {
+				t.Fatalf("save group err = %v, want %v", err, c.wantErr)
+			}
+		})
+	}
+}
+
+// conflictStore 前n次保存设备记录时模拟其它节点已经修改
+type conflictStore struct {
+	*MemoryStore
+	n int
+}
+
+func (s *conflictStore) Save(d *Device, prev time.Time) error {
+	if s.n > 0 {
+		s.n--
+		return ErrConflict
+	}
+	return s.MemoryStore.Save(d, prev)
+}
+
+func TestUpdateRetriesConflict(t *testing.T) {
+	cases := []struct {
+		name      string
+		conflicts int
+		wantErr   error
+	}{
+		{name: "no conflict", conflicts: 0},
+		{name: "retry once", conflicts: 1},
+		{name: "give up", conflicts: saveRetries, wantErr: ErrConflict},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			dev := &testDevice{online: true, params: Params{"hb": "10"}}
+			m := newTestManager(dev, &conflictStore{MemoryStore: NewMemoryStore(), n: c.conflicts})
+			err := m.SetDesired("ops", "A", Params{"hb": "30"})
+			if c.wantErr == nil && err != nil || c.wantErr != nil && !errors.Is(err, c.wantErr) {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+			if c.wantErr == nil {
+				mustState(t, m, "A", StateReading)
+			}
+		})
+	}
+}
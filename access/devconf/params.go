@@ -0,0 +1,140 @@
+package devconf
+
+import (
+	"sort"
+	"time"
+)
+
+// Params 设备参数, 值统一用字符串表示, 由协议的 Codec 转换为报文字段
+type Params map[string]string
+
+// Clone 复制参数
+func (p Params) Clone() Params {
+	if p == nil {
+		return nil
+	}
+	ret := make(Params, len(p))
+	for k, v := range p {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Keys 排序后的参数名
+func (p Params) Keys() []string {
+	ret := make([]string, 0, len(p))
+	for k := range p {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Merge 合并参数, 值为空表示删除该参数, 返回变化的参数
+func (p Params) Merge(changes Params) map[string]Change {
+	ret := make(map[string]Change)
+	for k, v := range changes {
+		old, ok := p[k]
+		if v == "" {
+			if ok {
+				delete(p, k)
+				ret[k] = Change{Old: old}
+			}
+			continue
+		}
+		if !ok || old != v {
+			p[k] = v
+			ret[k] = Change{Old: old, New: v}
+		}
+	}
+	return ret
+}
+
+// Diff 期望参数中和设备上报不一致(或设备没有上报)的参数
+func Diff(desired, reported Params) Params {
+	ret := make(Params)
+	for k, v := range desired {
+		if r, ok := reported[k]; !ok || r != v {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+// Change 参数变化, Old/New为空表示新增/删除
+type Change struct {
+	Old string `json:"old,omitempty"`
+	New string `json:"new,omitempty"`
+}
+
+// State 设备参数同步状态
+type State string
+
+const (
+	StatePending   State = "pending"   // 需要同步(设备离线时等待上线)
+	StateReading   State = "reading"   // 已下发读取, 等待设备上报
+	StateVerifying State = "verifying" // 已下发设置和读取, 等待回读校验
+	StateSynced    State = "synced"    // 设备参数和期望一致
+	StateFailed    State = "failed"    // 超过最大次数仍不一致, 设备重新上线或修改参数后重试
+)
+
+// synced 是否不需要继续同步
+func (s State) synced() bool {
+	return s == StateSynced || s == StateFailed
+}
+
+// Device 设备参数记录
+type Device struct {
+	Mark       string    `json:"mark"`
+	Group      string    `json:"group,omitempty"`
+	Desired    Params    `json:"desired,omitempty"`  // 设备自己的期望参数, 覆盖分组参数
+	Reported   Params    `json:"reported,omitempty"` // 设备上报的参数, nil表示还没有读取过
+	ReportedAt time.Time `json:"reported_at,omitempty"`
+	State      State     `json:"state"`
+	Attempts   int       `json:"attempts"` // 本轮同步的下发次数
+	Deadline   time.Time `json:"deadline,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
+
+// Group 分组参数记录
+type Group struct {
+	Name      string    `json:"name"`
+	Params    Params    `json:"params"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Status 设备参数状态
+type Status struct {
+	*Device
+	Effective Params `json:"effective"` // 分组参数和设备参数合并后的期望参数
+	Diff      Params `json:"diff"`      // 期望参数中和设备上报不一致的参数
+}
+
+// 审计动作
+const (
+	ActionDesired  = "desired"  // 修改设备期望参数
+	ActionGroup    = "group"    // 修改分组参数
+	ActionAssign   = "assign"   // 修改设备分组
+	ActionPush     = "push"     // 下发设置
+	ActionVerified = "verified" // 回读校验一致
+	ActionFailed   = "failed"   // 同步失败
+)
+
+// OperatorSystem 自动同步的操作人
+const OperatorSystem = "system"
+
+// Audit 参数变更审计记录
+type Audit struct {
+	Time     time.Time         `json:"time"`
+	Target   string            `json:"target"` // 设备标记, 分组为 group:{name}
+	Operator string            `json:"operator"`
+	Action   string            `json:"action"`
+	Changes  map[string]Change `json:"changes,omitempty"`
+	Note     string            `json:"note,omitempty"`
+}
+
+// GroupTarget 分组的审计目标
+func GroupTarget(name string) string {
+	return "group:" + name
+}
@@ -0,0 +1,34 @@
+package devconf
+
+import "time"
+
+// Store 参数持久化
+type Store interface {
+	// Load 获取设备记录, 不存在时返回nil, nil
+	Load(mark string) (*Device, error)
+
+	// Save 保存设备记录, 同时维护分组成员和待同步设备
+	// 已保存的 UpdatedAt 和prev不一致时不保存, 返回 ErrConflict; prev为零值表示记录还不存在
+	Save(d *Device, prev time.Time) error
+
+	// Unsynced 需要继续同步(State不是synced/failed)的设备
+	Unsynced() ([]string, error)
+
+	// LoadGroup 获取分组参数, 不存在时返回nil, nil
+	LoadGroup(name string) (*Group, error)
+
+	// SaveGroup 保存分组参数, 和 Save 一样按prev比较 UpdatedAt
+	SaveGroup(g *Group, prev time.Time) error
+
+	// Members 分组中的设备
+	Members(group string) ([]string, error)
+
+	// AddAudit 添加审计记录, 每个目标只保留最近的记录
+	AddAudit(a *Audit) error
+
+	// Audits 目标最近的审计记录, 从新到旧
+	Audits(target string, limit int) ([]*Audit, error)
+}
+
+// maxAudits 每个目标保留的审计记录数
+const maxAudits = 1000
@@ -0,0 +1,145 @@
+package devconf
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryStore 内存存储, 进程重启后数据丢失, 用于测试和单机部署
+type MemoryStore struct {
+	mu      sync.Mutex
+	devices map[string]*Device
+	groups  map[string]*Group
+	members map[string]map[string]struct{}
+	audits  map[string][]*Audit // target -> 从旧到新
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		devices: make(map[string]*Device),
+		groups:  make(map[string]*Group),
+		members: make(map[string]map[string]struct{}),
+		audits:  make(map[string][]*Audit),
+	}
+}
+
+// Load 获取设备记录
+func (s *MemoryStore) Load(mark string) (*Device, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if d, ok := s.devices[mark]; ok {
+		return copyDevice(d), nil
+	}
+	return nil, nil
+}
+
+// Save 保存设备记录, 比较 UpdatedAt 后写入
+func (s *MemoryStore) Save(d *Device, prev time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.devices[d.Mark]
+	if ok && !old.UpdatedAt.Equal(prev) || !ok && !prev.IsZero() {
+		return ErrConflict
+	}
+	if ok && old.Group != d.Group {
+		delete(s.members[old.Group], d.Mark)
+		if len(s.members[old.Group]) == 0 {
+			delete(s.members, old.Group)
+		}
+	}
+	if d.Group != "" {
+		if s.members[d.Group] == nil {
+			s.members[d.Group] = make(map[string]struct{})
+		}
+		s.members[d.Group][d.Mark] = struct{}{}
+	}
+	s.devices[d.Mark] = copyDevice(d)
+	return nil
+}
+
+// Unsynced 需要继续同步的设备
+func (s *MemoryStore) Unsynced() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]string, 0)
+	for mark, d := range s.devices {
+		if !d.State.synced() {
+			ret = append(ret, mark)
+		}
+	}
+	return ret, nil
+}
+
+// LoadGroup 获取分组参数
+func (s *MemoryStore) LoadGroup(name string) (*Group, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if g, ok := s.groups[name]; ok {
+		return copyGroup(g), nil
+	}
+	return nil, nil
+}
+
+// SaveGroup 保存分组参数, 比较 UpdatedAt 后写入
+func (s *MemoryStore) SaveGroup(g *Group, prev time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	old, ok := s.groups[g.Name]
+	if ok && !old.UpdatedAt.Equal(prev) || !ok && !prev.IsZero() {
+		return ErrConflict
+	}
+	s.groups[g.Name] = copyGroup(g)
+	return nil
+}
+
+// Members 分组中的设备
+func (s *MemoryStore) Members(group string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]string, 0, len(s.members[group]))
+	for mark := range s.members[group] {
+		ret = append(ret, mark)
+	}
+	return ret, nil
+}
+
+// AddAudit 添加审计记录
+func (s *MemoryStore) AddAudit(a *Audit) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := append(s.audits[a.Target], a)
+	if len(list) > maxAudits {
+		list = list[len(list)-maxAudits:]
+	}
+	s.audits[a.Target] = list
+	return nil
+}
+
+// Audits 最近的审计记录
+func (s *MemoryStore) Audits(target string, limit int) ([]*Audit, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := s.audits[target]
+	ret := make([]*Audit, 0, len(list))
+	for i := len(list) - 1; i >= 0; i-- {
+		if limit > 0 && len(ret) >= limit {
+			break
+		}
+		ret = append(ret, list[i])
+	}
+	return ret, nil
+}
+
+func copyGroup(g *Group) *Group {
+	cp := *g
+	cp.Params = g.Params.Clone()
+	return &cp
+}
+
+func copyDevice(d *Device) *Device {
+	cp := *d
+	cp.Desired = d.Desired.Clone()
+	cp.Reported = d.Reported.Clone()
+	return &cp
+}
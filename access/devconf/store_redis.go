@@ -0,0 +1,210 @@
+package devconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// KEYS: device unsynced
+// ARGV: mark device membersPrefix group unsynced(1/0) prev(updated_at, 空表示不存在)
+var saveScript = redis.NewScript(2, `
+local old = redis.call('GET', KEYS[1])
+if old then
+	local d = cjson.decode(old)
+	if d['updated_at'] ~= ARGV[6] then
+		return 0
+	end
+	local g = d['group']
+	if g and g ~= '' and g ~= ARGV[4] then
+		redis.call('SREM', ARGV[3] .. g, ARGV[1])
+	end
+elseif ARGV[6] ~= '' then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[2])
+if ARGV[4] ~= '' then
+	redis.call('SADD', ARGV[3] .. ARGV[4], ARGV[1])
+end
+if ARGV[5] == '1' then
+	redis.call('SADD', KEYS[2], ARGV[1])
+else
+	redis.call('SREM', KEYS[2], ARGV[1])
+end
+return 1
+`)
+
+// KEYS: group
+// ARGV: group prev(updated_at, 空表示不存在)
+var saveGroupScript = redis.NewScript(1, `
+local old = redis.call('GET', KEYS[1])
+if old then
+	if cjson.decode(old)['updated_at'] ~= ARGV[2] then
+		return 0
+	end
+elseif ARGV[2] ~= '' then
+	return 0
+end
+redis.call('SET', KEYS[1], ARGV[1])
+return 1
+`)
+
+// timeArg 脚本中比较的 updated_at, 和json编码一致, 零值为空
+func timeArg(t time.Time) (string, error) {
+	if t.IsZero() {
+		return "", nil
+	}
+	b, err := t.MarshalJSON()
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(string(b), `"`), nil
+}
+
+// RedisStore redis存储, 使用 redigo 连接池
+//
+//	{prefix}:dev:{mark}         string  设备记录json
+//	{prefix}:unsynced           set     待同步的设备
+//	{prefix}:group:{name}       string  分组记录json
+//	{prefix}:members:{name}     set     分组中的设备
+//	{prefix}:audit:{target}     list    审计记录json, 从新到旧
+type RedisStore struct {
+	prefix string
+}
+
+// NewRedisStore 创建redis存储, prefix为空时使用 access:devconf
+func NewRedisStore(prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "access:devconf"
+	}
+	return &RedisStore{prefix: prefix}
+}
+
+// Load 获取设备记录
+func (s *RedisStore) Load(mark string) (*Device, error) {
+	b, err := redis.Bytes(redigo.Do("GET", s.prefix+":dev:"+mark))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	d := &Device{}
+	if err = json.Unmarshal(b, d); err != nil {
+		return nil, fmt.Errorf("devconf: decode device[%s]: %w", mark, err)
+	}
+	return d, nil
+}
+
+// Save 保存设备记录, 和已保存的 updated_at 比较后写入
+func (s *RedisStore) Save(d *Device, prev time.Time) error {
+	b, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	unsynced := "0"
+	if !d.State.synced() {
+		unsynced = "1"
+	}
+	updatedAt, err := timeArg(prev)
+	if err != nil {
+		return err
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	saved, err := redis.Bool(saveScript.Do(rd, s.prefix+":dev:"+d.Mark, s.prefix+":unsynced",
+		d.Mark, b, s.prefix+":members:", d.Group, unsynced, updatedAt))
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Unsynced 需要继续同步的设备
+func (s *RedisStore) Unsynced() ([]string, error) {
+	return redis.Strings(redigo.Do("SMEMBERS", s.prefix+":unsynced"))
+}
+
+// LoadGroup 获取分组参数
+func (s *RedisStore) LoadGroup(name string) (*Group, error) {
+	b, err := redis.Bytes(redigo.Do("GET", s.prefix+":group:"+name))
+	if err == redis.ErrNil {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	g := &Group{}
+	if err = json.Unmarshal(b, g); err != nil {
+		return nil, fmt.Errorf("devconf: decode group[%s]: %w", name, err)
+	}
+	return g, nil
+}
+
+// SaveGroup 保存分组参数, 和已保存的 updated_at 比较后写入
+func (s *RedisStore) SaveGroup(g *Group, prev time.Time) error {
+	b, err := json.Marshal(g)
+	if err != nil {
+		return err
+	}
+	updatedAt, err := timeArg(prev)
+	if err != nil {
+		return err
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	saved, err := redis.Bool(saveGroupScript.Do(rd, s.prefix+":group:"+g.Name, b, updatedAt))
+	if err != nil {
+		return err
+	}
+	if !saved {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Members 分组中的设备
+func (s *RedisStore) Members(group string) ([]string, error) {
+	return redis.Strings(redigo.Do("SMEMBERS", s.prefix+":members:"+group))
+}
+
+// AddAudit 添加审计记录
+func (s *RedisStore) AddAudit(a *Audit) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	key := s.prefix + ":audit:" + a.Target
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	rd.Send("MULTI")
+	rd.Send("LPUSH", key, b)
+	rd.Send("LTRIM", key, 0, maxAudits-1)
+	_, err = rd.Do("EXEC")
+	return err
+}
+
+// Audits 最近的审计记录
+func (s *RedisStore) Audits(target string, limit int) ([]*Audit, error) {
+	if limit <= 0 || limit > maxAudits {
+		limit = maxAudits
+	}
+	vals, err := redis.ByteSlices(redigo.Do("LRANGE", s.prefix+":audit:"+target, 0, limit-1))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Audit, 0, len(vals))
+	for _, v := range vals {
+		a := &Audit{}
+		if err := json.Unmarshal(v, a); err != nil {
+			return nil, fmt.Errorf("devconf: decode audit of [%s]: %w", target, err)
+		}
+		ret = append(ret, a)
+	}
+	return ret, nil
+}
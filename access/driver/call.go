@@ -0,0 +1,343 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	// ErrCallTimeout 等待设备应答超时
+	ErrCallTimeout = errors.New("call timeout")
+
+	// ErrCallDisconnected 等待应答时设备断开
+	ErrCallDisconnected = errors.New("call disconnected")
+
+	// ErrCallDuplicate 相同关联键的请求还在等待应答
+	ErrCallDuplicate = errors.New("call duplicate key")
+)
+
+var (
+	callCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_call_total",
+		Help: "request/reply call count by caller/result",
+	}, []string{"caller", "result"})
+	orphanReplyCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_call_orphan_reply_total",
+		Help: "replies without waiting call (late or unknown)",
+	}, []string{"caller"})
+)
+
+// Correlator 请求/应答关联
+// 同一设备(mark)下, 请求和应答的关联键相同即为一对
+type Correlator interface {
+	// RequestKey 下行请求的关联键
+	RequestKey(msg Msg) (key string, err error)
+
+	// ReplyKey 上行报文的关联键, ok为false表示不是应答报文
+	ReplyKey(msg Msg) (key string, ok bool)
+}
+
+// SeqCorrelator 按报文序列号关联
+type SeqCorrelator struct {
+	// Seq 获取报文的序列号, ok为false表示报文没有序列号
+	Seq func(msg Msg) (seq uint64, ok bool)
+	// IsReply 判断上行报文是否为应答, 为nil时有序列号的上行报文都当做应答
+	IsReply func(msg Msg) bool
+}
+
+// RequestKey 请求的序列号
+func (c *SeqCorrelator) RequestKey(msg Msg) (string, error) {
+	seq, ok := c.Seq(msg)
+	if !ok {
+		return "", errors.New("request without seq")
+	}
+	return strconv.FormatUint(seq, 10), nil
+}
+
+// ReplyKey 应答的序列号
+func (c *SeqCorrelator) ReplyKey(msg Msg) (string, bool) {
+	if c.IsReply != nil && !c.IsReply(msg) {
+		return "", false
+	}
+	seq, ok := c.Seq(msg)
+	if !ok {
+		return "", false
+	}
+	return strconv.FormatUint(seq, 10), true
+}
+
+// CmdPairCorrelator 按命令码对关联, 如 0x33 下发启动 -> 0x34 启动应答
+// 同一设备同一命令同时只能有一个请求在等待
+type CmdPairCorrelator struct {
+	// Cmd 获取报文的命令码
+	Cmd func(msg Msg) (cmd string, ok bool)
+	// Pairs 请求命令码 -> 应答命令码
+	Pairs map[string]string
+}
+
+// RequestKey 请求期望的应答命令码
+func (c *CmdPairCorrelator) RequestKey(msg Msg) (string, error) {
+	cmd, ok := c.Cmd(msg)
+	if !ok {
+		return "", errors.New("request without cmd")
+	}
+	reply, ok := c.Pairs[cmd]
+	if !ok {
+		return "", fmt.Errorf("cmd[%s] has no reply pair", cmd)
+	}
+	return reply, nil
+}
+
+// ReplyKey 应答命令码
+func (c *CmdPairCorrelator) ReplyKey(msg Msg) (string, bool) {
+	cmd, ok := c.Cmd(msg)
+	if !ok {
+		return "", false
+	}
+	for _, reply := range c.Pairs {
+		if reply == cmd {
+			return cmd, true
+		}
+	}
+	return "", false
+}
+
+// OCPPCorrelator 按OCPP-J的UniqueId关联
+// CALL [2,"id","Action",{}] -> CALLRESULT [3,"id",{}] / CALLERROR [4,"id","code","desc",{}]
+type OCPPCorrelator struct{}
+
+func ocppFrame(msg Msg) (typ int, id string, ok bool) {
+	bs := MsgBytes(msg)
+	if len(bs) != 1 {
+		return 0, "", false
+	}
+	var frame []json.RawMessage
+	if err := json.Unmarshal(bs[0], &frame); err != nil || len(frame) < 3 {
+		return 0, "", false
+	}
+	if err := json.Unmarshal(frame[0], &typ); err != nil {
+		return 0, "", false
+	}
+	if err := json.Unmarshal(frame[1], &id); err != nil {
+		return 0, "", false
+	}
+	return typ, id, true
+}
+
+// RequestKey CALL的UniqueId
+func (OCPPCorrelator) RequestKey(msg Msg) (string, error) {
+	typ, id, ok := ocppFrame(msg)
+	if !ok || typ != 2 {
+		return "", errors.New("not ocpp call")
+	}
+	return id, nil
+}
+
+// ReplyKey CALLRESULT/CALLERROR的UniqueId
+func (OCPPCorrelator) ReplyKey(msg Msg) (string, bool) {
+	typ, id, ok := ocppFrame(msg)
+	if !ok || (typ != 3 && typ != 4) {
+		return "", false
+	}
+	return id, true
+}
+
+type pendingCall struct {
+	mark  string
+	key   string
+	reply chan Msg
+	err   chan error
+}
+
+// Caller 发送消息并等待设备应答
+//
+//	caller := driver.NewCaller("ykc", tcpDriver, &driver.CmdPairCorrelator{...})
+//	reply, err := caller.Call(ctx, driver.NewMsg(mark, raw))
+//
+// 协议收到应答后调用 Reply(或使用 Interceptor), 设备断开时调用 Disconnect
+type Caller struct {
+	name    string
+	driver  Driver
+	corr    Correlator
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[string]map[string]*pendingCall // mark -> key -> call
+}
+
+// NewCaller 创建Caller, name用于指标标签, 默认超时30秒
+func NewCaller(name string, d Driver, c Correlator) *Caller {
+	return &Caller{
+		name:    name,
+		driver:  d,
+		corr:    c,
+		timeout: 30 * time.Second,
+		pending: make(map[string]map[string]*pendingCall),
+	}
+}
+
+// SetTimeout 设置默认超时, ctx的deadline更早时以ctx为准
+func (c *Caller) SetTimeout(t time.Duration) *Caller {
+	c.timeout = t
+	return c
+}
+
+// Call 发送消息并等待应答
+func (c *Caller) Call(ctx context.Context, msg Msg) (reply Msg, err error) {
+	defer func() {
+		callCounter.WithLabelValues(c.name, callResult(err)).Inc()
+	}()
+
+	key, err := c.corr.RequestKey(msg)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := c.add(msg.GetMark(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer c.remove(pc)
+
+	if err = c.driver.Send(msg); err != nil {
+		return nil, err
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	select {
+	case reply = <-pc.reply:
+		return reply, nil
+	case err = <-pc.err:
+		return nil, err
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w: mark[%s] key[%s]", ErrCallTimeout, pc.mark, key)
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Reply 投递上行报文, 返回true表示是某个请求的应答并已投递
+// 没有对应请求的应答(超时后才到/未知)计入孤儿应答指标
+func (c *Caller) Reply(msg Msg) bool {
+	key, ok := c.corr.ReplyKey(msg)
+	if !ok {
+		return false
+	}
+
+	c.mu.Lock()
+	pc := c.pending[msg.GetMark()][key]
+	c.mu.Unlock()
+	if pc == nil {
+		orphanReplyCounter.WithLabelValues(c.name).Inc()
+		return false
+	}
+
+	select {
+	case pc.reply <- msg:
+		return true
+	default: // 重复应答
+		orphanReplyCounter.WithLabelValues(c.name).Inc()
+		return false
+	}
+}
+
+// Disconnect 设备断开, 所有等待中的请求返回 ErrCallDisconnected
+func (c *Caller) Disconnect(mark string) {
+	c.mu.Lock()
+	calls := c.pending[mark]
+	delete(c.pending, mark)
+	c.mu.Unlock()
+
+	for _, pc := range calls {
+		select {
+		case pc.err <- fmt.Errorf("%w: mark[%s]", ErrCallDisconnected, mark):
+		default:
+		}
+	}
+}
+
+// Pending 设备等待应答的请求数
+func (c *Caller) Pending(mark string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.pending[mark])
+}
+
+// Interceptor 翻译拦截器, 把协议输出的 tos 中的应答投递给等待的请求
+// consume为true时, 已投递的应答不再转发
+func (c *Caller) Interceptor(consume bool) TranslateInterceptor {
+	return func(ctx context.Context, acctx *Ctx, handler TranslateHandler) (tos []Msg, rets []Msg, err error) {
+		tos, rets, err = handler(ctx)
+		if err != nil {
+			return
+		}
+		n := 0
+		for _, to := range tos {
+			if c.Reply(to) && consume {
+				continue
+			}
+			tos[n] = to
+			n++
+		}
+		tos = tos[:n]
+		return
+	}
+}
+
+func (c *Caller) add(mark, key string) (*pendingCall, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	calls, ok := c.pending[mark]
+	if !ok {
+		calls = make(map[string]*pendingCall)
+		c.pending[mark] = calls
+	}
+	if _, ok := calls[key]; ok {
+		return nil, fmt.Errorf("%w: mark[%s] key[%s]", ErrCallDuplicate, mark, key)
+	}
+	pc := &pendingCall{
+		mark:  mark,
+		key:   key,
+		reply: make(chan Msg, 1),
+		err:   make(chan error, 1),
+	}
+	calls[key] = pc
+	return pc, nil
+}
+
+func (c *Caller) remove(pc *pendingCall) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if calls, ok := c.pending[pc.mark]; ok && calls[pc.key] == pc {
+		delete(calls, pc.key)
+		if len(calls) == 0 {
+			delete(c.pending, pc.mark)
+		}
+	}
+}
+
+func callResult(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, ErrCallTimeout):
+		return "timeout"
+	case errors.Is(err, ErrCallDisconnected):
+		return "disconnected"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
@@ -37,7 +37,8 @@ type Driver interface {
 	// Run 运行驱动
 	Run() (err error)
 
-	// Stop 停止
+	// Stop 停止, 立即断开所有连接
+	// 需要优雅关闭时驱动实现 Shutdowner, 调用方使用 driver.Shutdown
 	Stop()
 
 	// CheckOnline 检查是否在线
@@ -50,8 +51,11 @@ type Driver interface {
 	Send(msg Msg) (err error)
 
 	// TraficSize 流量统计
+	// int32计数会溢出, 新驱动使用 traffic.Accounting, 这里返回 Accounting.TraficSize
 	TraficSize(mark string) (recv, send int32, err error)
 
+	// Debug 打印调试信息到日志
+	// 新驱动使用 debughttp.Register 注册会话数据, 通过http查看
 	Debug(t string)
 }
 
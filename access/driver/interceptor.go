@@ -0,0 +1,149 @@
+package driver
+
+import (
+	"context"
+)
+
+const (
+	// CtxDataCmd 协议解析出命令码后写入 Ctx.Data 的键
+	// 拦截器通过这个键获取命令码, 用于按命令统计/记录
+	CtxDataCmd = "cmd"
+
+	// CtxDataRemote 驱动创建上下文时写入的对端地址
+	CtxDataRemote = "remote"
+)
+
+// TranslateHandler 协议翻译函数
+type TranslateHandler func(ctx context.Context) (tos []Msg, rets []Msg, err error)
+
+// TranslateInterceptor 协议翻译拦截器
+// acctx 为当前报文的上下文(含原始报文 Raw), 拦截器通过调用 handler 执行后续翻译并拿到结果
+type TranslateInterceptor func(ctx context.Context, acctx *Ctx, handler TranslateHandler) (tos []Msg, rets []Msg, err error)
+
+// ChainTranslateInterceptors 把多个拦截器串成一个, 按传入顺序由外到内执行
+func ChainTranslateInterceptors(ints ...TranslateInterceptor) TranslateInterceptor {
+	switch len(ints) {
+	case 0:
+		return nil
+	case 1:
+		return ints[0]
+	}
+	return func(ctx context.Context, acctx *Ctx, handler TranslateHandler) ([]Msg, []Msg, error) {
+		return ints[0](ctx, acctx, chainHandler(ints[1:], acctx, handler))
+	}
+}
+
+func chainHandler(ints []TranslateInterceptor, acctx *Ctx, handler TranslateHandler) TranslateHandler {
+	if len(ints) == 0 {
+		return handler
+	}
+	return func(ctx context.Context) ([]Msg, []Msg, error) {
+		return ints[0](ctx, acctx, chainHandler(ints[1:], acctx, handler))
+	}
+}
+
+// interceptedProtocol 带拦截器的协议
+type interceptedProtocol struct {
+	Protocol
+	interceptor TranslateInterceptor
+}
+
+// WithInterceptors 给协议加上拦截器, 驱动无需改动, 直接使用返回的协议即可
+// 返回的协议会把 Inject 透传给原协议
+func WithInterceptors(p Protocol, ints ...TranslateInterceptor) Protocol {
+	if len(ints) == 0 {
+		return p
+	}
+	if ip, ok := p.(*interceptedProtocol); ok {
+		return &interceptedProtocol{
+			Protocol:    ip.Protocol,
+			interceptor: ChainTranslateInterceptors(append([]TranslateInterceptor{ip.interceptor}, ints...)...),
+		}
+	}
+	return &interceptedProtocol{Protocol: p, interceptor: ChainTranslateInterceptors(ints...)}
+}
+
+// Translate 执行拦截器链
+// 上下文中没有 Ctx 时(非驱动调用), 直接调用原协议
+func (p *interceptedProtocol) Translate(ctx context.Context) (tos []Msg, rets []Msg, err error) {
+	acctx := FromContext(ctx)
+	if acctx == nil {
+		return p.Protocol.Translate(ctx)
+	}
+	return p.interceptor(ctx, acctx, p.Protocol.Translate)
+}
+
+// Inject 透传注入
+func (p *interceptedProtocol) Inject(name string, v interface{}) Inject {
+	if in, ok := p.Protocol.(Inject); ok {
+		in.Inject(name, v)
+	}
+	return p
+}
+
+// Unwrap 获取原协议
+func (p *interceptedProtocol) Unwrap() Protocol {
+	return p.Protocol
+}
+
+// Unwrapper 包装了其它协议的协议实现(如 WithInterceptors 返回的协议)
+type Unwrapper interface {
+	Unwrap() Protocol
+}
+
+// Unwrap 逐层去掉包装, 返回最内层的原协议
+func Unwrap(p Protocol) Protocol {
+	for {
+		u, ok := p.(Unwrapper)
+		if !ok {
+			return p
+		}
+		p = u.Unwrap()
+	}
+}
+
+// As 从外到内在协议及其包装的协议中查找实现了T的协议
+// 检查协议是否实现了可选接口(如 Goodbyer)时使用, 不要直接类型断言, 协议可能已经被包装
+//
+//	if g, ok := driver.As[driver.Goodbyer](protocol); ok { ... }
+func As[T any](p Protocol) (T, bool) {
+	for p != nil {
+		if t, ok := p.(T); ok {
+			return t, true
+		}
+		u, ok := p.(Unwrapper)
+		if !ok {
+			break
+		}
+		p = u.Unwrap()
+	}
+	var zero T
+	return zero, false
+}
+
+// GetCmd 获取协议写入的命令码, 没有写入返回空
+func (ctx *Ctx) GetCmd() string {
+	return KeyCmd.Value(ctx)
+}
+
+// SetCmd 协议解析出命令码后调用, 供拦截器使用
+func (ctx *Ctx) SetCmd(cmd string) {
+	KeyCmd.Set(ctx, cmd)
+}
+
+// GetRemote 获取驱动写入的对端地址
+func (ctx *Ctx) GetRemote() string {
+	return KeyRemote.Value(ctx)
+}
+
+// MsgBytes 获取消息内容的字节
+// GetMsg 返回 []byte 或者 [][]byte, 其它类型返回nil
+func MsgBytes(m Msg) [][]byte {
+	switch v := m.GetMsg().(type) {
+	case []byte:
+		return [][]byte{v}
+	case [][]byte:
+		return v
+	}
+	return nil
+}
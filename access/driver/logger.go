@@ -0,0 +1,218 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zhuoqingbin/utils/lg"
+)
+
+// Logger 上下文日志, 字段保存在 lg.LogContext 中, 输出格式和 lg 一致
+// 提供 logrus.Entry 常用的方法(WithField/WithFields/WithError/Dup, Debug/Info/Warn/Error 及其f/ln形式),
+// 返回值为 *Logger, 需要 *logrus.Entry 的地方使用 Ctx.Log; 通过 Ctx.Logger 获取
+type Logger struct {
+	ctx context.Context
+}
+
+// NewLogger 从context创建日志, 继承context中的 lg.LogContext
+func NewLogger(ctx context.Context) *Logger {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Logger{ctx: ctx}
+}
+
+// Context 带日志字段的context, 可以直接用于 lg.Infoc 等
+func (l *Logger) Context() context.Context {
+	return l.ctx
+}
+
+// fieldFormat 字段的格式串, key中的%转义, 避免被当做格式化动词
+func fieldFormat(key string) string {
+	return strings.ReplaceAll(key, "%", "%%") + "=%v"
+}
+
+// WithField 添加字段, 返回新的日志对象
+func (l *Logger) WithField(key string, value interface{}) *Logger {
+	return &Logger{ctx: lg.With(l.ctx, fieldFormat(key), value)}
+}
+
+// WithFields 添加多个字段, 按key排序, fields 可以直接传 logrus.Fields
+func (l *Logger) WithFields(fields map[string]interface{}) *Logger {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ctx := l.ctx
+	for _, k := range keys {
+		ctx = lg.With(ctx, fieldFormat(k), fields[k])
+	}
+	return &Logger{ctx: ctx}
+}
+
+// WithError 添加error字段
+func (l *Logger) WithError(err error) *Logger {
+	return l.WithField("error", err)
+}
+
+// WithContext 使用新的context, 保留已经添加的字段
+func (l *Logger) WithContext(ctx context.Context) *Logger {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &Logger{ctx: lg.Derive(l.ctx, ctx)}
+}
+
+// Dup 复制日志对象, 字段不可变, 返回的对象和原对象互不影响
+func (l *Logger) Dup() *Logger {
+	return &Logger{ctx: l.ctx}
+}
+
+// sprintln 和 logrus 一致, 参数之间总是加空格, 去掉末尾的换行
+func sprintln(v ...interface{}) string {
+	return strings.TrimSuffix(fmt.Sprintln(v...), "\n")
+}
+
+// Trace trace日志, lg没有trace级别, 按debug输出
+func (l *Logger) Trace(v ...interface{}) {
+	lg.Debugc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Debug debug日志
+func (l *Logger) Debug(v ...interface{}) {
+	lg.Debugc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Print info日志
+func (l *Logger) Print(v ...interface{}) {
+	lg.Infoc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Info info日志
+func (l *Logger) Info(v ...interface{}) {
+	lg.Infoc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Warn warn日志
+func (l *Logger) Warn(v ...interface{}) {
+	lg.Warnc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Warning warn日志
+func (l *Logger) Warning(v ...interface{}) {
+	lg.Warnc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Error error日志
+func (l *Logger) Error(v ...interface{}) {
+	lg.Errorc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Fatal fatal日志, 输出后退出进程
+func (l *Logger) Fatal(v ...interface{}) {
+	lg.Fatalc(l.ctx, "%s", fmt.Sprint(v...))
+}
+
+// Panic error日志, 输出后panic
+func (l *Logger) Panic(v ...interface{}) {
+	msg := fmt.Sprint(v...)
+	lg.Errorc(l.ctx, "%s", msg)
+	panic(msg)
+}
+
+// Tracef trace日志, 按debug输出
+func (l *Logger) Tracef(format string, v ...interface{}) {
+	lg.Debugc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Debugf debug日志
+func (l *Logger) Debugf(format string, v ...interface{}) {
+	lg.Debugc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Infof info日志
+func (l *Logger) Infof(format string, v ...interface{}) {
+	lg.Infoc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Warnf warn日志
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	lg.Warnc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Errorf error日志
+func (l *Logger) Errorf(format string, v ...interface{}) {
+	lg.Errorc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Printf info日志
+func (l *Logger) Printf(format string, v ...interface{}) {
+	lg.Infoc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Warningf warn日志
+func (l *Logger) Warningf(format string, v ...interface{}) {
+	lg.Warnc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Fatalf fatal日志, 输出后退出进程
+func (l *Logger) Fatalf(format string, v ...interface{}) {
+	lg.Fatalc(l.ctx, "%s", fmt.Sprintf(format, v...))
+}
+
+// Panicf error日志, 输出后panic
+func (l *Logger) Panicf(format string, v ...interface{}) {
+	msg := fmt.Sprintf(format, v...)
+	lg.Errorc(l.ctx, "%s", msg)
+	panic(msg)
+}
+
+// Traceln trace日志, 按debug输出
+func (l *Logger) Traceln(v ...interface{}) {
+	lg.Debugc(l.ctx, "%s", sprintln(v...))
+}
+
+// Debugln debug日志
+func (l *Logger) Debugln(v ...interface{}) {
+	lg.Debugc(l.ctx, "%s", sprintln(v...))
+}
+
+// Println info日志
+func (l *Logger) Println(v ...interface{}) {
+	lg.Infoc(l.ctx, "%s", sprintln(v...))
+}
+
+// Infoln info日志
+func (l *Logger) Infoln(v ...interface{}) {
+	lg.Infoc(l.ctx, "%s", sprintln(v...))
+}
+
+// Warnln warn日志
+func (l *Logger) Warnln(v ...interface{}) {
+	lg.Warnc(l.ctx, "%s", sprintln(v...))
+}
+
+// Warningln warn日志
+func (l *Logger) Warningln(v ...interface{}) {
+	lg.Warnc(l.ctx, "%s", sprintln(v...))
+}
+
+// Errorln error日志
+func (l *Logger) Errorln(v ...interface{}) {
+	lg.Errorc(l.ctx, "%s", sprintln(v...))
+}
+
+// Fatalln fatal日志, 输出后退出进程
+func (l *Logger) Fatalln(v ...interface{}) {
+	lg.Fatalc(l.ctx, "%s", sprintln(v...))
+}
+
+// Panicln error日志, 输出后panic
+func (l *Logger) Panicln(v ...interface{}) {
+	msg := sprintln(v...)
+	lg.Errorc(l.ctx, "%s", msg)
+	panic(msg)
+}
@@ -0,0 +1,491 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// CtxDataSession 驱动创建上下文时写入的会话
+const CtxDataSession = "session"
+
+// DupPolicy 重复登录策略
+type DupPolicy int
+
+const (
+	// DupKickOld 踢掉旧连接
+	DupKickOld DupPolicy = iota
+	// DupRejectNew 拒绝新连接
+	DupRejectNew
+	// DupAllowMulti 允许同一设备多个连接
+	DupAllowMulti
+)
+
+// Reason 连接/断开原因
+type Reason string
+
+const (
+	ReasonLogin            Reason = "login"             // 登录
+	ReasonClosed           Reason = "closed"            // 对端关闭/网络错误
+	ReasonHeartbeatTimeout Reason = "heartbeat_timeout" // 心跳超时
+	ReasonIdleTimeout      Reason = "idle_timeout"      // 长时间没有业务报文
+	ReasonAuthTimeout      Reason = "auth_timeout"      // 连接后长时间未登录
+	ReasonDuplicate        Reason = "duplicate"         // 重复登录被踢
+	ReasonKick             Reason = "kick"              // 主动踢下线
+	ReasonShutdown         Reason = "shutdown"          // 服务停止
+)
+
+// EventType 会话事件类型
+type EventType string
+
+const (
+	EventConnect    EventType = "connect"
+	EventDisconnect EventType = "disconnect"
+)
+
+// SessionEvent 会话事件
+type SessionEvent struct {
+	Type    EventType
+	Reason  Reason
+	Session SessionInfo
+	Time    time.Time
+}
+
+// SessionInfo 会话快照
+type SessionInfo struct {
+	ID           string                 `json:"id"`
+	Mark         string                 `json:"mark"`
+	Driver       string                 `json:"driver"`
+	Remote       string                 `json:"remote"`
+	ProtoVersion string                 `json:"proto_version"`
+	Authed       bool                   `json:"authed"`
+	ConnectedAt  time.Time              `json:"connected_at"`
+	LastSeen     time.Time              `json:"last_seen"`
+	LastActive   time.Time              `json:"last_active"`
+	Attrs        map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// Session 一个设备连接
+type Session struct {
+	id     string
+	driver string
+	remote string
+	kick   func(reason string)
+
+	mu           sync.RWMutex
+	mark         string
+	protoVersion string
+	authed       bool
+	connectedAt  time.Time
+	lastSeen     time.Time
+	lastActive   time.Time
+	attrs        map[string]interface{}
+	values       map[string]interface{}
+	closeReason  Reason
+	closed       bool
+}
+
+// ID 连接id
+func (s *Session) ID() string {
+	return s.id
+}
+
+// Remote 对端地址
+func (s *Session) Remote() string {
+	return s.remote
+}
+
+// Mark 设备标记, 登录前为空
+func (s *Session) Mark() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mark
+}
+
+// Authed 是否已登录
+func (s *Session) Authed() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.authed
+}
+
+// Touch 收到报文(含心跳)时调用
+func (s *Session) Touch() {
+	s.mu.Lock()
+	s.lastSeen = time.Now()
+	s.mu.Unlock()
+}
+
+// Active 收到业务报文时调用
+func (s *Session) Active() {
+	now := time.Now()
+	s.mu.Lock()
+	s.lastSeen, s.lastActive = now, now
+	s.mu.Unlock()
+}
+
+// SetProtoVersion 设置协议版本
+func (s *Session) SetProtoVersion(v string) {
+	s.mu.Lock()
+	s.protoVersion = v
+	s.mu.Unlock()
+}
+
+// SetAttr 设置自定义属性
+func (s *Session) SetAttr(key string, val interface{}) {
+	s.mu.Lock()
+	s.attrs[key] = val
+	s.mu.Unlock()
+}
+
+// Attr 获取自定义属性
+func (s *Session) Attr(key string) (val interface{}, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, ok = s.attrs[key]
+	return
+}
+
+// SetValue 设置会话私有数据(如会话密钥), 不会出现在 Info 中
+func (s *Session) SetValue(key string, val interface{}) {
+	s.mu.Lock()
+	s.values[key] = val
+	s.mu.Unlock()
+}
+
+// Value 获取会话私有数据
+func (s *Session) Value(key string) (val interface{}, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	val, ok = s.values[key]
+	return
+}
+
+// Info 会话快照
+func (s *Session) Info() SessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	info := SessionInfo{
+		ID:           s.id,
+		Mark:         s.mark,
+		Driver:       s.driver,
+		Remote:       s.remote,
+		ProtoVersion: s.protoVersion,
+		Authed:       s.authed,
+		ConnectedAt:  s.connectedAt,
+		LastSeen:     s.lastSeen,
+		LastActive:   s.lastActive,
+	}
+	if len(s.attrs) > 0 {
+		info.Attrs = make(map[string]interface{}, len(s.attrs))
+		for k, v := range s.attrs {
+			info.Attrs[k] = v
+		}
+	}
+	return info
+}
+
+// Kick 断开连接, reason会作为断开事件的原因
+func (s *Session) Kick(reason Reason) {
+	s.mu.Lock()
+	if s.closed || s.closeReason != "" {
+		s.mu.Unlock()
+		return
+	}
+	s.closeReason = reason
+	s.mu.Unlock()
+	if s.kick != nil {
+		s.kick(string(reason))
+	}
+}
+
+// GetSession 获取驱动写入上下文的会话, 没有返回nil
+func (ctx *Ctx) GetSession() *Session {
+	return KeySession.Value(ctx)
+}
+
+// SessionManager 会话管理
+// 负责心跳超时/空闲/未登录超时踢下线, 以及重复登录处理
+//
+//	sm := driver.NewSessionManager("ykc", driver.DupKickOld).SetHeartbeatTimeout(3 * time.Minute)
+//	go sm.Run(ctx)
+//	// 新连接:   s := sm.Open(connID, conn.RemoteAddr().String(), func(reason string) { conn.Close() })
+//	// 登录成功: err := sm.Login(s, mark)
+//	// 连接关闭: sm.Close(s, driver.ReasonClosed)
+type SessionManager struct {
+	driver           string
+	policy           DupPolicy
+	heartbeatTimeout time.Duration
+	idleTimeout      time.Duration
+	authTimeout      time.Duration
+
+	mu       sync.RWMutex
+	sessions map[string]*Session   // id -> session
+	marks    map[string][]*Session // mark -> sessions
+	handlers []func(SessionEvent)
+}
+
+// NewSessionManager 创建会话管理, driverName为驱动名称
+func NewSessionManager(driverName string, policy DupPolicy) *SessionManager {
+	return &SessionManager{
+		driver:   driverName,
+		policy:   policy,
+		sessions: make(map[string]*Session),
+		marks:    make(map[string][]*Session),
+	}
+}
+
+// SetHeartbeatTimeout 超过时间没有收到任何报文踢下线, 0表示不检查
+func (sm *SessionManager) SetHeartbeatTimeout(t time.Duration) *SessionManager {
+	sm.heartbeatTimeout = t
+	return sm
+}
+
+// SetIdleTimeout 超过时间没有收到业务报文踢下线, 0表示不检查
+func (sm *SessionManager) SetIdleTimeout(t time.Duration) *SessionManager {
+	sm.idleTimeout = t
+	return sm
+}
+
+// SetAuthTimeout 连接后超过时间未登录踢下线, 0表示不检查
+func (sm *SessionManager) SetAuthTimeout(t time.Duration) *SessionManager {
+	sm.authTimeout = t
+	return sm
+}
+
+// OnEvent 注册会话事件回调, 回调在事件发生的协程中同步执行
+func (sm *SessionManager) OnEvent(f func(SessionEvent)) *SessionManager {
+	sm.mu.Lock()
+	sm.handlers = append(sm.handlers, f)
+	sm.mu.Unlock()
+	return sm
+}
+
+func (sm *SessionManager) emit(typ EventType, reason Reason, s *Session) {
+	sm.mu.RLock()
+	handlers := sm.handlers
+	sm.mu.RUnlock()
+	if len(handlers) == 0 {
+		return
+	}
+	ev := SessionEvent{Type: typ, Reason: reason, Session: s.Info(), Time: time.Now()}
+	for _, f := range handlers {
+		f(ev)
+	}
+}
+
+// Open 新连接, kick为驱动提供的断开连接函数
+func (sm *SessionManager) Open(id, remote string, kick func(reason string)) *Session {
+	now := time.Now()
+	s := &Session{
+		id:          id,
+		driver:      sm.driver,
+		remote:      remote,
+		kick:        kick,
+		connectedAt: now,
+		lastSeen:    now,
+		lastActive:  now,
+		attrs:       make(map[string]interface{}),
+		values:      make(map[string]interface{}),
+	}
+	sm.mu.Lock()
+	sm.sessions[id] = s
+	sm.mu.Unlock()
+	return s
+}
+
+// Login 连接登录成功, 按重复登录策略处理同一设备的其它连接
+// 策略为 DupRejectNew 且设备已有连接时返回 ErrAlreadySession, 连接已经关闭时返回 ErrSessionClosed
+func (sm *SessionManager) Login(s *Session, mark string) error {
+	sm.mu.Lock()
+	var olds []*Session
+	for _, old := range sm.marks[mark] {
+		if old != s {
+			olds = append(olds, old)
+		}
+	}
+	if len(olds) > 0 && sm.policy == DupRejectNew {
+		sm.mu.Unlock()
+		return fmt.Errorf("%w: mark[%s] remote[%s]", ErrAlreadySession, mark, olds[0].remote)
+	}
+
+	// Close 标记closed和读取mark在同一个s.mu临界区内, 这里检查closed和设置mark也一样
+	// 避免已关闭的连接重新绑定到设备
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		sm.mu.Unlock()
+		return fmt.Errorf("%w: mark[%s] remote[%s]", ErrSessionClosed, mark, s.remote)
+	}
+	prevMark := s.mark
+	s.mark, s.authed = mark, true
+	s.lastActive = time.Now()
+	s.mu.Unlock()
+	if prevMark != mark {
+		sm.unbind(prevMark, s)
+		sm.marks[mark] = append(sm.marks[mark], s)
+	}
+	sm.mu.Unlock()
+
+	if sm.policy == DupKickOld {
+		for _, old := range olds {
+			old.Kick(ReasonDuplicate)
+		}
+	}
+	if prevMark != mark {
+		sm.emit(EventConnect, ReasonLogin, s)
+	}
+	return nil
+}
+
+// unbind 需要持有sm.mu
+func (sm *SessionManager) unbind(mark string, s *Session) {
+	if mark == "" {
+		return
+	}
+	ss := sm.marks[mark]
+	for i, v := range ss {
+		if v == s {
+			ss = append(ss[:i], ss[i+1:]...)
+			break
+		}
+	}
+	if len(ss) == 0 {
+		delete(sm.marks, mark)
+	} else {
+		sm.marks[mark] = ss
+	}
+}
+
+// Close 连接已关闭, 由驱动在连接关闭后调用
+// 之前通过Kick断开的连接, 事件原因以Kick的原因为准
+func (sm *SessionManager) Close(s *Session, reason Reason) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	if s.closeReason != "" {
+		reason = s.closeReason
+	}
+	mark := s.mark
+	s.mu.Unlock()
+
+	sm.mu.Lock()
+	delete(sm.sessions, s.id)
+	sm.unbind(mark, s)
+	sm.mu.Unlock()
+
+	sm.emit(EventDisconnect, reason, s)
+}
+
+// Get 获取设备最新的连接
+func (sm *SessionManager) Get(mark string) *Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	if ss := sm.marks[mark]; len(ss) > 0 {
+		return ss[len(ss)-1]
+	}
+	return nil
+}
+
+// GetAll 获取设备所有连接
+func (sm *SessionManager) GetAll(mark string) []*Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return append([]*Session(nil), sm.marks[mark]...)
+}
+
+// GetByID 通过连接id获取会话
+func (sm *SessionManager) GetByID(id string) *Session {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return sm.sessions[id]
+}
+
+// CheckOnline 设备是否有已登录的连接
+func (sm *SessionManager) CheckOnline(mark string) bool {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.marks[mark]) > 0
+}
+
+// Sessions 所有连接(含未登录), 按连接时间排序
+func (sm *SessionManager) Sessions() []*Session {
+	sm.mu.RLock()
+	ret := make([]*Session, 0, len(sm.sessions))
+	for _, s := range sm.sessions {
+		ret = append(ret, s)
+	}
+	sm.mu.RUnlock()
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].connectedAt.Before(ret[j].connectedAt)
+	})
+	return ret
+}
+
+// Count 连接数和已登录设备数
+func (sm *SessionManager) Count() (conns, marks int) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	return len(sm.sessions), len(sm.marks)
+}
+
+// Kick 踢掉设备所有连接
+func (sm *SessionManager) Kick(mark string, reason Reason) {
+	for _, s := range sm.GetAll(mark) {
+		s.Kick(reason)
+	}
+}
+
+// Interceptor 翻译拦截器, 收到报文时刷新会话的最后活跃时间
+// 协议返回 ErrIgnore 的报文(如心跳)只刷新心跳时间
+func (sm *SessionManager) Interceptor() TranslateInterceptor {
+	return func(ctx context.Context, acctx *Ctx, handler TranslateHandler) (tos []Msg, rets []Msg, err error) {
+		if s := acctx.GetSession(); s != nil {
+			defer func() {
+				if err == nil && len(tos) > 0 {
+					s.Active()
+				} else {
+					s.Touch()
+				}
+			}()
+		}
+		return handler(ctx)
+	}
+}
+
+// check 检查所有会话的超时
+func (sm *SessionManager) check(now time.Time) {
+	for _, s := range sm.Sessions() {
+		s.mu.RLock()
+		authed, connectedAt, lastSeen, lastActive := s.authed, s.connectedAt, s.lastSeen, s.lastActive
+		s.mu.RUnlock()
+
+		switch {
+		case sm.authTimeout > 0 && !authed && now.Sub(connectedAt) > sm.authTimeout:
+			s.Kick(ReasonAuthTimeout)
+		case sm.heartbeatTimeout > 0 && now.Sub(lastSeen) > sm.heartbeatTimeout:
+			s.Kick(ReasonHeartbeatTimeout)
+		case sm.idleTimeout > 0 && authed && now.Sub(lastActive) > sm.idleTimeout:
+			s.Kick(ReasonIdleTimeout)
+		}
+	}
+}
+
+// Run 定时检查会话超时, ctx结束后退出
+func (sm *SessionManager) Run(ctx context.Context) {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			sm.check(now)
+		}
+	}
+}
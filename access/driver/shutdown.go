@@ -0,0 +1,222 @@
+package driver
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Shutdowner 支持优雅关闭的驱动
+// Shutdown 停止接收新连接, 等待处理中的 Translate 结束, 通知协议发送告别报文, 清空下行队列后关闭连接;
+// ctx 到期后立即关闭并返回 ctx.Err()
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Goodbyer 协议实现后, 驱动关闭时对每个已登录的设备调用, 返回的报文会在断开前发送(如通知设备稍后重连)
+type Goodbyer interface {
+	Goodbye(mark string) []Msg
+}
+
+// Shutdown 优雅关闭驱动, 驱动没有实现 Shutdowner 时调用 Stop, ctx 到期不再等待 Stop 返回
+func Shutdown(ctx context.Context, d Driver) error {
+	if s, ok := d.(Shutdowner); ok {
+		return s.Shutdown(ctx)
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Stop()
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Drainer 驱动实现 Shutdown 的辅助
+//
+//	dr := driver.NewDrainer(d).SetSessions(sm).SetProtocol(proto)
+//	dr.AddListener(ln)
+//	dr.OnFlush(writeQueues.Flush)
+//	pipeline.Intercept(dr.Interceptor())
+//	func (d *TCPDriver) Shutdown(ctx context.Context) error { return d.drainer.Shutdown(ctx) }
+type Drainer struct {
+	driver   Driver
+	sessions *SessionManager
+	protocol Protocol
+	spread   time.Duration
+
+	mu        sync.Mutex
+	listeners []net.Listener
+	flushers  []func(ctx context.Context) error
+
+	closing  int32
+	inflight int64
+	idle     chan struct{} // 处理中的Translate数变为0时通知
+	done     chan struct{} // 第一次Shutdown结束时关闭
+	err      error         // 第一次Shutdown的结果, done关闭后可读
+}
+
+// NewDrainer 创建关闭辅助, d 用于发送告别报文
+func NewDrainer(d Driver) *Drainer {
+	return &Drainer{driver: d, idle: make(chan struct{}, 1), done: make(chan struct{})}
+}
+
+// SetSessions 关闭时向已登录的会话发送告别报文, 最后以 ReasonShutdown 断开所有会话
+func (dr *Drainer) SetSessions(sm *SessionManager) *Drainer {
+	dr.sessions = sm
+	return dr
+}
+
+// SetProtocol 协议实现了 Goodbyer 时发送告别报文
+func (dr *Drainer) SetProtocol(p Protocol) *Drainer {
+	dr.protocol = p
+	return dr
+}
+
+// SetKickSpread 最后断开会话时在d内均匀分批断开, 避免设备同时重连, 默认立即全部断开
+// 断开的总时长计入 Shutdown 的ctx, ctx 到期后剩余会话立即断开
+func (dr *Drainer) SetKickSpread(d time.Duration) *Drainer {
+	dr.spread = d
+	return dr
+}
+
+// AddListener 关闭时最先关闭监听, 不再接收新连接
+func (dr *Drainer) AddListener(l net.Listener) {
+	dr.mu.Lock()
+	dr.listeners = append(dr.listeners, l)
+	dr.mu.Unlock()
+}
+
+// OnFlush 关闭时清空下行队列(如写队列/outbox)
+func (dr *Drainer) OnFlush(f func(ctx context.Context) error) {
+	dr.mu.Lock()
+	dr.flushers = append(dr.flushers, f)
+	dr.mu.Unlock()
+}
+
+// Closing 是否正在关闭, 驱动可以据此拒绝新连接
+func (dr *Drainer) Closing() bool {
+	return atomic.LoadInt32(&dr.closing) == 1
+}
+
+// Begin 开始处理一个报文, 返回的函数在处理结束时调用
+// 使用 Interceptor 时不需要直接调用
+func (dr *Drainer) Begin() (end func()) {
+	atomic.AddInt64(&dr.inflight, 1)
+	return func() {
+		if atomic.AddInt64(&dr.inflight, -1) == 0 {
+			select {
+			case dr.idle <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// Inflight 处理中的 Translate 数
+func (dr *Drainer) Inflight() int64 {
+	return atomic.LoadInt64(&dr.inflight)
+}
+
+// Interceptor 翻译拦截器, 统计处理中的 Translate; 开始关闭后收到的报文返回 ErrIgnore, 不再处理
+func (dr *Drainer) Interceptor() TranslateInterceptor {
+	return func(ctx context.Context, acctx *Ctx, handler TranslateHandler) (tos []Msg, rets []Msg, err error) {
+		// 先计数再检查, Shutdown 设置closing之前开始的报文一定会被等待
+		defer dr.Begin()()
+		if dr.Closing() {
+			return nil, nil, ErrIgnore
+		}
+		return handler(ctx)
+	}
+}
+
+// Shutdown 优雅关闭
+//  1. 关闭监听, 不再接收新连接, 之后收到的报文不再处理
+//  2. 等待处理中的 Translate 结束, 它们产生的下行报文也会在第4步清空
+//  3. 向已登录的设备发送协议的告别报文
+//  4. 清空下行队列
+//  5. 以 ReasonShutdown 断开所有会话, 设置了 SetKickSpread 时分批断开
+//
+// ctx 到期时跳过剩余的等待, 直接断开所有会话并返回 ctx.Err()
+// 重复调用时等待第一次调用结束, 返回第一次的结果; ctx 先到期时返回 ctx.Err()
+func (dr *Drainer) Shutdown(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&dr.closing, 0, 1) {
+		select {
+		case <-dr.done:
+			return dr.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	dr.err = dr.shutdown(ctx)
+	dr.kickAll(ctx)
+	close(dr.done)
+	return dr.err
+}
+
+func (dr *Drainer) shutdown(ctx context.Context) (err error) {
+	dr.mu.Lock()
+	listeners, flushers := dr.listeners, dr.flushers
+	dr.mu.Unlock()
+	for _, l := range listeners {
+		l.Close()
+	}
+
+	for dr.Inflight() > 0 {
+		select {
+		case <-dr.idle:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if g, ok := As[Goodbyer](dr.protocol); ok && dr.sessions != nil {
+		for _, s := range dr.sessions.Sessions() {
+			if !s.Authed() {
+				continue
+			}
+			for _, msg := range g.Goodbye(s.Mark()) {
+				dr.driver.Send(msg)
+			}
+			if err = ctx.Err(); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, f := range flushers {
+		if err = f(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (dr *Drainer) kickAll(ctx context.Context) {
+	if dr.sessions == nil {
+		return
+	}
+	ss := dr.sessions.Sessions()
+	var interval time.Duration
+	if dr.spread > 0 && len(ss) > 1 {
+		interval = dr.spread / time.Duration(len(ss))
+	}
+	for i, s := range ss {
+		s.Kick(ReasonShutdown)
+		if interval <= 0 || i == len(ss)-1 || ctx.Err() != nil {
+			continue
+		}
+		t := time.NewTimer(interval)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+		}
+	}
+}
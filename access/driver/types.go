@@ -1,7 +1,6 @@
 package driver
 
 import (
-	"encoding/binary"
 	"errors"
 	"fmt"
 	"strconv"
@@ -26,6 +25,9 @@ var (
 
 	// ErrAlreadySession 重复的连接
 	ErrAlreadySession = errors.New("already session")
+
+	// ErrSessionClosed 连接已关闭
+	ErrSessionClosed = errors.New("session closed")
 )
 
 /////////////////////////////////////////////////////////////////////////////////////
@@ -192,6 +194,20 @@ func (b Bytetime) Unix(def ...time.Time) int64 {
 	return t.Unix()
 }
 
+// TimeIn 按BCD格式(yyyyMMddHHmmss)转换为loc时区的时间, 不是合法的BCD时间时返回错误
+func (b Bytetime) TimeIn(loc *time.Location) (time.Time, error) {
+	for _, v := range b[:7] {
+		if v>>4 > 9 || v&0x0f > 9 {
+			return time.Time{}, fmt.Errorf("invalid bcd bytetime [%x]", b[:7])
+		}
+	}
+	t, err := time.ParseInLocation("20060102150405", fmt.Sprintf("%x", b[:7]), loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid bytetime [%x]: %w", b[:7], err)
+	}
+	return t, nil
+}
+
 type BCDByte1 uint8
 
 func (b BCDByte1) String() string {
@@ -235,26 +251,3 @@ func (b BCDByte16) String() string {
 	// return string(byteconv.BCDToByte(b[:]))
 	return fmt.Sprintf("%x", b[:])
 }
-
-type CP56Time [7]byte
-
-func (b CP56Time) String() string {
-	mill := binary.LittleEndian.Uint16(b[0:2])
-	return fmt.Sprintf("20%02d-%02d-%02d %02d:%02d:%02d", b[6]&0x7f, b[5]&0x0f, b[4]&0x1f, b[3]&0x1f, b[2]&0x3f, int32(mill/1000))
-}
-
-func (b CP56Time) Time() time.Time {
-	t, _ := time.ParseInLocation("2006-01-02 15:04:05", b.String(), time.Local)
-	return t
-}
-
-// NewCP56Time 根据传入时间，创建一个cp56时间
-func NewCP56Time(t time.Time) (ret CP56Time) {
-	ret[6] = uint8(t.Year() % 100)
-	ret[5] = uint8(t.Month())
-	ret[4] = uint8(t.Day())
-	ret[3] = uint8(t.Hour())
-	ret[2] = uint8(t.Minute())
-	binary.LittleEndian.PutUint16(ret[:2], uint16(t.Second()*1000))
-	return
-}
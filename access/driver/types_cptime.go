@@ -0,0 +1,230 @@
+package driver
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////
+// IEC 60870-5-101/104 时标
+/////////////////////////////////////////////////////////////////////////////////////
+
+// ErrCPTime 时标字段超出范围
+var ErrCPTime = errors.New("invalid cp time")
+
+const (
+	cpInvalid = 0x80 // 分钟字节 bit7: IV 时标无效
+	cpSummer  = 0x80 // 小时字节 bit7: SU 夏令时
+)
+
+// CP56Time CP56Time2a 七字节时标
+//
+//	[0:2] 毫秒(含秒) 0~59999, 小端
+//	[2]   bit0~5 分, bit7 IV
+//	[3]   bit0~4 时, bit7 SU
+//	[4]   bit0~4 日, bit5~7 星期(1~7, 0为不使用)
+//	[5]   bit0~3 月
+//	[6]   bit0~6 年(0~99, 2000年起)
+type CP56Time [7]byte
+
+// NewCP56Time 根据传入时间创建cp56时标, 精确到毫秒
+// 星期字段为0(不使用), 和之前的编码保持一致; 需要星期的协议使用 SetWeekday
+//
+//	NewCP56Time(t).SetWeekday(t.Weekday(), true)
+func NewCP56Time(t time.Time) (ret CP56Time) {
+	binary.LittleEndian.PutUint16(ret[:2], uint16(t.Second()*1000+t.Nanosecond()/int(time.Millisecond)))
+	ret[2] = uint8(t.Minute())
+	ret[3] = uint8(t.Hour())
+	ret[4] = uint8(t.Day())
+	ret[5] = uint8(t.Month())
+	ret[6] = uint8(t.Year() % 100)
+	return
+}
+
+func (b CP56Time) String() string {
+	return fmt.Sprintf("20%02d-%02d-%02d %02d:%02d:%02d", b.year(), b[5]&0x0f, b[4]&0x1f, b[3]&0x1f, b[2]&0x3f, b.Millisecond()/1000)
+}
+
+// Time 转换为本地时间, 时标字段无效时返回零值
+// 需要区分错误时使用 TimeIn
+func (b CP56Time) Time() time.Time {
+	t, _ := b.TimeIn(time.Local)
+	return t
+}
+
+// TimeIn 转换为loc时区的时间, 精确到毫秒
+// 字段超出范围或日期不存在(如2月30日)时返回 ErrCPTime
+// 不检查IV标记, 由调用方通过 Invalid 判断
+func (b CP56Time) TimeIn(loc *time.Location) (time.Time, error) {
+	ms, min, hour := int(b.Millisecond()), int(b[2]&0x3f), int(b[3]&0x1f)
+	day, month, year := int(b[4]&0x1f), int(b[5]&0x0f), 2000+int(b.year())
+	switch {
+	case ms > 59999:
+		return time.Time{}, fmt.Errorf("%w: millisecond %d", ErrCPTime, ms)
+	case min > 59:
+		return time.Time{}, fmt.Errorf("%w: minute %d", ErrCPTime, min)
+	case hour > 23:
+		return time.Time{}, fmt.Errorf("%w: hour %d", ErrCPTime, hour)
+	case month < 1 || month > 12:
+		return time.Time{}, fmt.Errorf("%w: month %d", ErrCPTime, month)
+	case day < 1 || day > daysIn(time.Month(month), year):
+		return time.Time{}, fmt.Errorf("%w: day %d of %04d-%02d", ErrCPTime, day, year, month)
+	}
+	return time.Date(year, time.Month(month), day, hour, min, ms/1000, ms%1000*int(time.Millisecond), loc), nil
+}
+
+// Millisecond 毫秒字段(含秒)
+func (b CP56Time) Millisecond() uint16 {
+	return binary.LittleEndian.Uint16(b[0:2])
+}
+
+// Invalid IV标记, 设备时钟无效
+func (b CP56Time) Invalid() bool {
+	return b[2]&cpInvalid != 0
+}
+
+// Summer SU标记, 夏令时
+func (b CP56Time) Summer() bool {
+	return b[3]&cpSummer != 0
+}
+
+// Weekday 星期字段, 设备不使用星期(值为0)时ok为false
+func (b CP56Time) Weekday() (w time.Weekday, ok bool) {
+	d := b[4] >> 5
+	if d == 0 {
+		return 0, false
+	}
+	return time.Weekday(d % 7), true
+}
+
+// SetWeekday 设置星期字段, ok为false时清除(值为0, 不使用)
+func (b CP56Time) SetWeekday(w time.Weekday, ok bool) CP56Time {
+	b[4] &= 0x1f
+	if ok {
+		b[4] |= isoWeekday(w) << 5
+	}
+	return b
+}
+
+// SetInvalid 设置IV标记
+func (b CP56Time) SetInvalid(iv bool) CP56Time {
+	b[2] = setBit(b[2], cpInvalid, iv)
+	return b
+}
+
+// SetSummer 设置SU标记
+func (b CP56Time) SetSummer(su bool) CP56Time {
+	b[3] = setBit(b[3], cpSummer, su)
+	return b
+}
+
+func (b CP56Time) year() uint8 {
+	return b[6] & 0x7f
+}
+
+// CP24Time CP24Time2a 三字节时标: 毫秒(含秒)和分, 不含小时及以上
+//
+//	[0:2] 毫秒(含秒) 0~59999, 小端
+//	[2]   bit0~5 分, bit7 IV
+type CP24Time [3]byte
+
+// NewCP24Time 根据传入时间创建cp24时标
+func NewCP24Time(t time.Time) (ret CP24Time) {
+	binary.LittleEndian.PutUint16(ret[:2], uint16(t.Second()*1000+t.Nanosecond()/int(time.Millisecond)))
+	ret[2] = uint8(t.Minute())
+	return
+}
+
+func (b CP24Time) String() string {
+	return fmt.Sprintf("%02d:%02d.%03d", b[2]&0x3f, b.Millisecond()/1000, b.Millisecond()%1000)
+}
+
+// Millisecond 毫秒字段(含秒)
+func (b CP24Time) Millisecond() uint16 {
+	return binary.LittleEndian.Uint16(b[0:2])
+}
+
+// Invalid IV标记, 设备时钟无效
+func (b CP24Time) Invalid() bool {
+	return b[2]&cpInvalid != 0
+}
+
+// SetInvalid 设置IV标记
+func (b CP24Time) SetInvalid(iv bool) CP24Time {
+	b[2] = setBit(b[2], cpInvalid, iv)
+	return b
+}
+
+// TimeNear 用参考时间(一般为接收时间)补全小时及以上字段
+// 返回离ref最近的时间, 与ref相差不超过半小时, 用于处理跨小时
+func (b CP24Time) TimeNear(ref time.Time) (time.Time, error) {
+	ms, min := int(b.Millisecond()), int(b[2]&0x3f)
+	switch {
+	case ms > 59999:
+		return time.Time{}, fmt.Errorf("%w: millisecond %d", ErrCPTime, ms)
+	case min > 59:
+		return time.Time{}, fmt.Errorf("%w: minute %d", ErrCPTime, min)
+	}
+	// 不用 Truncate, 它按绝对时间截断, 非整小时时区会出错
+	hour := time.Date(ref.Year(), ref.Month(), ref.Day(), ref.Hour(), 0, 0, 0, ref.Location())
+	t := hour.Add(time.Duration(min)*time.Minute + time.Duration(ms)*time.Millisecond)
+	switch d := t.Sub(ref); {
+	case d > 30*time.Minute:
+		t = t.Add(-time.Hour)
+	case d < -30*time.Minute:
+		t = t.Add(time.Hour)
+	}
+	return t, nil
+}
+
+// CP16Time CP16Time2a 两字节时标: 毫秒(含秒) 0~59999, 小端
+// 一般用于表示经过的时间, 如继电保护动作时间
+type CP16Time [2]byte
+
+// NewCP16Time 创建cp16时标, d不能超过59.999秒
+func NewCP16Time(d time.Duration) (ret CP16Time, err error) {
+	if d < 0 || d >= time.Minute {
+		return ret, fmt.Errorf("%w: duration %s", ErrCPTime, d)
+	}
+	binary.LittleEndian.PutUint16(ret[:], uint16(d/time.Millisecond))
+	return ret, nil
+}
+
+func (b CP16Time) String() string {
+	return fmt.Sprintf("%d.%03ds", b.Millisecond()/1000, b.Millisecond()%1000)
+}
+
+// Millisecond 毫秒字段(含秒)
+func (b CP16Time) Millisecond() uint16 {
+	return binary.LittleEndian.Uint16(b[:])
+}
+
+// Duration 转换为时长, 超出范围时返回 ErrCPTime
+func (b CP16Time) Duration() (time.Duration, error) {
+	ms := b.Millisecond()
+	if ms > 59999 {
+		return 0, fmt.Errorf("%w: millisecond %d", ErrCPTime, ms)
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// isoWeekday 星期一为1, 星期日为7
+func isoWeekday(w time.Weekday) uint8 {
+	if w == time.Sunday {
+		return 7
+	}
+	return uint8(w)
+}
+
+func daysIn(m time.Month, year int) int {
+	return time.Date(year, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func setBit(b, mask byte, on bool) byte {
+	if on {
+		return b | mask
+	}
+	return b &^ mask
+}
@@ -2,8 +2,13 @@ package driver
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
 
 	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/lg"
 )
 
 /////////////////////////////////////////////////////////////////////////////////////
@@ -11,31 +16,109 @@ import (
 /////////////////////////////////////////////////////////////////////////////////////
 
 // Ctx ac上下文
+// Data 保留给旧代码直接读写, 新代码使用 Key 或 Get/Set, 可以并发访问
 type Ctx struct {
 	Raw        interface{}            // 报文字节
 	Mark       string                 // 唯一标记，evseid/sn
 	Data       map[string]interface{} // data
 	Log        *logrus.Entry          // 日志对象
-	AfterFuncs []func()               // 回调
+	AfterFuncs []func()               // 回调, 新代码使用 After
 	DriverName string                 // 驱动名称
+
+	mu     sync.RWMutex
+	afters []func() error
+	logger *Logger
 }
 
-// Clone 复制一个上下文信息
-// Clone 不能在goroution里面clone，容易并发读写map，导致panic
+// Clone 复制一个上下文信息, 可以在协程中调用
+// 回调函数不会复制
 func (ctx *Ctx) Clone() (ret *Ctx) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
 	ret = &Ctx{
 		Raw:        ctx.Raw,
 		Mark:       ctx.Mark,
-		Log:        ctx.Log.Dup(),
-		Data:       make(map[string]interface{}),
+		Log:        ctx.Log,
+		Data:       make(map[string]interface{}, len(ctx.Data)),
+		logger:     ctx.logger,
+		AfterFuncs: make([]func(), 0),
 		DriverName: ctx.DriverName,
 	}
+	if ctx.Log != nil {
+		ret.Log = ctx.Log.Dup()
+	}
 	for k, v := range ctx.Data {
 		ret.Data[k] = v
 	}
 	return
 }
 
+// Get 获取数据
+func (ctx *Ctx) Get(name string) (v interface{}, ok bool) {
+	ctx.mu.RLock()
+	defer ctx.mu.RUnlock()
+	v, ok = ctx.Data[name]
+	return
+}
+
+// Set 设置数据
+func (ctx *Ctx) Set(name string, v interface{}) {
+	ctx.mu.Lock()
+	if ctx.Data == nil {
+		ctx.Data = make(map[string]interface{})
+	}
+	ctx.Data[name] = v
+	ctx.mu.Unlock()
+}
+
+// Delete 删除数据
+func (ctx *Ctx) Delete(name string) {
+	ctx.mu.Lock()
+	delete(ctx.Data, name)
+	ctx.mu.Unlock()
+}
+
+// After 添加回调, 驱动在报文发送后按添加顺序执行
+func (ctx *Ctx) After(f func() error) {
+	ctx.mu.Lock()
+	ctx.afters = append(ctx.afters, f)
+	ctx.mu.Unlock()
+}
+
+// RunAfterFuncs 按添加顺序执行 After 回调, 然后执行 AfterFuncs
+// 每个回调都会执行, 回调panic转换为错误, 返回所有错误的合并
+func (ctx *Ctx) RunAfterFuncs() error {
+	ctx.mu.Lock()
+	afters, legacy := ctx.afters, ctx.AfterFuncs
+	ctx.afters, ctx.AfterFuncs = nil, nil
+	ctx.mu.Unlock()
+
+	var errs []error
+	for _, f := range afters {
+		if err := safeCall(f); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, f := range legacy {
+		f := f
+		if err := safeCall(func() error { f(); return nil }); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func safeCall(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 4096)
+			buf = buf[:runtime.Stack(buf, false)]
+			err = fmt.Errorf("after func panic: %v\n%s", r, buf)
+		}
+	}()
+	return f()
+}
+
 // NewACCtx ...
 func NewACCtx(mark, drivername string, raw interface{}) *Ctx {
 	return &Ctx{
@@ -45,18 +128,51 @@ func NewACCtx(mark, drivername string, raw interface{}) *Ctx {
 		Log:        logrus.WithField("mark", mark),
 		Data:       make(map[string]interface{}),
 		AfterFuncs: make([]func(), 0),
+		logger:     NewLogger(context.Background()).WithField("mark", mark),
+	}
+}
+
+// Logger 基于 lg.LogContext 的日志对象, 带设备标记, 输出格式和 lg 一致
+// 直接构造的 Ctx 第一次调用时按 Mark 创建
+func (ctx *Ctx) Logger() *Logger {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	if ctx.logger == nil {
+		ctx.logger = NewLogger(context.Background()).WithField("mark", ctx.Mark)
+	}
+	return ctx.logger
+}
+
+// SetLogger 替换 lg 日志对象(如添加字段后), 之后 NewContext 使用新的日志字段
+func (ctx *Ctx) SetLogger(l *Logger) {
+	ctx.mu.Lock()
+	ctx.logger = l
+	ctx.mu.Unlock()
+}
+
+type ctxKey struct{}
+
+// NewContext 把上下文信息放入context
+// context中没有 lg.LogContext 时同时放入 Ctx.Logger 的日志字段, lg.Infoc(ctx, ...) 会带上设备标记
+func NewContext(ctx context.Context, acctx *Ctx) context.Context {
+	if lg.FromContext(ctx) == nil {
+		ctx = lg.Derive(acctx.Logger().Context(), ctx)
 	}
+	return context.WithValue(ctx, ctxKey{}, acctx)
+}
+
+// FromContext 获取context中的上下文信息, 没有返回nil
+func FromContext(ctx context.Context) *Ctx {
+	acctx, _ := ctx.Value(ctxKey{}).(*Ctx)
+	return acctx
 }
 
-// NewACContext accontext
+// NewACContext accontext, 同 NewContext
 func NewACContext(ctx context.Context, acctx *Ctx) context.Context {
-	return context.WithValue(ctx, "acctx", acctx)
+	return NewContext(ctx, acctx)
 }
 
-// GetACCtxWithContext ...
+// GetACCtxWithContext 同 FromContext
 func GetACCtxWithContext(ctx context.Context) *Ctx {
-	if _acctx := ctx.Value("acctx"); _acctx != nil {
-		return _acctx.(*Ctx)
-	}
-	return nil
+	return FromContext(ctx)
 }
@@ -0,0 +1,53 @@
+package driver
+
+// Key 类型化的上下文数据key, 数据保存在 Ctx.Data 中, 旧代码按名称读写的数据可以互通
+//
+//	var KeyGun = driver.NewKey[int]("gun")
+//	KeyGun.Set(acctx, 1)
+//	gun, ok := KeyGun.Get(acctx)
+type Key[T any] struct {
+	name string
+}
+
+// NewKey 创建key
+func NewKey[T any](name string) Key[T] {
+	return Key[T]{name: name}
+}
+
+// Name key名称
+func (k Key[T]) Name() string {
+	return k.name
+}
+
+// Get 获取数据, 不存在或类型不对时ok为false
+func (k Key[T]) Get(ctx *Ctx) (v T, ok bool) {
+	raw, ok := ctx.Get(k.name)
+	if !ok {
+		return v, false
+	}
+	v, ok = raw.(T)
+	return
+}
+
+// Value 获取数据, 不存在时返回零值
+func (k Key[T]) Value(ctx *Ctx) T {
+	v, _ := k.Get(ctx)
+	return v
+}
+
+// Set 设置数据
+func (k Key[T]) Set(ctx *Ctx, v T) {
+	ctx.Set(k.name, v)
+}
+
+// Delete 删除数据
+func (k Key[T]) Delete(ctx *Ctx) {
+	ctx.Delete(k.name)
+}
+
+// 内置的上下文数据
+var (
+	KeyCmd     = NewKey[string](CtxDataCmd)
+	KeyRemote  = NewKey[string](CtxDataRemote)
+	KeySession = NewKey[*Session](CtxDataSession)
+)
@@ -0,0 +1,54 @@
+package driver
+
+import (
+	"time"
+)
+
+/////////////////////////////////////////////////////////////////////////////////////
+// 原始报文
+/////////////////////////////////////////////////////////////////////////////////////
+
+// Direction 报文方向
+type Direction uint8
+
+const (
+	// DirIn 设备上行
+	DirIn Direction = iota + 1
+	// DirOut 平台下行
+	DirOut
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirIn:
+		return "in"
+	case DirOut:
+		return "out"
+	}
+	return "unknown"
+}
+
+// Frame 一帧原始报文
+type Frame struct {
+	Time   time.Time
+	Mark   string
+	Driver string
+	Remote string // 对端地址, 驱动未提供时为空
+	Cmd    string
+	Dir    Direction
+	Data   []byte
+}
+
+// FrameSink 原始报文接收端(抓包/实时查看)
+// WriteFrame 在报文处理路径上同步调用, 实现不能阻塞
+type FrameSink interface {
+	WriteFrame(f *Frame)
+}
+
+// RawBytes 获取上下文中的原始报文字节, Raw 不是 []byte 时返回nil
+func (ctx *Ctx) RawBytes() []byte {
+	if b, ok := ctx.Raw.([]byte); ok {
+		return b
+	}
+	return nil
+}
@@ -1,11 +1,12 @@
 package driver
 
+import "time"
+
 /////////////////////////////////////////////////////////////////////////////////////
 // 转发消息类型
 /////////////////////////////////////////////////////////////////////////////////////
 
 // DefMsg 转发基本消息
-// todo: 可以加个延时属性
 type DefMsg struct {
 	Mark   string
 	Raw    interface{} // 要发送的消息内容(是消息数据源处理后的内容)
@@ -77,3 +78,27 @@ func (mm *DefaultMqttMsg) GetRetained() bool {
 func (mm *DefaultMqttMsg) GetTopic() string {
 	return mm.Topic
 }
+
+// DelayMsg 延时发送的消息
+type DelayMsg interface {
+	Msg
+
+	// GetSendAt 发送时间
+	GetSendAt() time.Time
+}
+
+// DefDelayMsg 延时消息
+type DefDelayMsg struct {
+	Msg
+	SendAt time.Time
+}
+
+// NewDelayMsg 创建一个在at时刻发送的消息
+func NewDelayMsg(msg Msg, at time.Time) Msg {
+	return &DefDelayMsg{Msg: msg, SendAt: at}
+}
+
+// GetSendAt 发送时间
+func (m *DefDelayMsg) GetSendAt() time.Time {
+	return m.SendAt
+}
@@ -0,0 +1,38 @@
+package events
+
+import (
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/traffic"
+)
+
+// Type 事件类型
+type Type string
+
+const (
+	TypeConnect    Type = "connect"    // 设备登录成功
+	TypeDisconnect Type = "disconnect" // 设备断开
+	TypeBlock      Type = "block"      // 报文被屏蔽名单拦截
+	TypeKick       Type = "kick"       // 协议/拦截器要求断开连接
+)
+
+// Event 设备生命周期事件
+type Event struct {
+	ID          string         `json:"id"`
+	Type        Type           `json:"type"`
+	Mark        string         `json:"mark"`
+	Driver      string         `json:"driver"`
+	Node        string         `json:"node"`
+	Session     string         `json:"session,omitempty"`
+	Remote      string         `json:"remote,omitempty"`
+	Reason      string         `json:"reason,omitempty"`
+	Time        time.Time      `json:"time"`
+	ConnectedAt time.Time      `json:"connected_at,omitempty"`
+	Duration    float64        `json:"duration,omitempty"` // 连接时长(秒), 断开事件有值
+	Traffic     *traffic.Stats `json:"traffic,omitempty"`  // 连接期间的流量, 断开事件有值
+}
+
+// Sink 事件接收端
+type Sink interface {
+	Publish(e *Event) error
+}
@@ -0,0 +1,220 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+	"github.com/zhuoqingbin/utils/access/traffic"
+	"github.com/zhuoqingbin/utils/uuid"
+)
+
+var eventCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_events_total",
+	Help: "lifecycle events by type/result(published/error/dropped/deduped)",
+}, []string{"type", "result"})
+
+// Publisher 异步发布设备生命周期事件, 发布失败只记录日志, 不影响驱动
+//
+//	p := events.NewPublisher("gw-1", events.NewRedisSink("", 100000))
+//	defer p.Close()
+//	p.WatchSessions(sm, acct)              // 登录/断开
+//	pipeline.Intercept(p.Interceptor())    // 屏蔽/踢下线
+type Publisher struct {
+	node    string
+	sink    Sink
+	queue   chan *Event
+	closing chan struct{} // Close时关闭, queue不关闭, 避免会话回调向已关闭的chan发送
+	done    chan struct{}
+	once    sync.Once
+	log     *logrus.Entry
+
+	window time.Duration
+	mu     sync.Mutex
+	recent map[string]time.Time // 类型/设备/原因 -> 最近一次发布时间
+	pruned time.Time
+}
+
+// NewPublisher 创建发布器, node为本节点标识
+func NewPublisher(node string, sink Sink) *Publisher {
+	p := &Publisher{
+		node:    node,
+		sink:    sink,
+		queue:   make(chan *Event, 4096),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		log:     logrus.WithField("module", "events").WithField("node", node),
+		window:  time.Minute,
+		recent:  make(map[string]time.Time),
+	}
+	go p.loop()
+	return p
+}
+
+// SetDedupWindow 同一设备同一原因的屏蔽/踢下线事件在窗口内只发布一次, 默认1分钟, <=0不去重
+// 被屏蔽的设备会不断重连, 每帧都发布会淹没事件流
+func (p *Publisher) SetDedupWindow(d time.Duration) *Publisher {
+	p.mu.Lock()
+	p.window = d
+	p.mu.Unlock()
+	return p
+}
+
+// dedup 窗口内已经发布过时返回true, 否则记录本次发布时间
+func (p *Publisher) dedup(e *Event) bool {
+	who := e.Mark
+	if who == "" {
+		who = e.Remote
+	}
+	key := string(e.Type) + "|" + who + "|" + e.Reason
+	now := time.Now()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.window <= 0 {
+		return false
+	}
+	if now.Sub(p.pruned) > p.window {
+		for k, t := range p.recent {
+			if now.Sub(t) >= p.window {
+				delete(p.recent, k)
+			}
+		}
+		p.pruned = now
+	}
+	if t, ok := p.recent[key]; ok && now.Sub(t) < p.window {
+		return true
+	}
+	p.recent[key] = now
+	return false
+}
+
+// Publish 发布事件, 自动填充ID/节点/时间; 队列满或Close之后丢弃
+func (p *Publisher) Publish(e *Event) {
+	select {
+	case <-p.closing:
+		eventCounter.WithLabelValues(string(e.Type), "dropped").Inc()
+		return
+	default:
+	}
+	if e.ID == "" {
+		e.ID = uuid.GetID().String()
+	}
+	if e.Node == "" {
+		e.Node = p.node
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	select {
+	case p.queue <- e:
+	default:
+		eventCounter.WithLabelValues(string(e.Type), "dropped").Inc()
+	}
+}
+
+func (p *Publisher) loop() {
+	defer close(p.done)
+	for {
+		select {
+		case e := <-p.queue:
+			p.publish(e)
+		case <-p.closing:
+			for {
+				select {
+				case e := <-p.queue:
+					p.publish(e)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *Publisher) publish(e *Event) {
+	if err := p.sink.Publish(e); err != nil {
+		eventCounter.WithLabelValues(string(e.Type), "error").Inc()
+		p.log.WithField("mark", e.Mark).Errorf("publish %s: %v", e.Type, err)
+		return
+	}
+	eventCounter.WithLabelValues(string(e.Type), "published").Inc()
+}
+
+// Close 发布完队列中的事件后退出, 可以重复调用
+// 会话回调无法注销, Close之后的事件(如关闭时踢下线)直接丢弃
+func (p *Publisher) Close() {
+	p.once.Do(func() { close(p.closing) })
+	<-p.done
+}
+
+// WatchSessions 订阅会话管理的登录/断开事件
+// acct不为nil时, 断开事件带上设备的累计流量
+func (p *Publisher) WatchSessions(sm *driver.SessionManager, acct *traffic.Accounting) {
+	sm.OnEvent(func(ev driver.SessionEvent) {
+		e := &Event{
+			Mark:        ev.Session.Mark,
+			Driver:      ev.Session.Driver,
+			Session:     ev.Session.ID,
+			Remote:      ev.Session.Remote,
+			Reason:      string(ev.Reason),
+			Time:        ev.Time,
+			ConnectedAt: ev.Session.ConnectedAt,
+		}
+		switch ev.Type {
+		case driver.EventConnect:
+			e.Type = TypeConnect
+		case driver.EventDisconnect:
+			e.Type = TypeDisconnect
+			e.Duration = ev.Time.Sub(ev.Session.ConnectedAt).Seconds()
+			if acct != nil && e.Mark != "" {
+				if snap, ok := acct.Get(e.Mark); ok {
+					e.Traffic = &snap.Total
+				}
+			}
+		default:
+			return
+		}
+		p.Publish(e)
+	})
+}
+
+// Interceptor 翻译拦截器, 报文被屏蔽(driver.ErrBlock)或要求断开(driver.ErrKick)时发布事件
+// 放在屏蔽名单/限流等拦截器之前, 窗口内重复的事件不发布, 见 SetDedupWindow
+func (p *Publisher) Interceptor() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		tos, rets, err = handler(ctx)
+		var typ Type
+		switch {
+		case err == nil:
+			return
+		case errors.Is(err, driver.ErrBlock):
+			typ = TypeBlock
+		case errors.Is(err, driver.ErrKick):
+			typ = TypeKick
+		default:
+			return
+		}
+		e := &Event{
+			Type:   typ,
+			Mark:   acctx.Mark,
+			Driver: acctx.DriverName,
+			Remote: acctx.GetRemote(),
+			Reason: err.Error(),
+		}
+		if s := acctx.GetSession(); s != nil {
+			e.Session = s.ID()
+		}
+		if p.dedup(e) {
+			eventCounter.WithLabelValues(string(typ), "deduped").Inc()
+			return
+		}
+		p.Publish(e)
+		return
+	}
+}
@@ -0,0 +1,35 @@
+package events
+
+import "sync/atomic"
+
+// ChanSink 把事件写入channel, 用于测试和进程内订阅
+// channel满时丢弃事件, 不阻塞驱动
+type ChanSink struct {
+	ch      chan *Event
+	dropped uint64
+}
+
+// NewChanSink 创建channel接收端
+func NewChanSink(size int) *ChanSink {
+	return &ChanSink{ch: make(chan *Event, size)}
+}
+
+// C 事件channel
+func (s *ChanSink) C() <-chan *Event {
+	return s.ch
+}
+
+// Dropped channel满时丢弃的事件数
+func (s *ChanSink) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Publish 写入事件
+func (s *ChanSink) Publish(e *Event) error {
+	select {
+	case s.ch <- e:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+	return nil
+}
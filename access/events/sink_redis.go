@@ -0,0 +1,41 @@
+package events
+
+import (
+	"encoding/json"
+
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// RedisSink 写入redis stream, 每条消息一个 data 字段, 值为事件json
+// 业务服务使用 redigo.StreamHandle 以 *events.Event 消费
+//
+//	h, _ := redigo.NewStreamHandle("access:events", "billing", hostname, 100, cb)
+//	go h.Daemon(ctx, &events.Event{})
+type RedisSink struct {
+	stream string
+	maxLen int
+}
+
+// NewRedisSink 创建redis stream接收端, stream为空时使用 access:events
+// maxLen>0 时近似裁剪stream长度
+func NewRedisSink(stream string, maxLen int) *RedisSink {
+	if stream == "" {
+		stream = "access:events"
+	}
+	return &RedisSink{stream: stream, maxLen: maxLen}
+}
+
+// Publish 写入事件
+func (s *RedisSink) Publish(e *Event) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	args := []interface{}{s.stream}
+	if s.maxLen > 0 {
+		args = append(args, "MAXLEN", "~", s.maxLen)
+	}
+	args = append(args, "*", "data", b)
+	_, err = redigo.Do("XADD", args...)
+	return err
+}
@@ -0,0 +1,110 @@
+package handoff
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// EnvListenFDs 子进程继承的监听fd, 格式 addr=fd,addr=fd
+const EnvListenFDs = "ACCESS_LISTEN_FDS"
+
+// ErrNotFileListener 监听不支持导出fd
+var ErrNotFileListener = errors.New("handoff: listener has no file descriptor")
+
+var (
+	inheritOnce sync.Once
+	inherited   map[string]*os.File
+	inheritMu   sync.Mutex
+)
+
+func loadInherited() {
+	inherited = make(map[string]*os.File)
+	for _, kv := range strings.Split(os.Getenv(EnvListenFDs), ",") {
+		idx := strings.LastIndexByte(kv, '=')
+		if idx <= 0 {
+			continue
+		}
+		fd, err := strconv.Atoi(kv[idx+1:])
+		if err != nil {
+			continue
+		}
+		inherited[kv[:idx]] = os.NewFile(uintptr(fd), "listener:"+kv[:idx])
+	}
+	os.Unsetenv(EnvListenFDs)
+}
+
+// Listen 监听地址, 父进程交接了该地址的监听时直接使用, 否则新建监听
+// 地址需要和父进程监听时使用的字符串一致
+func Listen(network, addr string) (net.Listener, error) {
+	inheritOnce.Do(loadInherited)
+	inheritMu.Lock()
+	f, ok := inherited[addr]
+	delete(inherited, addr)
+	inheritMu.Unlock()
+	if !ok {
+		return net.Listen(network, addr)
+	}
+	defer f.Close()
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("handoff: inherit %s: %w", addr, err)
+	}
+	logrus.WithField("module", "handoff").Infof("inherited listener %s", addr)
+	return l, nil
+}
+
+// Restart 用相同的参数启动新进程, 并把监听交给新进程
+// listeners 的key为 Listen 时使用的地址; 返回后旧进程应该调用 Shutdown 优雅关闭已有连接,
+// 新连接由新进程接收; 旧进程的 Drainer 设置 SetKickSpread 后分批断开, 设备不会同时重连
+func Restart(listeners map[string]net.Listener) (*os.Process, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		files []*os.File
+		fds   []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for addr, l := range listeners {
+		fl, ok := l.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrNotFileListener, addr)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return nil, fmt.Errorf("handoff: %s: %w", addr, err)
+		}
+		// ExtraFiles 从fd 3开始
+		fds = append(fds, fmt.Sprintf("%s=%d", addr, 3+len(files)))
+		files = append(files, f)
+	}
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, EnvListenFDs+"=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, EnvListenFDs+"="+strings.Join(fds, ","))
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	logrus.WithField("module", "handoff").Infof("started new process %d with %d listeners", cmd.Process.Pid, len(files))
+	return cmd.Process, nil
+}
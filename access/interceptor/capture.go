@@ -0,0 +1,42 @@
+package interceptor
+
+import (
+	"context"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Capture 把上行原始报文和协议的应答报文写入 sink
+// 上行报文在翻译之后写入, 这样可以带上协议解析出的命令码和mark
+func Capture(sink driver.FrameSink) driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		tos, rets, err = handler(ctx)
+
+		now := time.Now()
+		if raw := acctx.RawBytes(); raw != nil {
+			sink.WriteFrame(&driver.Frame{
+				Time:   now,
+				Mark:   acctx.Mark,
+				Driver: acctx.DriverName,
+				Remote: acctx.GetRemote(),
+				Cmd:    acctx.GetCmd(),
+				Dir:    driver.DirIn,
+				Data:   raw,
+			})
+		}
+		for _, ret := range rets {
+			for _, b := range driver.MsgBytes(ret) {
+				sink.WriteFrame(&driver.Frame{
+					Time:   now,
+					Mark:   ret.GetMark(),
+					Driver: acctx.DriverName,
+					Remote: acctx.GetRemote(),
+					Dir:    driver.DirOut,
+					Data:   b,
+				})
+			}
+		}
+		return
+	}
+}
@@ -0,0 +1,33 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Logging 使用 Ctx.Log 记录每帧报文的翻译结果
+// 正常和 ErrIgnore 记录为debug, 其它错误记录为error
+func Logging() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		start := time.Now()
+		tos, rets, err = handler(ctx)
+
+		log := acctx.Log.WithField("driver", acctx.DriverName).
+			WithField("cmd", acctx.GetCmd()).
+			WithField("cost", time.Since(start).String()).
+			WithField("tos", len(tos)).
+			WithField("rets", len(rets))
+		switch {
+		case err == nil:
+			log.Debugf("translate raw[%x]", acctx.RawBytes())
+		case errors.Is(err, driver.ErrIgnore):
+			log.Debugf("translate ignore raw[%x]: %v", acctx.RawBytes(), err)
+		default:
+			log.Errorf("translate raw[%x]: %v", acctx.RawBytes(), err)
+		}
+		return
+	}
+}
@@ -0,0 +1,57 @@
+package interceptor
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var (
+	translateCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_translate_total",
+		Help: "protocol translate count by driver/cmd/result",
+	}, []string{"driver", "cmd", "result"})
+	translateLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "access_translate_seconds",
+		Help:    "protocol translate latency by driver/cmd",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	}, []string{"driver", "cmd"})
+)
+
+// Result 翻译结果分类, 用作指标标签
+func Result(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, driver.ErrIgnore):
+		return "ignore"
+	case errors.Is(err, driver.ErrKick):
+		return "kick"
+	case errors.Is(err, driver.ErrBlock):
+		return "block"
+	case errors.Is(err, driver.ErrAlreadySession):
+		return "already_session"
+	}
+	return "error"
+}
+
+// Metrics 按命令统计翻译次数和耗时
+// 命令码取自 Ctx.GetCmd(), 协议未设置时为 unknown
+func Metrics() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		start := time.Now()
+		tos, rets, err = handler(ctx)
+
+		cmd := acctx.GetCmd()
+		if cmd == "" {
+			cmd = "unknown"
+		}
+		translateLatency.WithLabelValues(acctx.DriverName, cmd).Observe(time.Since(start).Seconds())
+		translateCounter.WithLabelValues(acctx.DriverName, cmd, Result(err)).Inc()
+		return
+	}
+}
@@ -0,0 +1,21 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+	"github.com/zhuoqingbin/utils/access/ratelimit"
+)
+
+// RateLimit 按设备标记限流, 每个mark每秒最多 rate 帧, 允许 burst 帧突发
+// 超过限制的报文返回 driver.ErrIgnore 丢弃
+func RateLimit(rate float64, burst int) driver.TranslateInterceptor {
+	limiter := ratelimit.NewKeyed(rate, burst)
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) ([]driver.Msg, []driver.Msg, error) {
+		if !limiter.Allow(acctx.Mark) {
+			return nil, nil, fmt.Errorf("%w: rate limit mark[%s]", driver.ErrIgnore, acctx.Mark)
+		}
+		return handler(ctx)
+	}
+}
@@ -0,0 +1,30 @@
+package interceptor
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Recovery 捕获协议翻译中的panic, 转成错误返回, 避免整个驱动协程退出
+// kick 为true时返回 driver.ErrKick, 让驱动断开连接
+func Recovery(kick bool) driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				buf := make([]byte, 64<<10)
+				buf = buf[:runtime.Stack(buf, false)]
+				acctx.Log.Errorf("translate panic recovered: %v raw[%x]\n%s", r, acctx.RawBytes(), buf)
+				tos, rets = nil, nil
+				if kick {
+					err = fmt.Errorf("%w: translate panic: %v", driver.ErrKick, r)
+				} else {
+					err = fmt.Errorf("translate panic: %v", r)
+				}
+			}
+		}()
+		return handler(ctx)
+	}
+}
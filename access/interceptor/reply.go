@@ -0,0 +1,28 @@
+package interceptor
+
+import (
+	"context"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Reply 把翻译出的上行消息交给 reply 匹配等待中的下发(outbox/ota/devconf等)
+// consume 为true时匹配成功的消息不再交给业务处理
+func Reply(reply func(msg driver.Msg) bool, consume bool) driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		tos, rets, err = handler(ctx)
+		if err != nil {
+			return
+		}
+		n := 0
+		for _, to := range tos {
+			if reply(to) && consume {
+				continue
+			}
+			tos[n] = to
+			n++
+		}
+		tos = tos[:n]
+		return
+	}
+}
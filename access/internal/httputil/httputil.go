@@ -0,0 +1,36 @@
+// Package httputil access 下各管理接口共用的json应答
+package httputil
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+)
+
+// WriteJSON 以 code 状态码写入json应答
+func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// WriteError 写入 {"error": "..."} 应答
+func WriteError(w http.ResponseWriter, code int, err error) {
+	WriteJSON(w, code, map[string]string{"error": err.Error()})
+}
+
+// Status 错误对应的http状态码
+type Status struct {
+	Err  error
+	Code int
+}
+
+// ErrStatus 按顺序返回第一个匹配(errors.Is)的状态码, 都不匹配时返回 def
+func ErrStatus(err error, def int, statuses ...Status) int {
+	for _, s := range statuses {
+		if errors.Is(err, s.Err) {
+			return s.Code
+		}
+	}
+	return def
+}
@@ -0,0 +1,180 @@
+package mux
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Conn 已识别协议的连接, 探测时读取的字节会在Read时重新返回
+type Conn struct {
+	net.Conn
+	r      io.Reader
+	m      *Mux
+	route  *route
+	remote string
+	once   sync.Once
+}
+
+// Read 先返回探测时读取的字节
+func (c *Conn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// Protocol 绑定的协议
+func (c *Conn) Protocol() driver.Protocol {
+	return c.route.protocol
+}
+
+// DriverName 绑定的协议名, 驱动设置到 Ctx.DriverName
+func (c *Conn) DriverName() string {
+	return c.route.name
+}
+
+// Close 关闭连接并解除绑定
+func (c *Conn) Close() error {
+	c.once.Do(func() { c.m.unbind(c.remote, c.route) })
+	return c.Conn.Close()
+}
+
+// Detect 探测连接的协议, 返回绑定了协议的连接
+// 超时或连接关闭时使用默认协议, 没有默认协议返回 ErrNoRoute
+func (m *Mux) Detect(conn net.Conn) (*Conn, error) {
+	start := time.Now()
+	defer func() { detectHistogram.WithLabelValues(m.name).Observe(time.Since(start).Seconds()) }()
+
+	conn.SetReadDeadline(start.Add(m.timeout))
+	defer conn.SetReadDeadline(time.Time{})
+
+	var (
+		head   = make([]byte, 0, m.peek)
+		buf    = make([]byte, m.peek)
+		r      *route
+		done   bool
+		result = "claimed"
+	)
+	for !done {
+		n, err := conn.Read(buf[:m.peek-len(head)])
+		head = append(head, buf[:n]...)
+		if err != nil {
+			var ne net.Error
+			if errors.As(err, &ne) && ne.Timeout() {
+				result = "timeout"
+			} else if len(head) == 0 {
+				detectCounter.WithLabelValues(m.name, "", "closed").Inc()
+				return nil, err
+			}
+			r, _ = m.detect(head, true)
+			break
+		}
+		r, done = m.detect(head, len(head) >= m.peek)
+	}
+
+	switch {
+	case r == nil:
+		detectCounter.WithLabelValues(m.name, "", "rejected").Inc()
+		return nil, ErrNoRoute
+	case r == m.def && result == "claimed":
+		result = "default"
+	}
+	detectCounter.WithLabelValues(m.name, r.name, result).Inc()
+
+	c := &Conn{
+		Conn:   conn,
+		r:      io.MultiReader(bytes.NewReader(head), conn),
+		m:      m,
+		route:  r,
+		remote: conn.RemoteAddr().String(),
+	}
+	m.bind(c.remote, r)
+	return c, nil
+}
+
+// Listener 包装监听, Accept返回已识别协议的 *Conn
+// 探测在独立协程中进行, 不阻塞其它连接的Accept
+func (m *Mux) Listener(l net.Listener) net.Listener {
+	ml := &listener{
+		Listener: l,
+		m:        m,
+		conns:    make(chan *Conn),
+		errc:     make(chan error, 1),
+		done:     make(chan struct{}),
+		log:      logrus.WithField("module", "mux").WithField("mux", m.name),
+	}
+	go ml.loop()
+	return ml
+}
+
+type listener struct {
+	net.Listener
+	m     *Mux
+	conns chan *Conn
+	errc  chan error
+	done  chan struct{}
+	once  sync.Once
+	log   *logrus.Entry
+}
+
+func (l *listener) loop() {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if retryable(err) {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			select {
+			case l.errc <- err:
+			case <-l.done:
+			}
+			return
+		}
+		go func() {
+			c, err := l.m.Detect(conn)
+			if err != nil {
+				l.log.WithField("remote", conn.RemoteAddr().String()).Debugf("detect: %v", err)
+				conn.Close()
+				return
+			}
+			select {
+			case l.conns <- c:
+			case <-l.done:
+				c.Close()
+			}
+		}()
+	}
+}
+
+// Accept 返回已识别协议的连接
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case err := <-l.errc:
+		return nil, err
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return l.Listener.Close()
+}
+
+// retryable Accept的错误是否可以重试: 超时, 连接在accept前被对端中止, 文件描述符/内存暂时不足
+func retryable(err error) bool {
+	var ne net.Error
+	if errors.As(err, &ne) && ne.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EMFILE) ||
+		errors.Is(err, syscall.ENFILE) || errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.ENOMEM)
+}
@@ -0,0 +1,227 @@
+package mux
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var (
+	// ErrNoRoute 没有协议认领连接且没有默认协议
+	ErrNoRoute = errors.New("mux: no protocol claimed the connection")
+
+	// ErrNoRemote 驱动没有设置 driver.KeyRemote, 无法找到连接绑定的协议
+	ErrNoRemote = errors.New("mux: remote address not set in ctx")
+
+	detectCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_mux_detect_total",
+		Help: "protocol detection count by mux/protocol/result(claimed/default/timeout/rejected/no_remote)",
+	}, []string{"mux", "protocol", "result"})
+	detectHistogram = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "access_mux_detect_seconds",
+		Help:    "protocol detection latency",
+		Buckets: []float64{.001, .005, .01, .05, .1, .5, 1, 2, 5},
+	}, []string{"mux"})
+)
+
+// Verdict 探测结果
+type Verdict int
+
+const (
+	// Reject 不是本协议
+	Reject Verdict = iota
+	// Claim 认领连接
+	Claim
+	// NeedMore 数据不够, 需要更多字节才能判断
+	NeedMore
+)
+
+// DetectFunc 根据连接最先收到的字节判断协议
+type DetectFunc func(head []byte) Verdict
+
+// Detector 协议自己实现探测时, Handle 可以不传 DetectFunc
+type Detector interface {
+	Detect(head []byte) Verdict
+}
+
+// Prefix 按报文起始字节探测, 如 Prefix([]byte{0x68}) / Prefix([]byte("GET "))
+func Prefix(prefixes ...[]byte) DetectFunc {
+	return func(head []byte) Verdict {
+		v := Reject
+		for _, p := range prefixes {
+			if len(head) >= len(p) {
+				if bytes.HasPrefix(head, p) {
+					return Claim
+				}
+				continue
+			}
+			if bytes.HasPrefix(p, head) {
+				v = NeedMore
+			}
+		}
+		return v
+	}
+}
+
+type route struct {
+	name     string
+	protocol driver.Protocol
+	detect   DetectFunc
+}
+
+// Mux 同一监听端口接入多种协议
+// 新连接先读取最多peek个字节交给各协议探测, 第一个认领的协议和连接绑定, 都不认领时使用默认协议
+// 驱动使用 Listener 包装监听, 并把 Protocol() 作为驱动的协议注入, 翻译时按连接(Ctx远端地址)分发到绑定的协议,
+// 同时把 Ctx.DriverName 设置为协议名; 驱动没有设置远端地址(driver.KeyRemote)时返回 ErrNoRemote
+//
+//	m := mux.New("public").Handle("ykc", ykcProto, mux.Prefix([]byte{0x68})).Default("ocpp16", ocppProto)
+//	ln, _ := net.Listen("tcp", ":8767")
+//	tcpDriver.Serve(m.Listener(ln))
+//	tcpDriver.Inject(driver.InjectProtocol, m.Protocol())
+type Mux struct {
+	name    string
+	peek    int
+	timeout time.Duration
+
+	routes []*route
+	def    *route
+
+	mu       sync.RWMutex
+	bindings map[string]*route // remote -> route
+}
+
+// New 创建多路复用, 默认探测16字节, 超时3秒
+func New(name string) *Mux {
+	return &Mux{
+		name:     name,
+		peek:     16,
+		timeout:  3 * time.Second,
+		bindings: make(map[string]*route),
+	}
+}
+
+// SetPeek 最多读取多少字节用于探测
+func (m *Mux) SetPeek(n int) *Mux {
+	m.peek = n
+	return m
+}
+
+// SetTimeout 探测超时, 超时后使用默认协议
+func (m *Mux) SetTimeout(t time.Duration) *Mux {
+	m.timeout = t
+	return m
+}
+
+// Handle 注册协议和探测, detect为nil时协议需要实现 Detector
+// 按注册顺序探测
+func (m *Mux) Handle(name string, p driver.Protocol, detect DetectFunc) *Mux {
+	if detect == nil {
+		d, ok := driver.As[Detector](p)
+		if !ok {
+			panic("mux: protocol " + name + " has no detector")
+		}
+		detect = d.Detect
+	}
+	m.routes = append(m.routes, &route{name: name, protocol: p, detect: detect})
+	return m
+}
+
+// Default 没有协议认领时使用的协议
+func (m *Mux) Default(name string, p driver.Protocol) *Mux {
+	m.def = &route{name: name, protocol: p}
+	return m
+}
+
+// detect 探测协议, final为true表示不会再有更多数据
+func (m *Mux) detect(head []byte, final bool) (r *route, done bool) {
+	more := false
+	for _, r := range m.routes {
+		switch r.detect(head) {
+		case Claim:
+			return r, true
+		case NeedMore:
+			more = true
+		}
+	}
+	if more && !final && len(head) < m.peek {
+		return nil, false
+	}
+	return m.def, true
+}
+
+func (m *Mux) bind(remote string, r *route) {
+	m.mu.Lock()
+	m.bindings[remote] = r
+	m.mu.Unlock()
+}
+
+func (m *Mux) unbind(remote string, r *route) {
+	m.mu.Lock()
+	if m.bindings[remote] == r {
+		delete(m.bindings, remote)
+	}
+	m.mu.Unlock()
+}
+
+// Binding 连接绑定的协议名
+func (m *Mux) Binding(remote string) (name string, ok bool) {
+	m.mu.RLock()
+	r, ok := m.bindings[remote]
+	m.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return r.name, true
+}
+
+// Protocol 分发协议, 作为驱动的协议注入
+// 实现了 driver.Inject, 注入的内容会转给所有协议
+func (m *Mux) Protocol() driver.Protocol {
+	return &muxProtocol{m: m}
+}
+
+type muxProtocol struct {
+	m *Mux
+}
+
+func (p *muxProtocol) Translate(ctx context.Context) (tos []driver.Msg, rets []driver.Msg, err error) {
+	acctx := driver.FromContext(ctx)
+	if acctx == nil {
+		return nil, nil, ErrNoRoute
+	}
+	remote := acctx.GetRemote()
+	if remote == "" {
+		// 不能回退到默认协议, 否则所有报文都会被默认协议处理
+		detectCounter.WithLabelValues(p.m.name, "", "no_remote").Inc()
+		return nil, nil, ErrNoRemote
+	}
+	p.m.mu.RLock()
+	r, ok := p.m.bindings[remote]
+	p.m.mu.RUnlock()
+	if !ok {
+		if r = p.m.def; r == nil {
+			return nil, nil, ErrNoRoute
+		}
+	}
+	acctx.DriverName = r.name
+	return r.protocol.Translate(ctx)
+}
+
+func (p *muxProtocol) Inject(name string, v interface{}) driver.Inject {
+	routes := p.m.routes
+	if p.m.def != nil {
+		routes = append(routes[:len(routes):len(routes)], p.m.def)
+	}
+	for _, r := range routes {
+		if in, ok := driver.As[driver.Inject](r.protocol); ok {
+			in.Inject(name, v)
+		}
+	}
+	return p
+}
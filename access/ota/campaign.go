@@ -0,0 +1,152 @@
+package ota
+
+import (
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Stage 升级阶段
+type Stage int
+
+const (
+	StageBegin Stage = iota // 开始(下发镜像信息)
+	StageChunk              // 传输分片
+	StageEnd                // 结束(设备校验镜像)
+)
+
+// Codec 协议的升级报文, 由协议实现
+type Codec interface {
+	// ChunkSize 单个分片的字节数
+	ChunkSize(mark string) int
+	// Begin 开始报文(镜像大小/版本/校验和), 返回nil表示协议没有开始报文
+	Begin(mark string, img *Image) (driver.Msg, error)
+	// Chunk 分片报文
+	Chunk(mark string, img *Image, offset int, data []byte) (driver.Msg, error)
+	// End 结束报文, 返回nil表示协议没有结束报文(最后一个分片确认即成功)
+	End(mark string, img *Image) (driver.Msg, error)
+	// Ack 解析设备上行报文, 是升级应答时返回true
+	Ack(msg driver.Msg) (ack Ack, ok bool)
+}
+
+// Ack 设备升级应答
+type Ack struct {
+	Mark   string
+	Stage  Stage
+	OK     bool
+	Offset int    // 设备已接收的字节数(下一个需要的偏移); 开始应答<0表示从上次确认的位置续传
+	Reason string // 失败原因
+}
+
+// State 设备升级状态
+type State string
+
+const (
+	StateWaiting      State = "waiting"      // 等待升级(未开始/设备离线/排队)
+	StateTransferring State = "transferring" // 传输中
+	StateVerifying    State = "verifying"    // 设备校验中
+	StateSuccess      State = "success"
+	StateFailed       State = "failed"
+	StateCancelled    State = "cancelled"
+)
+
+// Final 是否结束状态
+func (s State) Final() bool {
+	return s == StateSuccess || s == StateFailed || s == StateCancelled
+}
+
+// CampaignState 升级任务状态
+type CampaignState string
+
+const (
+	CampaignRunning   CampaignState = "running"
+	CampaignPaused    CampaignState = "paused"
+	CampaignCancelled CampaignState = "cancelled"
+	CampaignDone      CampaignState = "done"
+)
+
+// Progress 设备升级进度
+type Progress struct {
+	Mark      string    `json:"mark"`
+	State     State     `json:"state"`
+	Offset    int       `json:"offset"` // 设备已确认的字节数
+	Size      int       `json:"size"`
+	Attempts  int       `json:"attempts"` // 当前报文的发送次数
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	stage  Stage
+	sentAt time.Time
+}
+
+// Percent 进度百分比
+func (p *Progress) Percent() float64 {
+	if p.Size == 0 {
+		return 0
+	}
+	return float64(p.Offset) * 100 / float64(p.Size)
+}
+
+// Campaign 一次批量升级任务
+type Campaign struct {
+	ID          string
+	Image       *Image
+	Concurrency int
+	State       CampaignState
+	CreatedAt   time.Time
+
+	devices map[string]*Progress
+	order   []string
+}
+
+// CampaignStatus 升级任务状态
+type CampaignStatus struct {
+	ID          string        `json:"id"`
+	Version     string        `json:"version"`
+	Size        int           `json:"size"`
+	Concurrency int           `json:"concurrency"`
+	State       CampaignState `json:"state"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Counts      map[State]int `json:"counts"`
+	Devices     []Progress    `json:"devices,omitempty"`
+}
+
+func (c *Campaign) status(devices bool) *CampaignStatus {
+	st := &CampaignStatus{
+		ID:          c.ID,
+		Version:     c.Image.Version,
+		Size:        c.Image.Size(),
+		Concurrency: c.Concurrency,
+		State:       c.State,
+		CreatedAt:   c.CreatedAt,
+		Counts:      make(map[State]int),
+	}
+	for _, mark := range c.order {
+		p := c.devices[mark]
+		st.Counts[p.State]++
+		if devices {
+			st.Devices = append(st.Devices, *p)
+		}
+	}
+	return st
+}
+
+// active 正在升级(占用并发)的设备数
+func (c *Campaign) active() (n int) {
+	for _, p := range c.devices {
+		if p.State == StateTransferring || p.State == StateVerifying {
+			n++
+		}
+	}
+	return
+}
+
+func (c *Campaign) finished() bool {
+	for _, p := range c.devices {
+		if !p.State.Final() {
+			return false
+		}
+	}
+	return true
+}
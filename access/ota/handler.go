@@ -0,0 +1,101 @@
+package ota
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/zhuoqingbin/utils/access/internal/httputil"
+)
+
+// Handler 升级任务管理接口, 挂载时需要 http.StripPrefix
+//
+//	GET    /                                   所有任务
+//	POST   /?version=v2&marks=a,b&concurrency=50&sha256=xx   body为镜像, 创建并启动任务
+//	GET    /{id}                               任务状态和设备进度
+//	POST   /{id}/pause | /{id}/resume | /{id}/cancel
+//	DELETE /{id}                               删除已结束的任务
+func (m *Manager) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		if parts[0] == "" {
+			switch r.Method {
+			case http.MethodGet:
+				httputil.WriteJSON(w, http.StatusOK, m.Campaigns())
+			case http.MethodPost:
+				m.handleStart(w, r)
+			default:
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+			return
+		}
+
+		id := parts[0]
+		var err error
+		switch {
+		case len(parts) == 1 && r.Method == http.MethodGet:
+			st, err := m.Status(id)
+			if err != nil {
+				httputil.WriteError(w, errStatus(err), err)
+				return
+			}
+			httputil.WriteJSON(w, http.StatusOK, st)
+			return
+		case len(parts) == 1 && r.Method == http.MethodDelete:
+			err = m.Remove(id)
+		case len(parts) == 2 && r.Method == http.MethodPost && parts[1] == "pause":
+			err = m.Pause(id)
+		case len(parts) == 2 && r.Method == http.MethodPost && parts[1] == "resume":
+			err = m.Resume(id)
+		case len(parts) == 2 && r.Method == http.MethodPost && parts[1] == "cancel":
+			err = m.Cancel(id)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			httputil.WriteError(w, errStatus(err), err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func (m *Manager) handleStart(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		httputil.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+	img := NewImage(q.Get("version"), data)
+	for _, algo := range []string{"sha256", "md5", "crc32"} {
+		if v := q.Get(algo); v != "" {
+			if err := img.VerifyChecksum(algo, v); err != nil {
+				httputil.WriteError(w, http.StatusBadRequest, err)
+				return
+			}
+		}
+	}
+	var marks []string
+	for _, mark := range strings.Split(q.Get("marks"), ",") {
+		if mark = strings.TrimSpace(mark); mark != "" {
+			marks = append(marks, mark)
+		}
+	}
+	concurrency, _ := strconv.Atoi(q.Get("concurrency"))
+	id, err := m.Start(img, marks, concurrency)
+	if err != nil {
+		httputil.WriteError(w, errStatus(err), err)
+		return
+	}
+	httputil.WriteJSON(w, http.StatusOK, map[string]string{"id": id})
+}
+
+func errStatus(err error) int {
+	return httputil.ErrStatus(err, http.StatusBadRequest,
+		httputil.Status{Err: ErrNotFound, Code: http.StatusNotFound},
+		httputil.Status{Err: ErrDeviceBusy, Code: http.StatusConflict},
+		httputil.Status{Err: ErrCampaignState, Code: http.StatusConflict})
+}
@@ -0,0 +1,101 @@
+package ota
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strings"
+)
+
+var (
+	// ErrChecksum 镜像校验和不一致
+	ErrChecksum = errors.New("ota: checksum mismatch")
+	// ErrSignature 镜像签名校验失败
+	ErrSignature = errors.New("ota: invalid signature")
+)
+
+// Image 固件镜像
+type Image struct {
+	Version string
+	Data    []byte
+	SHA256  string // hex
+	MD5     string // hex
+	CRC32   uint32 // IEEE
+}
+
+// NewImage 创建镜像并计算校验和, 协议的结束报文可以直接使用
+func NewImage(version string, data []byte) *Image {
+	sum := sha256.Sum256(data)
+	md := md5.Sum(data)
+	return &Image{
+		Version: version,
+		Data:    data,
+		SHA256:  hex.EncodeToString(sum[:]),
+		MD5:     hex.EncodeToString(md[:]),
+		CRC32:   crc32.ChecksumIEEE(data),
+	}
+}
+
+// Size 镜像大小
+func (img *Image) Size() int {
+	return len(img.Data)
+}
+
+// Chunk 获取分片, 超出镜像大小的部分截断
+func (img *Image) Chunk(offset, size int) []byte {
+	if offset >= len(img.Data) {
+		return nil
+	}
+	end := offset + size
+	if end > len(img.Data) {
+		end = len(img.Data)
+	}
+	return img.Data[offset:end]
+}
+
+// VerifyChecksum 校验镜像, algo 为 sha256/md5/crc32, expected 为hex(crc32为8位hex)
+func (img *Image) VerifyChecksum(algo, expected string) error {
+	var got string
+	switch strings.ToLower(algo) {
+	case "sha256":
+		got = img.SHA256
+	case "md5":
+		got = img.MD5
+	case "crc32":
+		got = fmt.Sprintf("%08x", img.CRC32)
+	default:
+		return fmt.Errorf("ota: unknown checksum algorithm %q", algo)
+	}
+	if !strings.EqualFold(got, expected) {
+		return fmt.Errorf("%w: %s %s, want %s", ErrChecksum, algo, got, expected)
+	}
+	return nil
+}
+
+// VerifySignature 校验镜像签名, 签名内容为镜像的sha256摘要
+// 支持 RSA(PKCS#1 v1.5) / ECDSA(ASN.1) / Ed25519
+func (img *Image) VerifySignature(pub crypto.PublicKey, sig []byte) error {
+	digest := sha256.Sum256(img.Data)
+	ok := false
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(k, crypto.SHA256, digest[:], sig) == nil
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(k, digest[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(k, digest[:], sig)
+	default:
+		return fmt.Errorf("ota: unsupported public key %T", pub)
+	}
+	if !ok {
+		return ErrSignature
+	}
+	return nil
+}
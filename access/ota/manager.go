@@ -0,0 +1,466 @@
+package ota
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+	"github.com/zhuoqingbin/utils/access/interceptor"
+	"github.com/zhuoqingbin/utils/uuid"
+)
+
+var (
+	// ErrNotFound 升级任务不存在
+	ErrNotFound = errors.New("ota: campaign not found")
+	// ErrDeviceBusy 设备在其它未结束的升级任务中
+	ErrDeviceBusy = errors.New("ota: device busy in another campaign")
+	// ErrCampaignState 当前任务状态不允许该操作
+	ErrCampaignState = errors.New("ota: invalid campaign state")
+	// ErrNoMarks 升级任务没有设备
+	ErrNoMarks = errors.New("ota: no marks")
+
+	otaCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_ota_total",
+		Help: "ota count by ota/event(chunk/retry/success/failed/cancelled/resume)",
+	}, []string{"ota", "event"})
+)
+
+// Manager 固件升级管理
+// 按协议分片下发镜像, 每个分片等待设备确认后发送下一片; 超时重发, 设备断开后重新上线时从最后确认的位置续传
+// 同一任务同时升级的设备数受并发数限制; 进度只保存在内存中
+//
+//	m := ota.New("ykc", tcpDriver, ykcOTACodec)
+//	go m.Run(ctx)
+//	pipeline.Intercept(m.Interceptor(true))
+//	img := ota.NewImage("v2.1.0", data)
+//	if err := img.VerifySignature(pub, sig); err != nil {...}
+//	id, err := m.Start(img, marks, 50)
+type Manager struct {
+	name        string
+	driver      driver.Driver
+	codec       Codec
+	ackTimeout  time.Duration
+	maxAttempts int
+	interval    time.Duration
+	log         *logrus.Entry
+
+	mu        sync.Mutex
+	campaigns map[string]*Campaign
+	busy      map[string]string // mark -> campaign id(未结束)
+}
+
+// New 创建升级管理, name用于指标标签
+func New(name string, d driver.Driver, c Codec) *Manager {
+	return &Manager{
+		name:        name,
+		driver:      d,
+		codec:       c,
+		ackTimeout:  30 * time.Second,
+		maxAttempts: 5,
+		interval:    time.Second,
+		log:         logrus.WithField("module", "ota").WithField("ota", name),
+		campaigns:   make(map[string]*Campaign),
+		busy:        make(map[string]string),
+	}
+}
+
+// SetAckTimeout 应答超时时间, 超时后重发
+func (m *Manager) SetAckTimeout(t time.Duration) *Manager {
+	m.ackTimeout = t
+	return m
+}
+
+// SetMaxAttempts 同一报文最大发送次数, 超过后设备升级失败
+func (m *Manager) SetMaxAttempts(n int) *Manager {
+	m.maxAttempts = n
+	return m
+}
+
+// SetInterval 调度间隔
+func (m *Manager) SetInterval(t time.Duration) *Manager {
+	m.interval = t
+	return m
+}
+
+// Start 创建并启动升级任务, concurrency<=0表示不限制并发
+// marks 去掉空值和重复后不能为空
+func (m *Manager) Start(img *Image, marks []string, concurrency int) (id string, err error) {
+	if img.Size() == 0 {
+		return "", fmt.Errorf("ota: empty image")
+	}
+	uniq := make([]string, 0, len(marks))
+	seen := make(map[string]bool, len(marks))
+	for _, mark := range marks {
+		if mark == "" || seen[mark] {
+			continue
+		}
+		seen[mark] = true
+		uniq = append(uniq, mark)
+	}
+	if len(uniq) == 0 {
+		return "", ErrNoMarks
+	}
+	marks = uniq
+
+	c := &Campaign{
+		ID:          uuid.GetID().String(),
+		Image:       img,
+		Concurrency: concurrency,
+		State:       CampaignRunning,
+		CreatedAt:   time.Now(),
+		devices:     make(map[string]*Progress, len(marks)),
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, mark := range marks {
+		if _, ok := m.busy[mark]; ok {
+			return "", fmt.Errorf("%w: %s", ErrDeviceBusy, mark)
+		}
+	}
+	for _, mark := range marks {
+		c.devices[mark] = &Progress{Mark: mark, State: StateWaiting, Size: img.Size(), UpdatedAt: c.CreatedAt}
+		c.order = append(c.order, mark)
+		m.busy[mark] = c.ID
+	}
+	m.campaigns[c.ID] = c
+	m.log.Infof("campaign[%s] start version %s, %d devices", c.ID, img.Version, len(c.order))
+	return c.ID, nil
+}
+
+// Pause 暂停任务, 传输中的设备停在当前位置
+func (m *Manager) Pause(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if c.State != CampaignRunning {
+		return fmt.Errorf("%w: %s", ErrCampaignState, c.State)
+	}
+	c.State = CampaignPaused
+	return nil
+}
+
+// Resume 继续暂停的任务, 从设备最后确认的位置续传
+func (m *Manager) Resume(id string) error {
+	m.mu.Lock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		m.mu.Unlock()
+		return ErrNotFound
+	}
+	if c.State != CampaignPaused {
+		m.mu.Unlock()
+		return fmt.Errorf("%w: %s", ErrCampaignState, c.State)
+	}
+	c.State = CampaignRunning
+	var sends []func()
+	for _, p := range c.devices {
+		if p.State == StateTransferring || p.State == StateVerifying {
+			p.Attempts = 0
+			sends = append(sends, m.next(c, p))
+		}
+	}
+	m.mu.Unlock()
+	run(sends)
+	return nil
+}
+
+// Cancel 取消任务, 未完成的设备标记为取消
+func (m *Manager) Cancel(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if c.State == CampaignCancelled || c.State == CampaignDone {
+		return fmt.Errorf("%w: %s", ErrCampaignState, c.State)
+	}
+	c.State = CampaignCancelled
+	now := time.Now()
+	for _, p := range c.devices {
+		if !p.State.Final() {
+			p.State, p.UpdatedAt = StateCancelled, now
+			delete(m.busy, p.Mark)
+			otaCounter.WithLabelValues(m.name, "cancelled").Inc()
+		}
+	}
+	return nil
+}
+
+// Remove 删除已结束的任务
+func (m *Manager) Remove(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if c.State != CampaignCancelled && c.State != CampaignDone {
+		return fmt.Errorf("%w: %s", ErrCampaignState, c.State)
+	}
+	delete(m.campaigns, id)
+	return nil
+}
+
+// Status 任务状态和所有设备进度
+func (m *Manager) Status(id string) (*CampaignStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	c, ok := m.campaigns[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return c.status(true), nil
+}
+
+// Campaigns 所有任务状态(不含设备进度), 按创建时间排序
+func (m *Manager) Campaigns() []*CampaignStatus {
+	m.mu.Lock()
+	ret := make([]*CampaignStatus, 0, len(m.campaigns))
+	for _, c := range m.campaigns {
+		ret = append(ret, c.status(false))
+	}
+	m.mu.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].CreatedAt.Before(ret[j].CreatedAt) })
+	return ret
+}
+
+// Progress 设备在未结束任务中的进度
+func (m *Manager) Progress(mark string) (Progress, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.busy[mark]
+	if !ok {
+		return Progress{}, false
+	}
+	return *m.campaigns[id].devices[mark], true
+}
+
+func run(fs []func()) {
+	for _, f := range fs {
+		f()
+	}
+}
+
+func (m *Manager) finish(c *Campaign, p *Progress, state State, reason string) {
+	p.State, p.Error, p.UpdatedAt = state, reason, time.Now()
+	delete(m.busy, p.Mark)
+	otaCounter.WithLabelValues(m.name, string(state)).Inc()
+	if state == StateFailed {
+		m.log.WithField("mark", p.Mark).Warnf("campaign[%s] failed: %s", c.ID, reason)
+	}
+	if c.finished() {
+		c.State = CampaignDone
+		m.log.Infof("campaign[%s] done", c.ID)
+	}
+}
+
+// next 设备当前阶段要发送的报文, 在锁内调用, 返回的函数在锁外执行发送
+func (m *Manager) next(c *Campaign, p *Progress) func() {
+	var (
+		msg driver.Msg
+		err error
+	)
+	mark, img := p.Mark, c.Image
+	switch p.stage {
+	case StageBegin:
+		if msg, err = m.codec.Begin(mark, img); err == nil && msg == nil {
+			p.stage = StageChunk
+			return m.next(c, p)
+		}
+	case StageChunk:
+		if p.Offset >= img.Size() {
+			p.stage = StageEnd
+			return m.next(c, p)
+		}
+		size := m.codec.ChunkSize(mark)
+		if size <= 0 {
+			err = fmt.Errorf("ota: invalid chunk size %d", size)
+			break
+		}
+		msg, err = m.codec.Chunk(mark, img, p.Offset, img.Chunk(p.Offset, size))
+	case StageEnd:
+		if msg, err = m.codec.End(mark, img); err == nil && msg == nil {
+			m.finish(c, p, StateSuccess, "")
+			return func() {}
+		}
+		p.State = StateVerifying
+	}
+	if err != nil {
+		m.finish(c, p, StateFailed, err.Error())
+		return func() {}
+	}
+
+	p.Attempts++
+	p.sentAt, p.UpdatedAt = time.Now(), time.Now()
+	stage := p.stage
+	return func() {
+		if err := m.driver.Send(msg); err != nil {
+			// 发送失败等待超时重发
+			m.log.WithField("mark", mark).Warnf("send stage %d: %v", stage, err)
+			return
+		}
+		if stage == StageChunk {
+			otaCounter.WithLabelValues(m.name, "chunk").Inc()
+		}
+	}
+}
+
+// Reply 投递设备上行报文, 是升级应答时处理并返回true
+func (m *Manager) Reply(msg driver.Msg) bool {
+	ack, ok := m.codec.Ack(msg)
+	if !ok {
+		return false
+	}
+	if ack.Mark == "" {
+		ack.Mark = msg.GetMark()
+	}
+
+	m.mu.Lock()
+	id, ok := m.busy[ack.Mark]
+	if !ok {
+		m.mu.Unlock()
+		return true
+	}
+	c := m.campaigns[id]
+	p := c.devices[ack.Mark]
+	if p.State != StateTransferring && p.State != StateVerifying || ack.Stage != p.stage {
+		m.mu.Unlock()
+		return true
+	}
+
+	var send func()
+	switch ack.Stage {
+	case StageBegin:
+		if !ack.OK {
+			m.finish(c, p, StateFailed, "begin refused: "+ack.Reason)
+			break
+		}
+		if ack.Offset >= 0 && ack.Offset <= p.Size {
+			if ack.Offset > 0 {
+				otaCounter.WithLabelValues(m.name, "resume").Inc()
+			}
+			p.Offset = ack.Offset
+		}
+		p.stage, p.Attempts = StageChunk, 0
+	case StageChunk:
+		if !ack.OK {
+			// 设备要求重发, 等待超时或下一次调度
+			p.sentAt = time.Time{}
+			break
+		}
+		if ack.Offset > p.Offset {
+			p.Offset, p.Attempts = ack.Offset, 0
+			if p.Offset > p.Size {
+				p.Offset = p.Size
+			}
+		}
+	case StageEnd:
+		if ack.OK {
+			m.finish(c, p, StateSuccess, "")
+		} else {
+			m.finish(c, p, StateFailed, "verify failed: "+ack.Reason)
+		}
+	}
+	p.UpdatedAt = time.Now()
+	if !p.State.Final() && c.State == CampaignRunning && ack.OK {
+		send = m.next(c, p)
+	}
+	m.mu.Unlock()
+
+	if send != nil {
+		send()
+	}
+	return true
+}
+
+// Interceptor 翻译拦截器, 用协议输出的 tos 处理升级应答
+// consume为true时, 升级应答不再转发
+func (m *Manager) Interceptor(consume bool) driver.TranslateInterceptor {
+	return interceptor.Reply(m.Reply, consume)
+}
+
+// Disconnect 设备断开, 正在升级的设备回到等待状态, 重新上线后续传
+func (m *Manager) Disconnect(mark string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.busy[mark]
+	if !ok {
+		return
+	}
+	p := m.campaigns[id].devices[mark]
+	if p.State == StateTransferring || p.State == StateVerifying {
+		p.State, p.UpdatedAt = StateWaiting, time.Now()
+	}
+}
+
+// Run 定时调度: 超时重发, 按并发数启动等待中的在线设备, ctx结束后退出
+func (m *Manager) Run(ctx context.Context) {
+	t := time.NewTicker(m.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			m.schedule(now)
+		}
+	}
+}
+
+func (m *Manager) schedule(now time.Time) {
+	m.mu.Lock()
+	var sends []func()
+	for _, c := range m.campaigns {
+		if c.State != CampaignRunning {
+			continue
+		}
+		for _, mark := range c.order {
+			p := c.devices[mark]
+			if p.State != StateTransferring && p.State != StateVerifying || now.Sub(p.sentAt) < m.ackTimeout {
+				continue
+			}
+			if m.maxAttempts > 0 && p.Attempts >= m.maxAttempts {
+				m.finish(c, p, StateFailed, fmt.Sprintf("no ack after %d attempts at offset %d", p.Attempts, p.Offset))
+				continue
+			}
+			if !m.driver.CheckOnline(mark) {
+				p.State, p.UpdatedAt = StateWaiting, now
+				continue
+			}
+			otaCounter.WithLabelValues(m.name, "retry").Inc()
+			sends = append(sends, m.next(c, p))
+		}
+
+		active := c.active()
+		for _, mark := range c.order {
+			if c.Concurrency > 0 && active >= c.Concurrency {
+				break
+			}
+			p := c.devices[mark]
+			if p.State != StateWaiting || !m.driver.CheckOnline(mark) {
+				continue
+			}
+			// 重新从开始报文握手, 设备在开始应答中返回续传位置
+			p.State, p.stage, p.Attempts, p.Error = StateTransferring, StageBegin, 0, ""
+			if p.StartedAt.IsZero() {
+				p.StartedAt = now
+			}
+			active++
+			sends = append(sends, m.next(c, p))
+		}
+	}
+	m.mu.Unlock()
+	run(sends)
+}
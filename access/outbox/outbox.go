@@ -0,0 +1,355 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+	"github.com/zhuoqingbin/utils/access/interceptor"
+	"github.com/zhuoqingbin/utils/uuid"
+)
+
+var outboxCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_outbox_total",
+	Help: "outbox item count by outbox/result",
+}, []string{"outbox", "result"})
+
+// ErrNoFrames 消息内容不是 []byte 或者 [][]byte
+var ErrNoFrames = errors.New("outbox: msg has no frames")
+
+// ItemOption 消息选项
+type ItemOption func(it *Item)
+
+// WithPriority 优先级, 越大越优先
+func WithPriority(p int) ItemOption {
+	return func(it *Item) { it.Priority = p }
+}
+
+// WithTTL 有效期, 超过有效期还未送达的消息会被丢弃
+func WithTTL(ttl time.Duration) ItemOption {
+	return func(it *Item) { it.ExpireAt = time.Now().Add(ttl) }
+}
+
+// WithSendAt 最早发送时间
+func WithSendAt(t time.Time) ItemOption {
+	return func(it *Item) { it.SendAt = t }
+}
+
+// WithDedupKey 去重key, 同一设备相同key的消息只保留最新一条(如: 同一类配置下发)
+func WithDedupKey(key string) ItemOption {
+	return func(it *Item) { it.DedupKey = key }
+}
+
+// Outbox 设备下行消息队列
+// 设备离线时消息保存在Store中, 设备上线(Flush)或到达发送时间时发送
+// 设置了Correlator时, 消息需要设备应答才算送达, 超时未应答会重发(至少一次)
+//
+//	ob := outbox.New("ykc", tcpDriver, outbox.NewRedisStore("")).SetCorrelator(corr)
+//	go ob.Run(ctx)
+//	ob.Enqueue(driver.NewMsg(mark, raw), outbox.WithTTL(24*time.Hour), outbox.WithDedupKey("tariff"))
+type Outbox struct {
+	name        string
+	driver      driver.Driver
+	store       Store
+	corr        driver.Correlator
+	ackTimeout  time.Duration
+	maxAttempts int
+	batch       int
+	interval    time.Duration
+	log         *logrus.Entry
+
+	locks    sync.Map // mark -> *sync.Mutex
+	mu       sync.Mutex
+	inflight map[string]map[string][]string // mark -> ackKey -> ids, 按发送顺序应答
+}
+
+// New 创建下行消息队列, name用于指标标签
+func New(name string, d driver.Driver, s Store) *Outbox {
+	return &Outbox{
+		name:        name,
+		driver:      d,
+		store:       s,
+		ackTimeout:  30 * time.Second,
+		maxAttempts: 5,
+		batch:       32,
+		interval:    time.Second,
+		log:         logrus.WithField("module", "outbox").WithField("outbox", name),
+		inflight:    make(map[string]map[string][]string),
+	}
+}
+
+// SetCorrelator 设置应答关联, 设置后消息需要设备应答确认
+func (o *Outbox) SetCorrelator(c driver.Correlator) *Outbox {
+	o.corr = c
+	return o
+}
+
+// SetAckTimeout 应答超时时间, 超时后重发, 必须大于0
+func (o *Outbox) SetAckTimeout(t time.Duration) *Outbox {
+	if t <= 0 {
+		panic("outbox: ack timeout must be positive")
+	}
+	o.ackTimeout = t
+	return o
+}
+
+// SetMaxAttempts 最大发送次数, 超过后丢弃, <=0表示不限制
+func (o *Outbox) SetMaxAttempts(n int) *Outbox {
+	o.maxAttempts = n
+	return o
+}
+
+// SetInterval 扫描到期消息的间隔
+func (o *Outbox) SetInterval(t time.Duration) *Outbox {
+	o.interval = t
+	return o
+}
+
+// Enqueue 消息入队, 设备在线且已到发送时间时立即发送
+// msg实现了driver.DelayMsg时, 使用其发送时间
+func (o *Outbox) Enqueue(msg driver.Msg, opts ...ItemOption) (id string, err error) {
+	frames := driver.MsgBytes(msg)
+	if len(frames) == 0 {
+		return "", ErrNoFrames
+	}
+	it := &Item{
+		ID:     uuid.GetID().String(),
+		Mark:   msg.GetMark(),
+		Frames: frames,
+		SendAt: time.Now(),
+	}
+	if dm, ok := msg.(driver.DelayMsg); ok {
+		it.SendAt = dm.GetSendAt()
+	}
+	for _, opt := range opts {
+		opt(it)
+	}
+	if o.corr != nil {
+		if it.AckKey, err = o.corr.RequestKey(msg); err != nil {
+			return "", err
+		}
+	}
+	if err = o.store.Push(it); err != nil {
+		return "", err
+	}
+	outboxCounter.WithLabelValues(o.name, "enqueued").Inc()
+
+	if !it.SendAt.After(time.Now()) && o.driver.CheckOnline(it.Mark) {
+		if err := o.Flush(it.Mark); err != nil {
+			o.log.WithField("mark", it.Mark).Warnf("flush after enqueue: %v", err)
+		}
+	}
+	return it.ID, nil
+}
+
+// Flush 发送设备所有到期的消息, 设备重新连接时调用
+func (o *Outbox) Flush(mark string) error {
+	l, _ := o.locks.LoadOrStore(mark, &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	defer l.(*sync.Mutex).Unlock()
+
+	for {
+		now := time.Now()
+		items, err := o.store.Due(mark, now, o.batch)
+		if err != nil || len(items) == 0 {
+			return err
+		}
+		for _, it := range items {
+			if err := o.deliver(it, now); err != nil {
+				return err
+			}
+		}
+		if len(items) < o.batch {
+			return nil
+		}
+	}
+}
+
+func (o *Outbox) deliver(it *Item, now time.Time) error {
+	switch {
+	case it.Expired(now):
+		outboxCounter.WithLabelValues(o.name, "expired").Inc()
+		o.forget(it)
+		return o.store.Remove(it.Mark, it.ID)
+	case o.maxAttempts > 0 && it.Attempts >= o.maxAttempts:
+		outboxCounter.WithLabelValues(o.name, "dropped").Inc()
+		o.log.WithField("mark", it.Mark).Warnf("drop item[%s] after %d attempts", it.ID, it.Attempts)
+		o.forget(it)
+		return o.store.Remove(it.Mark, it.ID)
+	}
+
+	var raw interface{} = it.Frames
+	if len(it.Frames) == 1 {
+		raw = it.Frames[0]
+	}
+	if err := o.driver.Send(driver.NewMsg(it.Mark, raw, it)); err != nil {
+		return err
+	}
+	it.Attempts++
+	outboxCounter.WithLabelValues(o.name, "sent").Inc()
+
+	if it.AckKey == "" {
+		return o.store.Remove(it.Mark, it.ID)
+	}
+	it.SendAt = now.Add(o.ackTimeout)
+	o.mu.Lock()
+	if _, ok := o.inflight[it.Mark]; !ok {
+		o.inflight[it.Mark] = make(map[string][]string)
+	}
+	// 同一key可能有多条消息等待应答(如 CmdPairCorrelator 同一命令连续下发), 重发时保持原来的顺序
+	ids := o.inflight[it.Mark][it.AckKey]
+	if indexOf(ids, it.ID) < 0 {
+		o.inflight[it.Mark][it.AckKey] = append(ids, it.ID)
+	}
+	o.mu.Unlock()
+	return o.store.Update(it)
+}
+
+func indexOf(ids []string, id string) int {
+	for i, v := range ids {
+		if v == id {
+			return i
+		}
+	}
+	return -1
+}
+
+func (o *Outbox) forget(it *Item) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for key, ids := range o.inflight[it.Mark] {
+		if it.AckKey != "" && key != it.AckKey {
+			continue
+		}
+		i := indexOf(ids, it.ID)
+		if i < 0 {
+			continue
+		}
+		ids = append(ids[:i:i], ids[i+1:]...)
+		if len(ids) == 0 {
+			delete(o.inflight[it.Mark], key)
+		} else {
+			o.inflight[it.Mark][key] = ids
+		}
+		break
+	}
+	if len(o.inflight[it.Mark]) == 0 {
+		delete(o.inflight, it.Mark)
+	}
+}
+
+// Ack 确认消息已送达
+func (o *Outbox) Ack(mark, id string) error {
+	o.forget(&Item{Mark: mark, ID: id})
+	return o.acked(mark, id)
+}
+
+func (o *Outbox) acked(mark, id string) error {
+	outboxCounter.WithLabelValues(o.name, "acked").Inc()
+	return o.store.Remove(mark, id)
+}
+
+// Reply 投递设备上行报文, 是已发送消息的应答时确认送达并返回true
+func (o *Outbox) Reply(msg driver.Msg) bool {
+	if o.corr == nil {
+		return false
+	}
+	key, ok := o.corr.ReplyKey(msg)
+	if !ok {
+		return false
+	}
+	mark := msg.GetMark()
+	o.mu.Lock()
+	ids := o.inflight[mark][key]
+	if len(ids) == 0 {
+		o.mu.Unlock()
+		return false
+	}
+	// 应答和请求按顺序对应, 确认最早发送的一条
+	id := ids[0]
+	if len(ids) == 1 {
+		delete(o.inflight[mark], key)
+		if len(o.inflight[mark]) == 0 {
+			delete(o.inflight, mark)
+		}
+	} else {
+		o.inflight[mark][key] = ids[1:]
+	}
+	o.mu.Unlock()
+	if err := o.acked(mark, id); err != nil {
+		o.log.WithField("mark", mark).Errorf("ack item[%s]: %v", id, err)
+	}
+	return true
+}
+
+// Interceptor 翻译拦截器, 用协议输出的 tos 确认消息送达
+// consume为true时, 确认用的应答不再转发
+func (o *Outbox) Interceptor(consume bool) driver.TranslateInterceptor {
+	return interceptor.Reply(o.Reply, consume)
+}
+
+// Disconnect 设备断开, 清理等待应答的记录
+// 已发送未应答的消息重发时间改为当前时间, 设备重新连接 Flush 时立即重发
+func (o *Outbox) Disconnect(mark string) {
+	o.mu.Lock()
+	delete(o.inflight, mark)
+	o.mu.Unlock()
+
+	l, _ := o.locks.LoadOrStore(mark, &sync.Mutex{})
+	l.(*sync.Mutex).Lock()
+	defer l.(*sync.Mutex).Unlock()
+
+	// 等待应答的消息重发时间不超过 now+ackTimeout
+	now := time.Now()
+	items, err := o.store.Due(mark, now.Add(o.ackTimeout), 0)
+	if err != nil {
+		o.log.WithField("mark", mark).Warnf("load inflight: %v", err)
+		return
+	}
+	for _, it := range items {
+		if it.AckKey == "" || it.Attempts == 0 || !it.SendAt.After(now) {
+			continue
+		}
+		it.SendAt = now
+		if err := o.store.Update(it); err != nil {
+			o.log.WithField("mark", mark).Warnf("reset item[%s]: %v", it.ID, err)
+		}
+	}
+}
+
+// Len 设备待发送消息数
+func (o *Outbox) Len(mark string) (int, error) {
+	return o.store.Len(mark)
+}
+
+// Run 定时扫描在线设备的到期消息(延时消息/应答超时重发), ctx结束后退出
+func (o *Outbox) Run(ctx context.Context) {
+	t := time.NewTicker(o.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		marks, err := o.store.Marks()
+		if err != nil {
+			o.log.Errorf("load marks: %v", err)
+			continue
+		}
+		for _, mark := range marks {
+			if !o.driver.CheckOnline(mark) {
+				continue
+			}
+			if err := o.Flush(mark); err != nil {
+				o.log.WithField("mark", mark).Warnf("flush: %v", err)
+			}
+		}
+	}
+}
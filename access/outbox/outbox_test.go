@@ -0,0 +1,212 @@
+package outbox
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// testDriver 记录发送的报文, online 为在线设备
+type testDriver struct {
+	mu     sync.Mutex
+	online map[string]bool
+	sent   [][]byte
+}
+
+func newTestDriver(marks ...string) *testDriver {
+	d := &testDriver{online: make(map[string]bool)}
+	for _, m := range marks {
+		d.online[m] = true
+	}
+	return d
+}
+
+func (d *testDriver) Run() error                   { return nil }
+func (d *testDriver) Stop()                        {}
+func (d *testDriver) Disconnector(mark, r string)  {}
+func (d *testDriver) Debug(t string)               {}
+func (d *testDriver) CheckOnline(mark string) bool { return d.online[mark] }
+func (d *testDriver) TraficSize(mark string) (int32, int32, error) {
+	return 0, 0, nil
+}
+
+func (d *testDriver) Send(msg driver.Msg) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.sent = append(d.sent, driver.MsgBytes(msg)...)
+	return nil
+}
+
+func (d *testDriver) frames() [][]byte {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([][]byte(nil), d.sent...)
+}
+
+// testCorr 第一个字节为命令码, 0x33 -> 0x34
+func testCorr() driver.Correlator {
+	return &driver.CmdPairCorrelator{
+		Cmd: func(msg driver.Msg) (string, bool) {
+			bs := driver.MsgBytes(msg)
+			if len(bs) == 0 || len(bs[0]) == 0 {
+				return "", false
+			}
+			return fmt.Sprintf("%02x", bs[0][0]), true
+		},
+		Pairs: map[string]string{"33": "34"},
+	}
+}
+
+func mustLen(t *testing.T, o *Outbox, mark string, want int) {
+	t.Helper()
+	n, err := o.Len(mark)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("len = %d, want %d", n, want)
+	}
+}
+
+func TestReplyFIFOSameAckKey(t *testing.T) {
+	d := newTestDriver("m1")
+	o := New("test", d, NewMemoryStore()).SetCorrelator(testCorr())
+
+	id1, err := o.Enqueue(driver.NewMsg("m1", []byte{0x33, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id2, err := o.Enqueue(driver.NewMsg("m1", []byte{0x33, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.frames()) != 2 {
+		t.Fatalf("sent %d frames, want 2", len(d.frames()))
+	}
+	mustLen(t, o, "m1", 2)
+
+	if !o.Reply(driver.NewMsg("m1", []byte{0x34})) {
+		t.Fatal("first reply not matched")
+	}
+	items, _ := o.store.Due("m1", time.Now().Add(time.Hour), 0)
+	if len(items) != 1 || items[0].ID != id2 {
+		t.Fatalf("after first reply left %v, want %s (acked %s)", items, id2, id1)
+	}
+	if !o.Reply(driver.NewMsg("m1", []byte{0x34})) {
+		t.Fatal("second reply not matched")
+	}
+	mustLen(t, o, "m1", 0)
+	if o.Reply(driver.NewMsg("m1", []byte{0x34})) {
+		t.Fatal("reply without inflight matched")
+	}
+}
+
+func TestResendKeepsSingleInflight(t *testing.T) {
+	d := newTestDriver("m1")
+	o := New("test", d, NewMemoryStore()).SetCorrelator(testCorr()).SetAckTimeout(time.Millisecond)
+
+	if _, err := o.Enqueue(driver.NewMsg("m1", []byte{0x33})); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(5 * time.Millisecond)
+	if err := o.Flush("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(d.frames()); n != 2 {
+		t.Fatalf("sent %d frames, want 2", n)
+	}
+	if n := len(o.inflight["m1"]["34"]); n != 1 {
+		t.Fatalf("inflight %d ids, want 1", n)
+	}
+	o.Reply(driver.NewMsg("m1", []byte{0x34}))
+	mustLen(t, o, "m1", 0)
+	if len(o.inflight) != 0 {
+		t.Fatalf("inflight not cleared: %v", o.inflight)
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	cases := []struct {
+		name     string
+		online   bool
+		corr     bool
+		item     func(it *Item)
+		wantSent int
+		wantLen  int
+	}{
+		{name: "offline stays queued", online: false, wantSent: 0, wantLen: 1},
+		{name: "no ack removed after send", online: true, wantSent: 1, wantLen: 0},
+		{name: "ack waits reply", online: true, corr: true, wantSent: 1, wantLen: 1},
+		{name: "expired dropped", online: true, item: func(it *Item) { it.ExpireAt = time.Now().Add(-time.Second) }, wantSent: 0, wantLen: 0},
+		{name: "max attempts dropped", online: true, item: func(it *Item) { it.Attempts = 5 }, wantSent: 0, wantLen: 0},
+		{name: "not due", online: true, item: func(it *Item) { it.SendAt = time.Now().Add(time.Hour) }, wantSent: 0, wantLen: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := newTestDriver()
+			o := New("test", d, NewMemoryStore())
+			if c.corr {
+				o.SetCorrelator(testCorr())
+			}
+			it := &Item{ID: "1", Mark: "m1", Frames: [][]byte{{0x33}}, SendAt: time.Now()}
+			if c.corr {
+				it.AckKey = "34"
+			}
+			if c.item != nil {
+				c.item(it)
+			}
+			if err := o.store.Push(it); err != nil {
+				t.Fatal(err)
+			}
+			d.online["m1"] = c.online
+			if c.online {
+				if err := o.Flush("m1"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if n := len(d.frames()); n != c.wantSent {
+				t.Fatalf("sent %d, want %d", n, c.wantSent)
+			}
+			mustLen(t, o, "m1", c.wantLen)
+		})
+	}
+}
+
+func TestEnqueueDedup(t *testing.T) {
+	d := newTestDriver()
+	o := New("test", d, NewMemoryStore())
+	if _, err := o.Enqueue(driver.NewMsg("m1", []byte{1}), WithDedupKey("tariff")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := o.Enqueue(driver.NewMsg("m1", []byte{2}), WithDedupKey("tariff")); err != nil {
+		t.Fatal(err)
+	}
+	mustLen(t, o, "m1", 1)
+
+	d.online["m1"] = true
+	if err := o.Flush("m1"); err != nil {
+		t.Fatal(err)
+	}
+	if f := d.frames(); len(f) != 1 || f[0][0] != 2 {
+		t.Fatalf("sent %v, want latest frame", f)
+	}
+}
+
+func TestEnqueueNoFrames(t *testing.T) {
+	o := New("test", newTestDriver(), NewMemoryStore())
+	if _, err := o.Enqueue(driver.NewMsg("m1", "text")); err != ErrNoFrames {
+		t.Fatalf("err = %v, want ErrNoFrames", err)
+	}
+}
+
+func TestSetAckTimeoutRejectsZero(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("SetAckTimeout(0) did not panic")
+		}
+	}()
+	New("test", newTestDriver(), NewMemoryStore()).SetAckTimeout(0)
+}
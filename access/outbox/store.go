@@ -0,0 +1,55 @@
+package outbox
+
+import (
+	"sort"
+	"time"
+)
+
+// Item 待发送消息
+type Item struct {
+	ID       string    `json:"id"`
+	Mark     string    `json:"mark"`
+	Frames   [][]byte  `json:"frames"`    // 打包后的报文
+	SendAt   time.Time `json:"send_at"`   // 最早发送时间, 已发送待确认时为重发时间
+	ExpireAt time.Time `json:"expire_at"` // 过期时间, 零值表示不过期
+	Priority int       `json:"priority"`  // 越大越优先
+	DedupKey string    `json:"dedup_key"` // 相同key的消息只保留最新一条
+	AckKey   string    `json:"ack_key"`   // 设备应答的关联键, 为空表示发送成功即完成
+	Attempts int       `json:"attempts"`  // 已发送次数
+}
+
+// Expired 是否已经过期
+func (it *Item) Expired(now time.Time) bool {
+	return !it.ExpireAt.IsZero() && now.After(it.ExpireAt)
+}
+
+// Store 消息持久化
+type Store interface {
+	// Push 保存消息, DedupKey相同的旧消息会被替换
+	Push(it *Item) error
+
+	// Due 获取设备到期(SendAt<=now)的消息, 按优先级从高到低, 同优先级按SendAt排序
+	Due(mark string, now time.Time, limit int) ([]*Item, error)
+
+	// Update 更新消息(发送次数/重发时间)
+	Update(it *Item) error
+
+	// Remove 删除消息
+	Remove(mark, id string) error
+
+	// Marks 有待发送消息的设备
+	Marks() ([]string, error)
+
+	// Len 设备待发送消息数
+	Len(mark string) (int, error)
+}
+
+// sortItems 按优先级从高到低, 同优先级按SendAt排序
+func sortItems(items []*Item) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if items[i].Priority != items[j].Priority {
+			return items[i].Priority > items[j].Priority
+		}
+		return items[i].SendAt.Before(items[j].SendAt)
+	})
+}
@@ -0,0 +1,118 @@
+package outbox
+
+import (
+	"sync"
+	"time"
+)
+
+type memQueue struct {
+	items map[string]*Item  // id -> item
+	dedup map[string]string // dedupKey -> id
+}
+
+// MemoryStore 内存存储, 进程重启后消息丢失, 用于测试和单机部署
+type MemoryStore struct {
+	mu     sync.Mutex
+	queues map[string]*memQueue
+}
+
+// NewMemoryStore 创建内存存储
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{queues: make(map[string]*memQueue)}
+}
+
+// Push 保存消息
+func (s *MemoryStore) Push(it *Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[it.Mark]
+	if !ok {
+		q = &memQueue{items: make(map[string]*Item), dedup: make(map[string]string)}
+		s.queues[it.Mark] = q
+	}
+	if it.DedupKey != "" {
+		if old, ok := q.dedup[it.DedupKey]; ok {
+			delete(q.items, old)
+		}
+		q.dedup[it.DedupKey] = it.ID
+	}
+	cp := *it
+	q.items[it.ID] = &cp
+	return nil
+}
+
+// Due 获取到期消息
+func (s *MemoryStore) Due(mark string, now time.Time, limit int) ([]*Item, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[mark]
+	if !ok {
+		return nil, nil
+	}
+	ret := make([]*Item, 0)
+	for _, it := range q.items {
+		if !it.SendAt.After(now) {
+			cp := *it
+			ret = append(ret, &cp)
+		}
+	}
+	sortItems(ret)
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+// Update 更新消息
+func (s *MemoryStore) Update(it *Item) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[it.Mark]; ok {
+		if _, ok := q.items[it.ID]; ok {
+			cp := *it
+			q.items[it.ID] = &cp
+		}
+	}
+	return nil
+}
+
+// Remove 删除消息
+func (s *MemoryStore) Remove(mark, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	q, ok := s.queues[mark]
+	if !ok {
+		return nil
+	}
+	if it, ok := q.items[id]; ok {
+		if it.DedupKey != "" && q.dedup[it.DedupKey] == id {
+			delete(q.dedup, it.DedupKey)
+		}
+		delete(q.items, id)
+	}
+	if len(q.items) == 0 {
+		delete(s.queues, mark)
+	}
+	return nil
+}
+
+// Marks 有待发送消息的设备
+func (s *MemoryStore) Marks() ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]string, 0, len(s.queues))
+	for mark := range s.queues {
+		ret = append(ret, mark)
+	}
+	return ret, nil
+}
+
+// Len 设备待发送消息数
+func (s *MemoryStore) Len(mark string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if q, ok := s.queues[mark]; ok {
+		return len(q.items), nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,162 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// KEYS: zset items dedup marks
+// ARGV: id score item dedupKey mark
+var pushScript = redis.NewScript(4, `
+if ARGV[4] ~= '' then
+	local old = redis.call('HGET', KEYS[3], ARGV[4])
+	if old then
+		redis.call('ZREM', KEYS[1], old)
+		redis.call('HDEL', KEYS[2], old)
+	end
+	redis.call('HSET', KEYS[3], ARGV[4], ARGV[1])
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+redis.call('SADD', KEYS[4], ARGV[5])
+return 1
+`)
+
+// KEYS: zset items dedup marks
+// ARGV: id mark
+var removeScript = redis.NewScript(4, `
+local item = redis.call('HGET', KEYS[2], ARGV[1])
+if item then
+	local key = cjson.decode(item)['dedup_key']
+	if key and key ~= '' and redis.call('HGET', KEYS[3], key) == ARGV[1] then
+		redis.call('HDEL', KEYS[3], key)
+	end
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+if redis.call('ZCARD', KEYS[1]) == 0 then
+	redis.call('SREM', KEYS[4], ARGV[2])
+end
+return 1
+`)
+
+// KEYS: zset items
+// ARGV: id score item
+var updateScript = redis.NewScript(2, `
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+	redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+end
+return 1
+`)
+
+// RedisStore redis存储, 使用 redigo 连接池
+//
+//	{prefix}:marks            set   有消息的设备
+//	{prefix}:{mark}:q         zset  id -> SendAt(毫秒)
+//	{prefix}:{mark}:items     hash  id -> item json
+//	{prefix}:{mark}:dedup     hash  dedupKey -> id
+type RedisStore struct {
+	prefix string
+}
+
+// NewRedisStore 创建redis存储, prefix为空时使用 access:outbox
+func NewRedisStore(prefix string) *RedisStore {
+	if prefix == "" {
+		prefix = "access:outbox"
+	}
+	return &RedisStore{prefix: prefix}
+}
+
+func (s *RedisStore) keys(mark string) []interface{} {
+	return []interface{}{
+		fmt.Sprintf("%s:%s:q", s.prefix, mark),
+		fmt.Sprintf("%s:%s:items", s.prefix, mark),
+		fmt.Sprintf("%s:%s:dedup", s.prefix, mark),
+		s.prefix + ":marks",
+	}
+}
+
+// Push 保存消息
+func (s *RedisStore) Push(it *Item) error {
+	b, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	args := append(s.keys(it.Mark), it.ID, it.SendAt.UnixNano()/int64(time.Millisecond), b, it.DedupKey, it.Mark)
+	_, err = pushScript.Do(rd, args...)
+	return err
+}
+
+// Due 获取到期消息
+func (s *RedisStore) Due(mark string, now time.Time, limit int) ([]*Item, error) {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+
+	// zset按SendAt排序, 取全部到期消息按优先级排序后再截取, 否则LIMIT会漏掉高优先级的消息
+	keys := s.keys(mark)
+	ids, err := redis.Strings(rd.Do("ZRANGEBYSCORE", keys[0], "-inf", now.UnixNano()/int64(time.Millisecond)))
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+
+	vals, err := redis.ByteSlices(rd.Do("HMGET", redis.Args{}.Add(keys[1]).AddFlat(ids)...))
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]*Item, 0, len(vals))
+	for idx, v := range vals {
+		if v == nil { // 数据不一致, 清理掉
+			rd.Do("ZREM", keys[0], ids[idx])
+			continue
+		}
+		it := &Item{}
+		if err := json.Unmarshal(v, it); err != nil {
+			return nil, fmt.Errorf("outbox: decode item[%s]: %w", ids[idx], err)
+		}
+		ret = append(ret, it)
+	}
+	sortItems(ret)
+	if limit > 0 && len(ret) > limit {
+		ret = ret[:limit]
+	}
+	return ret, nil
+}
+
+// Update 更新消息
+func (s *RedisStore) Update(it *Item) error {
+	b, err := json.Marshal(it)
+	if err != nil {
+		return err
+	}
+	rd := redigo.GetRedis()
+	defer rd.Close()
+
+	keys := s.keys(it.Mark)
+	_, err = updateScript.Do(rd, keys[0], keys[1], it.ID, it.SendAt.UnixNano()/int64(time.Millisecond), b)
+	return err
+}
+
+// Remove 删除消息
+func (s *RedisStore) Remove(mark, id string) error {
+	rd := redigo.GetRedis()
+	defer rd.Close()
+	_, err := removeScript.Do(rd, append(s.keys(mark), id, mark)...)
+	return err
+}
+
+// Marks 有待发送消息的设备
+func (s *RedisStore) Marks() ([]string, error) {
+	return redis.Strings(redigo.Do("SMEMBERS", s.prefix+":marks"))
+}
+
+// Len 设备待发送消息数
+func (s *RedisStore) Len(mark string) (int, error) {
+	return redis.Int(redigo.Do("ZCARD", s.keys(mark)[0]))
+}
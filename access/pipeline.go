@@ -0,0 +1,318 @@
+package access
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var (
+	// ErrNoListen 未设置接入端驱动
+	ErrNoListen = errors.New("pipeline: listen driver not set")
+
+	// ErrNoProtocol 未设置协议
+	ErrNoProtocol = errors.New("pipeline: protocol not set")
+
+	// ErrNotInject 驱动没有实现driver.Inject, 无法注入
+	ErrNotInject = errors.New("pipeline: listen driver not implement driver.Inject")
+
+	// ErrRunning 已经在运行
+	ErrRunning = errors.New("pipeline: already running")
+
+	// ErrSharedListen 多条链路使用同一个接入端驱动, 后注入的协议会覆盖前面的
+	ErrSharedListen = errors.New("pipeline: listen driver shared by pipelines")
+)
+
+// Pipeline 接入链路: 接入端驱动 -> 协议 -> 转发端驱动
+// 用类型化的构建方式替代 Inject(name, p) 的字符串注入, 启动前统一校验
+//
+//	p := access.NewPipeline("ykc").Listen(tcp).Protocol(ykc).Forward(grpcDriver)
+//	if err := p.Run(); err != nil { ... }
+//	defer p.Stop()
+type Pipeline struct {
+	name     string
+	listen   driver.Driver
+	protocol driver.Protocol
+	forward  driver.Driver
+	routes   map[string]driver.Driver // 协议额外的转发驱动(ProtoBase.GetDrivers)
+	ints     []driver.TranslateInterceptor
+
+	running bool
+	started []driver.Driver // 已经启动的驱动, 用于失败回滚和停止
+}
+
+// NewPipeline 创建一个接入链路, name为驱动名称(Ctx.DriverName)
+func NewPipeline(name string) *Pipeline {
+	return &Pipeline{
+		name:   name,
+		routes: make(map[string]driver.Driver),
+	}
+}
+
+// Name 链路名称
+func (p *Pipeline) Name() string {
+	return p.name
+}
+
+// Listen 设置接入端驱动(tcp/ws/mqtt...)
+func (p *Pipeline) Listen(d driver.Driver) *Pipeline {
+	p.listen = d
+	return p
+}
+
+// Protocol 设置协议翻译对象
+func (p *Pipeline) Protocol(proto driver.Protocol) *Pipeline {
+	p.protocol = proto
+	return p
+}
+
+// Forward 设置转发端驱动
+func (p *Pipeline) Forward(d driver.Driver) *Pipeline {
+	p.forward = d
+	return p
+}
+
+// Route 为协议增加一个命名的转发驱动
+// 用于协议中无法映射命令的转发, 协议通过 ProtoBase.GetDrivers()[name] 获取
+func (p *Pipeline) Route(name string, d driver.Driver) *Pipeline {
+	p.routes[name] = d
+	return p
+}
+
+// Intercept 给协议翻译加上拦截器, 按传入顺序由外到内执行
+func (p *Pipeline) Intercept(ints ...driver.TranslateInterceptor) *Pipeline {
+	p.ints = append(p.ints, ints...)
+	return p
+}
+
+// GetListen 接入端驱动
+func (p *Pipeline) GetListen() driver.Driver {
+	return p.listen
+}
+
+// GetProtocol 协议翻译对象
+func (p *Pipeline) GetProtocol() driver.Protocol {
+	return p.protocol
+}
+
+// GetForward 转发端驱动
+func (p *Pipeline) GetForward() driver.Driver {
+	return p.forward
+}
+
+// Validate 校验链路配置
+func (p *Pipeline) Validate() error {
+	if p.listen == nil {
+		return fmt.Errorf("%w: [%s]", ErrNoListen, p.name)
+	}
+	if p.protocol == nil {
+		return fmt.Errorf("%w: [%s]", ErrNoProtocol, p.name)
+	}
+	if _, ok := p.listen.(driver.Inject); !ok {
+		return fmt.Errorf("%w: [%s] %T", ErrNotInject, p.name, p.listen)
+	}
+	for name, d := range p.routes {
+		if d == nil {
+			return fmt.Errorf("pipeline: [%s] route [%s] driver is nil", p.name, name)
+		}
+	}
+	return nil
+}
+
+// wire 注入驱动名/协议/转发端
+func (p *Pipeline) wire() {
+	if in, ok := driver.As[driver.Inject](p.protocol); ok {
+		if p.forward != nil {
+			in.Inject(driver.InjectPointDriver, p.forward)
+		}
+		for name, d := range p.routes {
+			in.Inject(name, d)
+		}
+	}
+
+	in := p.listen.(driver.Inject)
+	in.Inject(driver.InjectDriverName, p.name).Inject(driver.InjectProtocol, driver.WithInterceptors(p.protocol, p.ints...))
+	if p.forward != nil {
+		in.Inject(driver.InjectPointDriver, p.forward)
+	}
+}
+
+// drivers 按启动顺序返回链路上的驱动: 转发端先启动, 额外的转发驱动按名称排序, 接入端最后启动
+func (p *Pipeline) drivers() (ds []driver.Driver) {
+	if p.forward != nil {
+		ds = append(ds, p.forward)
+	}
+	names := make([]string, 0, len(p.routes))
+	for name := range p.routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if d := p.routes[name]; !containsDriver(ds, d) {
+			ds = append(ds, d)
+		}
+	}
+	return append(ds, p.listen)
+}
+
+// Run 校验并注入后, 依次启动转发端和接入端驱动
+// 任何一个驱动启动失败, 已启动的驱动会被停止
+func (p *Pipeline) Run() (err error) {
+	if p.running {
+		return ErrRunning
+	}
+	if err = p.Validate(); err != nil {
+		return err
+	}
+	p.wire()
+
+	if err = runDrivers(p.drivers(), &p.started); err != nil {
+		return fmt.Errorf("pipeline: [%s] %w", p.name, err)
+	}
+	p.running = true
+	return nil
+}
+
+// Stop 按启动的逆序停止驱动, 先停接入端, 再停转发端
+func (p *Pipeline) Stop() {
+	stopDrivers(p.started)
+	p.started = nil
+	p.running = false
+}
+
+// Shutdown 按启动的逆序优雅关闭驱动, 接入端的下行报文清空后再关闭转发端
+// 驱动没有实现 driver.Shutdowner 时调用 Stop, 返回第一个错误
+func (p *Pipeline) Shutdown(ctx context.Context) error {
+	err := shutdownDrivers(ctx, p.started)
+	p.started = nil
+	p.running = false
+	return err
+}
+
+// Group 多条链路统一管理生命周期
+// 多条链路共享的转发端驱动只会启动/停止一次
+// 接入端驱动注入了链路的驱动名和协议, 不能被多条链路共享
+type Group struct {
+	pipelines []*Pipeline
+	started   []driver.Driver
+	running   bool
+}
+
+// NewGroup 创建链路组
+func NewGroup(ps ...*Pipeline) *Group {
+	return &Group{pipelines: ps}
+}
+
+// Add 添加链路
+func (g *Group) Add(ps ...*Pipeline) *Group {
+	g.pipelines = append(g.pipelines, ps...)
+	return g
+}
+
+// Pipelines 组内所有链路
+func (g *Group) Pipelines() []*Pipeline {
+	return g.pipelines
+}
+
+// Validate 校验组内所有链路, 链路名称不能重复, 接入端驱动不能共享
+func (g *Group) Validate() error {
+	names := make(map[string]struct{}, len(g.pipelines))
+	listens := make(map[driver.Driver]string, len(g.pipelines))
+	for _, p := range g.pipelines {
+		if _, ok := names[p.name]; ok {
+			return fmt.Errorf("pipeline: duplicate name [%s]", p.name)
+		}
+		names[p.name] = struct{}{}
+		if err := p.Validate(); err != nil {
+			return err
+		}
+		if other, ok := listens[p.listen]; ok {
+			return fmt.Errorf("%w: [%s] and [%s]", ErrSharedListen, other, p.name)
+		}
+		listens[p.listen] = p.name
+	}
+	return nil
+}
+
+// Run 校验所有链路后统一启动
+// 所有转发端驱动先于接入端驱动启动, 避免接入后无处转发
+func (g *Group) Run() (err error) {
+	if g.running {
+		return ErrRunning
+	}
+	if err = g.Validate(); err != nil {
+		return err
+	}
+
+	var forwards, listens []driver.Driver
+	for _, p := range g.pipelines {
+		p.wire()
+		ds := p.drivers()
+		for _, d := range ds[:len(ds)-1] {
+			if !containsDriver(forwards, d) {
+				forwards = append(forwards, d)
+			}
+		}
+		listens = append(listens, p.listen)
+	}
+
+	if err = runDrivers(append(forwards, listens...), &g.started); err != nil {
+		return err
+	}
+	g.running = true
+	return nil
+}
+
+// Stop 按启动的逆序停止所有驱动
+func (g *Group) Stop() {
+	stopDrivers(g.started)
+	g.started = nil
+	g.running = false
+}
+
+// Shutdown 按启动的逆序优雅关闭所有驱动
+func (g *Group) Shutdown(ctx context.Context) error {
+	err := shutdownDrivers(ctx, g.started)
+	g.started = nil
+	g.running = false
+	return err
+}
+
+func runDrivers(ds []driver.Driver, started *[]driver.Driver) error {
+	for _, d := range ds {
+		if err := d.Run(); err != nil {
+			stopDrivers(*started)
+			*started = nil
+			return fmt.Errorf("run driver %T: %w", d, err)
+		}
+		*started = append(*started, d)
+	}
+	return nil
+}
+
+func stopDrivers(ds []driver.Driver) {
+	for i := len(ds) - 1; i >= 0; i-- {
+		ds[i].Stop()
+	}
+}
+
+func shutdownDrivers(ctx context.Context, ds []driver.Driver) (err error) {
+	for i := len(ds) - 1; i >= 0; i-- {
+		if e := driver.Shutdown(ctx, ds[i]); e != nil && err == nil {
+			err = fmt.Errorf("shutdown driver %T: %w", ds[i], e)
+		}
+	}
+	return
+}
+
+func containsDriver(ds []driver.Driver, d driver.Driver) bool {
+	for _, v := range ds {
+		if v == d {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,16 @@
+// Package protocoltest 为 driver.Protocol 实现提供通用的一致性测试
+//
+// 在协议自己的 _test.go 中使用:
+//
+//	func TestYKC(t *testing.T) {
+//		fs, _ := protocoltest.LoadFixtures("testdata/ykc.json")
+//		protocoltest.Run(t, ykc.New(), fs...)
+//		protocoltest.RoundTrip(t, ykc.Messages()...)
+//		protocoltest.Fuzz(t, ykc.New(), protocoltest.Seeds(fs), 10000)
+//	}
+//
+//	func FuzzYKC(f *testing.F) {
+//		f.Add(protocoltest.MustHex("68 0d 00 01 00 03 ..."))
+//		f.Fuzz(protocoltest.FuzzTarget(ykc.New()))
+//	}
+package protocoltest
@@ -0,0 +1,190 @@
+package protocoltest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"testing"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// ErrPanic 协议翻译时panic
+var ErrPanic = errors.New("protocoltest: protocol panic")
+
+// 期望的错误, Fixture.Err 取值
+const (
+	ErrNone   = ""       // 翻译成功
+	ErrIgnore = "ignore" // driver.ErrIgnore
+	ErrKick   = "kick"   // driver.ErrKick
+	ErrBlock  = "block"  // driver.ErrBlock
+	ErrAny    = "any"    // 任意错误
+)
+
+// Fixture 一帧报文的期望翻译结果, 报文都是十六进制字符串
+//
+//	// testdata/ykc.json
+//	[
+//	  {"name": "login", "mark": "32010200000001", "in": "68 22 00 00 00 01 ...",
+//	   "rets": ["68 0c 00 00 00 02 ..."], "tos": 1},
+//	  {"name": "bad crc", "in": "68 22 00 00 00 01 ... ff ff", "err": "ignore"}
+//	]
+type Fixture struct {
+	Name string   `json:"name"`
+	Mark string   `json:"mark"`
+	In   string   `json:"in"`
+	Rets []string `json:"rets"` // 期望的应答报文, 按顺序比较
+	Sent []string `json:"sent"` // 期望通过转发端驱动Send的报文, 为空时不检查
+	Tos  *int     `json:"tos"`  // 期望的转发消息数, 为空时不检查
+	Err  string   `json:"err"`  // 期望的错误, 见 ErrNone 等常量
+
+	// ToBytes 期望的转发报文, 转发消息为字节时使用, 为空时不检查
+	ToBytes []string `json:"to_bytes"`
+
+	// Setup 翻译前调用, 用于准备会话等上下文
+	Setup func(acctx *driver.Ctx) `json:"-"`
+	// Check 自定义检查, 用于转发消息不是字节的协议
+	Check func(t testing.TB, res *Result) `json:"-"`
+}
+
+// LoadFixtures 读取json格式的报文用例文件
+func LoadFixtures(path string) ([]*Fixture, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fs []*Fixture
+	if err = json.Unmarshal(b, &fs); err != nil {
+		return nil, fmt.Errorf("protocoltest: parse %s: %w", path, err)
+	}
+	for i, f := range fs {
+		if f.Name == "" {
+			f.Name = fmt.Sprintf("%s#%d", path, i)
+		}
+	}
+	return fs, nil
+}
+
+// Seeds 用例中的上行报文, 用作 Fuzz 的种子
+func Seeds(fs []*Fixture) (ret [][]byte) {
+	for _, f := range fs {
+		if b, err := ParseHex(f.In); err == nil {
+			ret = append(ret, b)
+		}
+	}
+	return
+}
+
+// Run 每个用例作为一个子测试运行, 检查错误/应答/转发/下发报文
+func Run(t *testing.T, p driver.Protocol, fs ...*Fixture) {
+	t.Helper()
+	h := New(p)
+	for _, f := range fs {
+		f := f
+		t.Run(f.Name, func(t *testing.T) {
+			h.Check(t, f)
+		})
+	}
+}
+
+// Check 翻译一个用例并检查结果
+func (h *Harness) Check(t testing.TB, f *Fixture) *Result {
+	t.Helper()
+	in, err := ParseHex(f.In)
+	if err != nil {
+		t.Fatalf("invalid in hex: %v", err)
+	}
+	res := h.Translate(f.Mark, in, f.Setup)
+
+	if !matchErr(f.Err, res.Err) {
+		t.Errorf("in[%x] err = %v, want %s", in, res.Err, errName(f.Err))
+	}
+	if errors.Is(res.Err, ErrPanic) {
+		return res
+	}
+	if f.Err == ErrNone || len(f.Rets) > 0 {
+		compareFrames(t, "rets", f.Rets, res.RetBytes())
+	}
+	if len(f.Sent) > 0 {
+		compareFrames(t, "sent", f.Sent, res.Sent)
+	}
+	if len(f.ToBytes) > 0 {
+		compareFrames(t, "tos", f.ToBytes, res.ToBytes())
+	}
+	if f.Tos != nil && len(res.Tos) != *f.Tos {
+		t.Errorf("tos = %d, want %d", len(res.Tos), *f.Tos)
+	}
+	if f.Check != nil {
+		f.Check(t, res)
+	}
+	return res
+}
+
+// ExpectIgnore 检查报文被协议忽略: 返回 driver.ErrIgnore, 没有转发消息
+func ExpectIgnore(t testing.TB, p driver.Protocol, mark string, frame []byte) *Result {
+	t.Helper()
+	res := New(p).Translate(mark, frame, nil)
+	if !errors.Is(res.Err, driver.ErrIgnore) {
+		t.Errorf("in[%x] err = %v, want %v", frame, res.Err, driver.ErrIgnore)
+	}
+	if len(res.Tos) > 0 {
+		t.Errorf("in[%x] ignored frame forwarded %d msgs", frame, len(res.Tos))
+	}
+	return res
+}
+
+// ExpectKick 检查报文使协议要求断开连接: 返回 driver.ErrKick, 没有转发消息
+func ExpectKick(t testing.TB, p driver.Protocol, mark string, frame []byte) *Result {
+	t.Helper()
+	res := New(p).Translate(mark, frame, nil)
+	if !errors.Is(res.Err, driver.ErrKick) {
+		t.Errorf("in[%x] err = %v, want %v", frame, res.Err, driver.ErrKick)
+	}
+	if len(res.Tos) > 0 {
+		t.Errorf("in[%x] kicked frame forwarded %d msgs", frame, len(res.Tos))
+	}
+	return res
+}
+
+func matchErr(want string, err error) bool {
+	switch want {
+	case ErrNone:
+		return err == nil
+	case ErrIgnore:
+		return errors.Is(err, driver.ErrIgnore)
+	case ErrKick:
+		return errors.Is(err, driver.ErrKick)
+	case ErrBlock:
+		return errors.Is(err, driver.ErrBlock)
+	case ErrAny:
+		return err != nil && !errors.Is(err, ErrPanic)
+	}
+	return err != nil && err.Error() == want
+}
+
+func errName(want string) string {
+	if want == ErrNone {
+		return "nil"
+	}
+	return want
+}
+
+func compareFrames(t testing.TB, name string, want []string, got [][]byte) {
+	t.Helper()
+	if len(want) != len(got) {
+		t.Errorf("%s count = %d, want %d, got %x", name, len(got), len(want), got)
+		return
+	}
+	for i := range want {
+		w, err := ParseHex(want[i])
+		if err != nil {
+			t.Errorf("%s[%d] invalid hex: %v", name, i, err)
+			continue
+		}
+		if !bytes.Equal(w, got[i]) {
+			t.Errorf("%s[%d]\n got: %x\nwant: %x", name, i, got[i], w)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package protocoltest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// fakeProtocol 测试用协议, 第一个字节为命令码:
+// 0x00 心跳忽略, 0x01 登录, 0x02 通过转发端下发0x03, 0xee 断开, 0xdd 按第二个字节取下标(越界panic)
+// 应答为 命令码|0x80 00, 上行报文原样转发
+type fakeProtocol struct {
+	point driver.Driver
+}
+
+func (p *fakeProtocol) Inject(name string, v interface{}) driver.Inject {
+	if name == driver.InjectPointDriver {
+		p.point = v.(driver.Driver)
+	}
+	return p
+}
+
+func (p *fakeProtocol) Translate(ctx context.Context) (tos []driver.Msg, rets []driver.Msg, err error) {
+	acctx := driver.FromContext(ctx)
+	b := acctx.RawBytes()
+	if len(b) == 0 {
+		return nil, nil, driver.ErrIgnore
+	}
+	switch b[0] {
+	case 0x00:
+		return nil, nil, driver.ErrIgnore
+	case 0x01:
+	case 0x02:
+		if err := p.point.Send(driver.NewMsg(acctx.Mark, []byte{0x03, 0x00})); err != nil {
+			return nil, nil, err
+		}
+	case 0xdd:
+		_ = b[b[1]]
+	case 0xee:
+		return nil, nil, fmt.Errorf("%w: bad frame", driver.ErrKick)
+	default:
+		return nil, nil, driver.ErrIgnore
+	}
+	tos = append(tos, driver.NewMsg(acctx.Mark, b))
+	rets = append(rets, driver.NewMsg(acctx.Mark, []byte{b[0] | 0x80, 0x00}))
+	return
+}
+
+// recordTB 记录失败信息而不是让测试失败, 用于检查 harness 本身能发现问题
+type recordTB struct {
+	testing.TB
+	errs []string
+}
+
+func (r *recordTB) Helper() {}
+
+func (r *recordTB) Errorf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func (r *recordTB) Fatalf(format string, args ...interface{}) {
+	r.errs = append(r.errs, fmt.Sprintf(format, args...))
+}
+
+func TestLoadFixtures(t *testing.T) {
+	fs, err := LoadFixtures("testdata/fake.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fs) != 5 || fs[4].Name != "testdata/fake.json#4" || *fs[0].Tos != 1 {
+		t.Fatalf("fixtures = %+v", fs)
+	}
+	Run(t, &fakeProtocol{}, fs...)
+
+	if _, err := LoadFixtures("testdata/missing.json"); err == nil {
+		t.Fatal("load missing file returned nil")
+	}
+}
+
+func TestCheckReportsMismatch(t *testing.T) {
+	one, two := 1, 2
+	cases := []struct {
+		name     string
+		fixture  *Fixture
+		wantErrs int
+	}{
+		{name: "pass", fixture: &Fixture{In: "01", Rets: []string{"81 00"}, Tos: &one}, wantErrs: 0},
+		{name: "wrong ret", fixture: &Fixture{In: "01", Rets: []string{"81 01"}}, wantErrs: 1},
+		{name: "missing ret", fixture: &Fixture{In: "01"}, wantErrs: 1},
+		{name: "wrong tos", fixture: &Fixture{In: "01", Rets: []string{"81 00"}, Tos: &two}, wantErrs: 1},
+		{name: "wrong sent", fixture: &Fixture{In: "02", Rets: []string{"82 00"}, Sent: []string{"04 00"}}, wantErrs: 1},
+		{name: "wrong error", fixture: &Fixture{In: "00", Err: ErrKick}, wantErrs: 1},
+		{name: "unexpected error", fixture: &Fixture{In: "ee"}, wantErrs: 1},
+		{name: "any error", fixture: &Fixture{In: "ee", Err: ErrAny}, wantErrs: 0},
+		{name: "error text", fixture: &Fixture{In: "ee", Err: "error kick: bad frame"}, wantErrs: 0},
+		{name: "panic", fixture: &Fixture{In: "dd 05", Err: ErrAny}, wantErrs: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tb := &recordTB{TB: t}
+			New(&fakeProtocol{}).Check(tb, c.fixture)
+			if len(tb.errs) != c.wantErrs {
+				t.Fatalf("errors = %q, want %d", tb.errs, c.wantErrs)
+			}
+		})
+	}
+}
+
+func TestTranslateRecoversPanic(t *testing.T) {
+	h := New(&fakeProtocol{})
+	res := h.Translate("A1", []byte{0xdd, 0x05}, nil)
+	if !errors.Is(res.Err, ErrPanic) {
+		t.Fatalf("err = %v, want ErrPanic", res.Err)
+	}
+	// panic 之后 harness 可以继续使用
+	res = h.Translate("A1", []byte{0x02}, nil)
+	if res.Err != nil || len(res.Sent) != 1 {
+		t.Fatalf("after panic: err = %v, sent = %x", res.Err, res.Sent)
+	}
+}
+
+func TestFuzz(t *testing.T) {
+	cases := []struct {
+		name     string
+		seeds    [][]byte
+		wantFail bool
+	}{
+		{name: "no panic", seeds: [][]byte{{0x01, 0x02}, {0x02}}},
+		{name: "panic found", seeds: [][]byte{{0xdd, 0x01}}, wantFail: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tb := &recordTB{TB: t}
+			Fuzz(tb, &fakeProtocol{}, c.seeds, 500)
+			if (len(tb.errs) > 0) != c.wantFail {
+				t.Fatalf("errors = %d, want fail %v", len(tb.errs), c.wantFail)
+			}
+		})
+	}
+}
+
+func TestExpect(t *testing.T) {
+	ExpectIgnore(t, &fakeProtocol{}, "A1", []byte{0x00})
+	ExpectKick(t, &fakeProtocol{}, "A1", []byte{0xee})
+
+	tb := &recordTB{TB: t}
+	ExpectIgnore(tb, &fakeProtocol{}, "A1", []byte{0x01})
+	if len(tb.errs) != 2 {
+		t.Fatalf("errors = %q, want err and forwarded", tb.errs)
+	}
+}
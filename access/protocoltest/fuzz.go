@@ -0,0 +1,72 @@
+package protocoltest
+
+import (
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Fuzz 把种子报文随机变异n次后送入协议, 协议不能panic
+// 随机数种子固定, 失败可以复现
+func Fuzz(t testing.TB, p driver.Protocol, seeds [][]byte, n int) {
+	t.Helper()
+	if len(seeds) == 0 {
+		seeds = [][]byte{{}}
+	}
+	h := New(p)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < n; i++ {
+		frame := Mutate(r, seeds[i%len(seeds)])
+		if res := h.Translate("FUZZ", frame, nil); errors.Is(res.Err, ErrPanic) {
+			t.Fatalf("in[%x]: %v", frame, res.Err)
+		}
+	}
+}
+
+// FuzzTarget 用于go原生fuzz的目标函数
+//
+//	f.Fuzz(protocoltest.FuzzTarget(p))
+func FuzzTarget(p driver.Protocol) func(t *testing.T, frame []byte) {
+	h := New(p)
+	return func(t *testing.T, frame []byte) {
+		if res := h.Translate("FUZZ", frame, nil); errors.Is(res.Err, ErrPanic) {
+			t.Fatalf("in[%x]: %v", frame, res.Err)
+		}
+	}
+}
+
+// Mutate 随机变异一帧报文: 翻转位/改字节/截断/追加/插入/删除, 不修改原报文
+func Mutate(r *rand.Rand, seed []byte) []byte {
+	b := append([]byte(nil), seed...)
+	for n := 1 + r.Intn(3); n > 0; n-- {
+		switch op := r.Intn(6); {
+		case len(b) == 0 || op == 3:
+			b = append(b, randBytes(r, 1+r.Intn(8))...)
+		case op == 0:
+			b[r.Intn(len(b))] ^= 1 << uint(r.Intn(8))
+		case op == 1:
+			b[r.Intn(len(b))] = interesting[r.Intn(len(interesting))]
+		case op == 2:
+			b = b[:r.Intn(len(b))]
+		case op == 4:
+			i := r.Intn(len(b) + 1)
+			b = append(b[:i], append(randBytes(r, 1+r.Intn(4)), b[i:]...)...)
+		default:
+			i := r.Intn(len(b))
+			j := i + 1 + r.Intn(len(b)-i)
+			b = append(b[:i], b[j:]...)
+		}
+	}
+	return b
+}
+
+// interesting 容易触发长度/边界问题的字节
+var interesting = []byte{0x00, 0x01, 0x7f, 0x80, 0xfe, 0xff}
+
+func randBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	r.Read(b)
+	return b
+}
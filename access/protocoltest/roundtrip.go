@@ -0,0 +1,79 @@
+package protocoltest
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/zhuoqingbin/utils/access/codec"
+)
+
+// Message 协议中的一种报文, 用于编解码往返测试
+// Encode/Decode 为空时使用 codec.Marshal/codec.Unmarshal
+type Message struct {
+	Name   string
+	Sample interface{}        // 编码的样例, 结构体指针
+	New    func() interface{} // 创建解码目标, 为空时按Sample的类型创建
+
+	Encode func(v interface{}) ([]byte, error)
+	Decode func(b []byte, v interface{}) error
+}
+
+// RoundTrip 对每种报文: 编码样例 -> 解码 -> 和样例比较 -> 再编码和第一次编码比较
+func RoundTrip(t *testing.T, msgs ...Message) {
+	t.Helper()
+	for _, m := range msgs {
+		m := m
+		t.Run(m.Name, func(t *testing.T) {
+			CheckRoundTrip(t, m)
+		})
+	}
+}
+
+// CheckRoundTrip 检查一种报文的编解码往返
+func CheckRoundTrip(t testing.TB, m Message) {
+	t.Helper()
+	encode, decode := m.Encode, m.Decode
+	if encode == nil {
+		encode = codec.Marshal
+	}
+	if decode == nil {
+		decode = codec.Unmarshal
+	}
+
+	b, err := encode(m.Sample)
+	if err != nil {
+		t.Fatalf("encode %T: %v", m.Sample, err)
+	}
+
+	var v interface{}
+	if m.New != nil {
+		v = m.New()
+	} else {
+		typ := reflect.TypeOf(m.Sample)
+		if typ.Kind() == reflect.Ptr {
+			typ = typ.Elem()
+		}
+		v = reflect.New(typ).Interface()
+	}
+	if err = decode(b, v); err != nil {
+		t.Fatalf("decode [%x]: %v", b, err)
+	}
+
+	want := reflect.ValueOf(m.Sample)
+	if want.Kind() != reflect.Ptr {
+		want = reflect.New(want.Type())
+		want.Elem().Set(reflect.ValueOf(m.Sample))
+	}
+	if !reflect.DeepEqual(want.Interface(), v) {
+		t.Errorf("decode [%x]\n got: %+v\nwant: %+v", b, v, want.Interface())
+	}
+
+	again, err := encode(v)
+	if err != nil {
+		t.Fatalf("re-encode %T: %v", v, err)
+	}
+	if !bytes.Equal(b, again) {
+		t.Errorf("re-encode\n got: %x\nwant: %x", again, b)
+	}
+}
@@ -0,0 +1,171 @@
+package protocoltest
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Result 一帧报文的翻译结果
+type Result struct {
+	Ctx  *driver.Ctx
+	Tos  []driver.Msg
+	Rets []driver.Msg
+	Sent [][]byte // 协议通过转发端驱动Send的报文
+	Err  error    // 协议返回的错误, 包括After回调的错误
+}
+
+// RetBytes rets中的报文字节
+func (r *Result) RetBytes() (ret [][]byte) {
+	for _, m := range r.Rets {
+		ret = append(ret, driver.MsgBytes(m)...)
+	}
+	return
+}
+
+// ToBytes tos中的报文字节, 转发的消息不是字节时为空
+func (r *Result) ToBytes() (ret [][]byte) {
+	for _, m := range r.Tos {
+		ret = append(ret, driver.MsgBytes(m)...)
+	}
+	return
+}
+
+// Harness 协议的测试环境
+// 协议实现了 driver.Inject 时注入一个记录下发报文的转发端驱动
+type Harness struct {
+	protocol   driver.Protocol
+	driverName string
+	point      *Recorder
+}
+
+// New 创建测试环境
+func New(p driver.Protocol) *Harness {
+	h := &Harness{
+		protocol:   p,
+		driverName: "protocoltest",
+		point:      NewRecorder(),
+	}
+	if in, ok := driver.As[driver.Inject](p); ok {
+		in.Inject(driver.InjectPointDriver, driver.Driver(h.point))
+	}
+	return h
+}
+
+// SetDriverName 设置 Ctx.DriverName, 默认为 protocoltest
+func (h *Harness) SetDriverName(name string) *Harness {
+	h.driverName = name
+	return h
+}
+
+// Recorder 注入协议的转发端驱动
+func (h *Harness) Recorder() *Recorder {
+	return h.point
+}
+
+// Translate 翻译一帧报文, setup不为nil时在翻译前调用, 用于准备会话等上下文
+// 协议panic时返回错误, 不会中断测试
+func (h *Harness) Translate(mark string, frame []byte, setup func(acctx *driver.Ctx)) (res *Result) {
+	acctx := driver.NewACCtx(mark, h.driverName, frame)
+	if setup != nil {
+		setup(acctx)
+	}
+	res = &Result{Ctx: acctx}
+	h.point.begin()
+
+	defer func() {
+		if e := recover(); e != nil {
+			res.Err = fmt.Errorf("%w: %v", ErrPanic, e)
+		}
+		res.Sent = h.point.end()
+	}()
+
+	res.Tos, res.Rets, res.Err = h.protocol.Translate(driver.NewContext(context.Background(), acctx))
+	if err := acctx.RunAfterFuncs(); res.Err == nil {
+		res.Err = err
+	}
+	return
+}
+
+// Recorder 记录协议下发报文的转发端驱动, 所有设备默认在线
+type Recorder struct {
+	mu      sync.Mutex
+	offline map[string]struct{}
+	sent    [][]byte
+}
+
+// NewRecorder 创建记录驱动
+func NewRecorder() *Recorder {
+	return &Recorder{offline: make(map[string]struct{})}
+}
+
+// SetOnline 设置设备是否在线, 用于测试设备离线时协议的处理
+func (d *Recorder) SetOnline(mark string, online bool) {
+	d.mu.Lock()
+	if online {
+		delete(d.offline, mark)
+	} else {
+		d.offline[mark] = struct{}{}
+	}
+	d.mu.Unlock()
+}
+
+func (d *Recorder) begin() {
+	d.mu.Lock()
+	d.sent = nil
+	d.mu.Unlock()
+}
+
+func (d *Recorder) end() (sent [][]byte) {
+	d.mu.Lock()
+	sent, d.sent = d.sent, nil
+	d.mu.Unlock()
+	return
+}
+
+func (d *Recorder) Run() error { return nil }
+func (d *Recorder) Stop()      {}
+
+func (d *Recorder) CheckOnline(mark string) bool {
+	d.mu.Lock()
+	_, ok := d.offline[mark]
+	d.mu.Unlock()
+	return !ok
+}
+
+func (d *Recorder) Disconnector(mark, reason string) {
+	d.SetOnline(mark, false)
+}
+
+func (d *Recorder) Send(msg driver.Msg) error {
+	d.mu.Lock()
+	d.sent = append(d.sent, driver.MsgBytes(msg)...)
+	d.mu.Unlock()
+	return nil
+}
+
+func (d *Recorder) TraficSize(mark string) (recv, send int32, err error) {
+	return 0, 0, nil
+}
+
+func (d *Recorder) Debug(t string) {}
+
+// ParseHex 解析十六进制报文, 忽略空白和 0x 前缀
+func ParseHex(s string) ([]byte, error) {
+	s = strings.Join(strings.Fields(s), "")
+	s = strings.ReplaceAll(s, "0x", "")
+	return hex.DecodeString(s)
+}
+
+// MustHex 同 ParseHex, 解析失败panic
+func MustHex(s string) []byte {
+	b, err := ParseHex(s)
+	if err != nil {
+		panic(fmt.Sprintf("protocoltest: invalid hex %q: %v", s, err))
+	}
+	return b
+}
@@ -0,0 +1,148 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Bucket 令牌桶
+// 以 rate 个/秒的速度放入令牌, 最多积累 burst 个
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket 创建令牌桶, 初始为满桶
+func NewBucket(rate float64, burst int) *Bucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &Bucket{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+}
+
+// Allow 取一个令牌, 没有令牌返回false
+func (b *Bucket) Allow() bool {
+	return b.AllowN(1)
+}
+
+// AllowN 取n个令牌, 令牌不足返回false且不扣除
+func (b *Bucket) AllowN(n int) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	if b.tokens < float64(n) {
+		return false
+	}
+	b.tokens -= float64(n)
+	return true
+}
+
+// Reserve 预定一个令牌, 返回需要等待的时间
+// 返回0表示可以立即执行
+func (b *Bucket) Reserve() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(time.Now())
+	b.tokens--
+	if b.tokens >= 0 || b.rate <= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// refund 归还一个预定的令牌
+func (b *Bucket) refund() {
+	b.mu.Lock()
+	b.tokens++
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.mu.Unlock()
+}
+
+// full 桶是否已满(长时间未使用)
+func (b *Bucket) full(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.refill(now)
+	return b.tokens >= b.burst
+}
+
+// Keyed 按key(mark/ip/命令码)分别限流
+// 满桶且超过 idle 没有使用的 key 会被清理
+type Keyed struct {
+	rate  float64
+	burst int
+	idle  time.Duration
+
+	mu      sync.Mutex
+	buckets map[string]*Bucket
+	lastGC  time.Time
+}
+
+// NewKeyed 创建按key限流器, rate<=0表示不限流
+func NewKeyed(rate float64, burst int) *Keyed {
+	return &Keyed{
+		rate:    rate,
+		burst:   burst,
+		idle:    10 * time.Minute,
+		buckets: make(map[string]*Bucket),
+		lastGC:  time.Now(),
+	}
+}
+
+// Get 获取key对应的令牌桶
+func (k *Keyed) Get(key string) *Bucket {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(k.lastGC) > k.idle {
+		for key, b := range k.buckets {
+			if b.full(now) {
+				delete(k.buckets, key)
+			}
+		}
+		k.lastGC = now
+	}
+
+	b, ok := k.buckets[key]
+	if !ok {
+		b = NewBucket(k.rate, k.burst)
+		k.buckets[key] = b
+	}
+	return b
+}
+
+// Allow key是否允许通过
+func (k *Keyed) Allow(key string) bool {
+	if k.rate <= 0 {
+		return true
+	}
+	return k.Get(key).Allow()
+}
+
+// Remove 删除key(设备断开时调用)
+func (k *Keyed) Remove(key string) {
+	k.mu.Lock()
+	delete(k.buckets, key)
+	k.mu.Unlock()
+}
@@ -0,0 +1,21 @@
+package ratelimit
+
+import (
+	"time"
+
+	"github.com/zhuoqingbin/utils/flags"
+)
+
+var (
+	markRate      = flags.Float64("access_ratelimit_mark_rate", 50, "frames per second per mark, 0: unlimited. default: 50")
+	markBurst     = flags.Int("access_ratelimit_mark_burst", 100, "frame burst per mark. default: 100")
+	ipRate        = flags.Float64("access_ratelimit_ip_rate", 200, "frames per second per remote ip, 0: unlimited. default: 200")
+	ipBurst       = flags.Int("access_ratelimit_ip_burst", 400, "frame burst per remote ip. default: 400")
+	cmdRate       = flags.Float64("access_ratelimit_cmd_rate", 0, "frames per second per mark and cmd, 0: unlimited. default: 0")
+	cmdBurst      = flags.Int("access_ratelimit_cmd_burst", 20, "frame burst per mark and cmd. default: 20")
+	policy        = flags.String("access_ratelimit_policy", "drop", "action on violation: drop/delay/kick. default: drop")
+	maxDelay      = flags.Duration("access_ratelimit_max_delay", time.Second, "max delay when policy is delay. default: 1s")
+	acceptRate    = flags.Float64("access_ratelimit_accept_rate", 500, "accepted connections per second, 0: unlimited. default: 500")
+	acceptBurst   = flags.Int("access_ratelimit_accept_burst", 1000, "accepted connection burst. default: 1000")
+	maxConnsPerIP = flags.Int("access_ratelimit_max_conns_per_ip", 0, "max concurrent connections per remote ip, 0: unlimited. default: 0")
+)
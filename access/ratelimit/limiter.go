@@ -0,0 +1,144 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var limitCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_ratelimit_total",
+	Help: "rate limit violations by driver/scope(mark/ip/cmd/accept/conns)/action",
+}, []string{"driver", "scope", "action"})
+
+// Policy 超过限制后的处理
+type Policy string
+
+const (
+	PolicyDrop  Policy = "drop"  // 丢弃报文(driver.ErrIgnore)
+	PolicyDelay Policy = "delay" // 延迟处理, 超过最大延迟时丢弃
+	PolicyKick  Policy = "kick"  // 断开连接(driver.ErrKick)
+)
+
+// Config 限流配置, rate<=0表示不限制
+type Config struct {
+	MarkRate      float64
+	MarkBurst     int
+	IPRate        float64
+	IPBurst       int
+	CmdRate       float64 // 同一设备同一命令
+	CmdBurst      int
+	Policy        Policy
+	MaxDelay      time.Duration
+	AcceptRate    float64
+	AcceptBurst   int
+	MaxConnsPerIP int
+}
+
+// ConfigFromFlags 从启动参数读取限流配置, 需要在 flags.Parse() 之后调用
+func ConfigFromFlags() Config {
+	return Config{
+		MarkRate:      markRate(),
+		MarkBurst:     markBurst(),
+		IPRate:        ipRate(),
+		IPBurst:       ipBurst(),
+		CmdRate:       cmdRate(),
+		CmdBurst:      cmdBurst(),
+		Policy:        Policy(policy()),
+		MaxDelay:      maxDelay(),
+		AcceptRate:    acceptRate(),
+		AcceptBurst:   acceptBurst(),
+		MaxConnsPerIP: maxConnsPerIP(),
+	}
+}
+
+// Limiter 报文限流, 按设备/对端ip/命令分别限制
+type Limiter struct {
+	cfg   Config
+	marks *Keyed
+	ips   *Keyed
+	cmds  *Keyed
+}
+
+// NewLimiter 创建报文限流
+func NewLimiter(cfg Config) *Limiter {
+	if cfg.Policy == "" {
+		cfg.Policy = PolicyDrop
+	}
+	return &Limiter{
+		cfg:   cfg,
+		marks: NewKeyed(cfg.MarkRate, cfg.MarkBurst),
+		ips:   NewKeyed(cfg.IPRate, cfg.IPBurst),
+		cmds:  NewKeyed(cfg.CmdRate, cfg.CmdBurst),
+	}
+}
+
+// Remove 设备断开时清理设备的令牌桶
+func (l *Limiter) Remove(mark string) {
+	l.marks.Remove(mark)
+}
+
+// take 取令牌, 返回nil表示通过
+func (l *Limiter) take(k *Keyed, driverName, scope, key string) error {
+	if k.rate <= 0 || key == "" {
+		return nil
+	}
+	b := k.Get(key)
+	if l.cfg.Policy == PolicyDelay {
+		wait := b.Reserve()
+		if wait == 0 {
+			return nil
+		}
+		if wait <= l.cfg.MaxDelay {
+			limitCounter.WithLabelValues(driverName, scope, string(PolicyDelay)).Inc()
+			time.Sleep(wait)
+			return nil
+		}
+		// 超过最大延迟, 归还预定的令牌后丢弃
+		b.refund()
+		limitCounter.WithLabelValues(driverName, scope, string(PolicyDrop)).Inc()
+		return fmt.Errorf("%w: rate limit %s[%s]", driver.ErrIgnore, scope, key)
+	}
+
+	if b.Allow() {
+		return nil
+	}
+	limitCounter.WithLabelValues(driverName, scope, string(l.cfg.Policy)).Inc()
+	if l.cfg.Policy == PolicyKick {
+		return fmt.Errorf("%w: rate limit %s[%s]", driver.ErrKick, scope, key)
+	}
+	return fmt.Errorf("%w: rate limit %s[%s]", driver.ErrIgnore, scope, key)
+}
+
+// Interceptor 翻译拦截器
+// 设备和ip在翻译前检查, 命令在翻译后按协议写入的命令码检查(超过时丢弃翻译结果)
+func (l *Limiter) Interceptor() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) ([]driver.Msg, []driver.Msg, error) {
+		if err := l.take(l.ips, acctx.DriverName, "ip", remoteIP(acctx.GetRemote())); err != nil {
+			return nil, nil, err
+		}
+		if err := l.take(l.marks, acctx.DriverName, "mark", acctx.Mark); err != nil {
+			return nil, nil, err
+		}
+
+		tos, rets, err := handler(ctx)
+		if cmd := acctx.GetCmd(); cmd != "" && acctx.Mark != "" {
+			if err := l.take(l.cmds, acctx.DriverName, "cmd", acctx.Mark+":"+cmd); err != nil {
+				return nil, nil, err
+			}
+		}
+		return tos, rets, err
+	}
+}
+
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
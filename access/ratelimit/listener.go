@@ -0,0 +1,91 @@
+package ratelimit
+
+import (
+	"net"
+	"sync"
+)
+
+// Listener 限制接入速率和单ip并发连接数的监听
+// 超过限制的连接在Accept中直接关闭, 不返回给驱动
+type Listener struct {
+	net.Listener
+	driver   string
+	accept   *Bucket
+	maxPerIP int
+
+	mu    sync.Mutex
+	conns map[string]int
+}
+
+// NewListener 包装驱动的监听
+func NewListener(l net.Listener, driverName string, cfg Config) *Listener {
+	ln := &Listener{
+		Listener: l,
+		driver:   driverName,
+		maxPerIP: cfg.MaxConnsPerIP,
+		conns:    make(map[string]int),
+	}
+	if cfg.AcceptRate > 0 {
+		ln.accept = NewBucket(cfg.AcceptRate, cfg.AcceptBurst)
+	}
+	return ln
+}
+
+// Accept 接受连接
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.accept != nil && !l.accept.Allow() {
+			limitCounter.WithLabelValues(l.driver, "accept", string(PolicyKick)).Inc()
+			c.Close()
+			continue
+		}
+
+		ip := remoteIP(c.RemoteAddr().String())
+		if l.maxPerIP > 0 {
+			l.mu.Lock()
+			if l.conns[ip] >= l.maxPerIP {
+				l.mu.Unlock()
+				limitCounter.WithLabelValues(l.driver, "conns", string(PolicyKick)).Inc()
+				c.Close()
+				continue
+			}
+			l.conns[ip]++
+			l.mu.Unlock()
+			return &conn{Conn: c, ip: ip, l: l}, nil
+		}
+		return c, nil
+	}
+}
+
+// ConnsPerIP ip当前连接数
+func (l *Listener) ConnsPerIP(ip string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.conns[ip]
+}
+
+func (l *Listener) release(ip string) {
+	l.mu.Lock()
+	if l.conns[ip] <= 1 {
+		delete(l.conns, ip)
+	} else {
+		l.conns[ip]--
+	}
+	l.mu.Unlock()
+}
+
+type conn struct {
+	net.Conn
+	ip   string
+	l    *Listener
+	once sync.Once
+}
+
+func (c *conn) Close() error {
+	c.once.Do(func() { c.l.release(c.ip) })
+	return c.Conn.Close()
+}
@@ -0,0 +1,450 @@
+package reconcile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var reconcileCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "access_reconcile_total",
+	Help: "offline transaction records by reconciler/result(record/late/duplicate/gap/error)",
+}, []string{"reconciler", "result"})
+
+const defaultTTL = 30 * 24 * time.Hour
+
+// ErrNoTxID 交易号为空
+var ErrNoTxID = errors.New("reconcile: empty transaction id")
+
+// Transaction 协议解析出的一条交易记录
+// 设备时间一般来自报文中的 driver.Bytetime 或 driver.CP56Time, 使用其 TimeIn 转换
+type Transaction interface {
+	// TxID 交易号, 同一设备唯一
+	TxID() string
+	// DeviceTime 设备记录的交易时间(如结束时间)
+	DeviceTime() time.Time
+}
+
+// Sequenced 交易记录带设备的连续序号时实现, 用于检测缺失的记录
+// 序号会回绕(如2字节序号)时设置 Reconciler.SetSeqModulus
+type Sequenced interface {
+	Seq() uint64
+}
+
+// EventType 事件类型
+type EventType string
+
+const (
+	EventRecord EventType = "record" // 一条交易记录
+	EventGap    EventType = "gap"    // 序号不连续, 有记录缺失
+)
+
+// Event 对账后的事件
+// ID由设备和交易号(或缺失的序号范围)决定, 重复发出的事件ID相同, 消费方按ID去重
+type Event struct {
+	ID      string      `json:"id"`
+	Type    EventType   `json:"type"`
+	Mark    string      `json:"mark"`
+	TxID    string      `json:"tx_id,omitempty"`
+	Seq     uint64      `json:"seq,omitempty"`
+	Time    time.Time   `json:"time"`               // 设备时间, 缺失事件为检测时间
+	Late    bool        `json:"late,omitempty"`     // 早于已经发出的记录(设备时间或序号)
+	GapFrom uint64      `json:"gap_from,omitempty"` // 缺失的序号范围 [GapFrom, GapTo], 序号回绕时GapFrom可能大于GapTo
+	GapTo   uint64      `json:"gap_to,omitempty"`
+	Tx      Transaction `json:"tx,omitempty"`
+}
+
+// Reconciler 离线交易记录对账
+// 设备重连后批量上传的记录先按设备缓存, 一段时间没有新记录(或数量达到上限)后
+// 按设备时间排序, 去掉已经发出的交易, 检查序号缺失, 再依次回调
+// 回调返回错误时该记录及之后的记录重新缓存, 下次再发出, 保证至少一次
+//
+//	r := reconcile.New("ykc", reconcile.NewRedisStore("", 0)).OnEvent(func(e reconcile.Event) error {...})
+//	go r.Run(ctx)
+//
+//	// 协议中, 不论是否重复都要应答设备
+//	r.FeedCtx(acctx, record)
+type Reconciler struct {
+	name     string
+	store    Store
+	window   time.Duration
+	maxBatch int
+	interval time.Duration
+	modulus  uint64
+	log      *logrus.Entry
+
+	locks     sync.Map // mark -> *sync.Mutex, 同一设备串行发出
+	mu        sync.Mutex
+	pending   map[string]*batch
+	latest    map[string]time.Time // 设备已发出记录的最大设备时间
+	unchecked map[string][]uint64  // 缺失事件发出失败时, 已发出但还没有检查缺失的序号, 下次Flush重新检查
+	handlers  []func(Event) error
+}
+
+type batch struct {
+	txs  map[string]Transaction
+	last time.Time // 最后一条记录的接收时间
+}
+
+// New 创建对账, name用于指标标签
+func New(name string, s Store) *Reconciler {
+	return &Reconciler{
+		name:      name,
+		store:     s,
+		window:    3 * time.Second,
+		maxBatch:  256,
+		interval:  time.Second,
+		log:       logrus.WithField("module", "reconcile").WithField("reconciler", name),
+		pending:   make(map[string]*batch),
+		latest:    make(map[string]time.Time),
+		unchecked: make(map[string][]uint64),
+	}
+}
+
+// SetWindow 设备超过该时间没有新记录时发出缓存的记录
+func (r *Reconciler) SetWindow(d time.Duration) *Reconciler {
+	r.window = d
+	return r
+}
+
+// SetMaxBatch 设备缓存的记录达到该数量时立即发出
+func (r *Reconciler) SetMaxBatch(n int) *Reconciler {
+	r.maxBatch = n
+	return r
+}
+
+// SetInterval 扫描缓存的间隔
+func (r *Reconciler) SetInterval(d time.Duration) *Reconciler {
+	r.interval = d
+	return r
+}
+
+// SetSeqModulus 序号按m回绕(如2字节序号为65536), 0表示不回绕
+// 设置后序号按环比较: 向前距离小于m/2为新记录, 否则为迟到的记录
+func (r *Reconciler) SetSeqModulus(m uint64) *Reconciler {
+	r.modulus = m
+	return r
+}
+
+// dist 从from向前到to的序号距离
+func (r *Reconciler) dist(from, to uint64) uint64 {
+	if r.modulus == 0 {
+		return to - from
+	}
+	return (to%r.modulus + r.modulus - from%r.modulus) % r.modulus
+}
+
+// after 序号seq是否在last之后
+func (r *Reconciler) after(seq, last uint64) bool {
+	if r.modulus == 0 {
+		return seq > last
+	}
+	d := r.dist(last, seq)
+	return d != 0 && d < (r.modulus+1)/2
+}
+
+// step 序号seq之后第n个序号(n可以为-1)
+func (r *Reconciler) step(seq uint64, n int64) uint64 {
+	v := seq + uint64(n)
+	if r.modulus == 0 {
+		return v
+	}
+	if n < 0 {
+		v = seq%r.modulus + r.modulus - uint64(-n)
+	}
+	return v % r.modulus
+}
+
+// OnEvent 注册事件回调, 回调在发出记录的协程中同步执行
+func (r *Reconciler) OnEvent(f func(Event) error) *Reconciler {
+	r.mu.Lock()
+	r.handlers = append(r.handlers, f)
+	r.mu.Unlock()
+	return r
+}
+
+// FeedCtx 同 Feed, 设备标记取 acctx.Mark
+func (r *Reconciler) FeedCtx(acctx *driver.Ctx, tx Transaction) (dup bool, err error) {
+	return r.Feed(acctx.Mark, tx)
+}
+
+// Feed 缓存一条交易记录, 交易已经发出或已经在缓存中时dup为true
+func (r *Reconciler) Feed(mark string, tx Transaction) (dup bool, err error) {
+	id := tx.TxID()
+	if id == "" {
+		return false, ErrNoTxID
+	}
+	if dup, err = r.store.Seen(mark, id); err != nil {
+		reconcileCounter.WithLabelValues(r.name, "error").Inc()
+		return false, err
+	} else if dup {
+		reconcileCounter.WithLabelValues(r.name, "duplicate").Inc()
+		return true, nil
+	}
+
+	r.mu.Lock()
+	b, ok := r.pending[mark]
+	if !ok {
+		b = &batch{txs: make(map[string]Transaction)}
+		r.pending[mark] = b
+	}
+	_, dup = b.txs[id]
+	if !dup {
+		b.txs[id] = tx
+	}
+	b.last = time.Now()
+	full := r.maxBatch > 0 && len(b.txs) >= r.maxBatch
+	r.mu.Unlock()
+
+	if dup {
+		reconcileCounter.WithLabelValues(r.name, "duplicate").Inc()
+		return true, nil
+	}
+	if full {
+		return false, r.Flush(mark)
+	}
+	return false, nil
+}
+
+// Flush 立即发出设备缓存的记录, 如设备上报补传结束时
+func (r *Reconciler) Flush(mark string) (err error) {
+	v, _ := r.locks.LoadOrStore(mark, &sync.Mutex{})
+	mu := v.(*sync.Mutex)
+	mu.Lock()
+	defer mu.Unlock()
+
+	r.mu.Lock()
+	b, ok := r.pending[mark]
+	delete(r.pending, mark)
+	seqs := r.unchecked[mark]
+	delete(r.unchecked, mark)
+	handlers := r.handlers
+	latest := r.latest[mark]
+	r.mu.Unlock()
+	if !ok && len(seqs) == 0 {
+		return nil
+	}
+
+	var txs []Transaction
+	if ok {
+		txs = make([]Transaction, 0, len(b.txs))
+		for _, tx := range b.txs {
+			txs = append(txs, tx)
+		}
+		sortTxs(txs)
+	}
+
+	lastSeq, hasSeq, err := r.store.LastSeq(mark)
+	if err != nil {
+		r.requeue(mark, txs)
+		r.recheck(mark, seqs)
+		return err
+	}
+	// 发出失败时, 已经发出的记录也要检查缺失并更新最大序号
+	defer func() {
+		if e := r.checkGaps(mark, handlers, lastSeq, hasSeq, seqs); e != nil && err == nil {
+			err = e
+		}
+	}()
+
+	for i, tx := range txs {
+		ev := Event{
+			ID:   mark + ":" + tx.TxID(),
+			Type: EventRecord,
+			Mark: mark,
+			TxID: tx.TxID(),
+			Time: tx.DeviceTime(),
+			Late: tx.DeviceTime().Before(latest),
+			Tx:   tx,
+		}
+		s, sequenced := tx.(Sequenced)
+		if sequenced {
+			ev.Seq = s.Seq()
+			ev.Late = ev.Late || (hasSeq && !r.after(ev.Seq, lastSeq))
+		}
+		if err = r.emit(handlers, ev); err == nil {
+			err = r.store.MarkSeen(mark, ev.TxID)
+		}
+		if err != nil {
+			reconcileCounter.WithLabelValues(r.name, "error").Inc()
+			r.requeue(mark, txs[i:])
+			return fmt.Errorf("reconcile: [%s] tx[%s]: %w", mark, ev.TxID, err)
+		}
+
+		result := "record"
+		if ev.Late {
+			result = "late"
+		}
+		reconcileCounter.WithLabelValues(r.name, result).Inc()
+		if ev.Time.After(latest) {
+			latest = ev.Time
+			r.mu.Lock()
+			r.latest[mark] = latest
+			r.mu.Unlock()
+		}
+		if sequenced && (!hasSeq || r.after(ev.Seq, lastSeq)) {
+			seqs = append(seqs, ev.Seq)
+		}
+	}
+	return nil
+}
+
+// checkGaps 检查新发出的序号和已发出的最大序号之间是否连续, 发出缺失事件并更新最大序号
+func (r *Reconciler) checkGaps(mark string, handlers []func(Event) error, lastSeq uint64, hasSeq bool, seqs []uint64) error {
+	if len(seqs) == 0 {
+		return nil
+	}
+	base := lastSeq
+	if !hasSeq { // 第一次对账, 以本批最早的序号为起点
+		first := seqs[0]
+		for _, seq := range seqs[1:] {
+			if r.after(first, seq) {
+				first = seq
+			}
+		}
+		base = r.step(first, -1)
+	}
+	// 按到起点的距离排序, 序号回绕时也是按发生顺序
+	sort.Slice(seqs, func(i, j int) bool { return r.dist(base, seqs[i]) < r.dist(base, seqs[j]) })
+	prev, now := base, time.Now()
+	for i, seq := range seqs {
+		d := r.dist(prev, seq)
+		if d == 0 {
+			continue
+		}
+		if d > 1 {
+			from, to := r.step(prev, 1), r.step(seq, -1)
+			ev := Event{
+				ID:      fmt.Sprintf("%s:gap:%d-%d", mark, from, to),
+				Type:    EventGap,
+				Mark:    mark,
+				Time:    now,
+				GapFrom: from,
+				GapTo:   to,
+			}
+			if err := r.emit(handlers, ev); err != nil {
+				reconcileCounter.WithLabelValues(r.name, "error").Inc()
+				// 最大序号只更新到缺失之前, 之后的序号留到下次Flush, 重新检测到这段缺失
+				r.recheck(mark, seqs[i:])
+				if !hasSeq || r.after(prev, lastSeq) {
+					if e := r.store.SetLastSeq(mark, prev); e != nil {
+						r.log.WithField("mark", mark).Warnf("set last seq: %v", e)
+					}
+				}
+				return fmt.Errorf("reconcile: [%s] gap[%d-%d]: %w", mark, ev.GapFrom, ev.GapTo, err)
+			}
+			reconcileCounter.WithLabelValues(r.name, "gap").Inc()
+		}
+		prev = seq
+	}
+	return r.store.SetLastSeq(mark, prev)
+}
+
+// recheck 保存还没有检查缺失的序号, 下次Flush时和新记录的序号一起检查
+func (r *Reconciler) recheck(mark string, seqs []uint64) {
+	if len(seqs) == 0 {
+		return
+	}
+	r.mu.Lock()
+	r.unchecked[mark] = append(r.unchecked[mark], seqs...)
+	r.mu.Unlock()
+}
+
+func (r *Reconciler) emit(handlers []func(Event) error, ev Event) error {
+	for _, f := range handlers {
+		if err := f(ev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// requeue 发出失败的记录放回缓存, 不覆盖期间新收到的同一交易
+func (r *Reconciler) requeue(mark string, txs []Transaction) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	b, ok := r.pending[mark]
+	if !ok {
+		b = &batch{txs: make(map[string]Transaction), last: time.Now()}
+		r.pending[mark] = b
+	}
+	for _, tx := range txs {
+		if _, ok := b.txs[tx.TxID()]; !ok {
+			b.txs[tx.TxID()] = tx
+		}
+	}
+}
+
+// Pending 设备缓存中待发出的记录数
+func (r *Reconciler) Pending(mark string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if b, ok := r.pending[mark]; ok {
+		return len(b.txs)
+	}
+	return 0
+}
+
+// Disconnect 设备断开时调用, 发出缓存的记录
+func (r *Reconciler) Disconnect(mark string) {
+	if err := r.Flush(mark); err != nil {
+		r.log.WithField("mark", mark).Warnf("flush: %v", err)
+	}
+}
+
+// Run 定时发出超过窗口时间没有新记录的设备缓存, ctx结束后退出
+func (r *Reconciler) Run(ctx context.Context) {
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		now := time.Now()
+		var marks []string
+		r.mu.Lock()
+		for mark, b := range r.pending {
+			if now.Sub(b.last) >= r.window {
+				marks = append(marks, mark)
+			}
+		}
+		for mark := range r.unchecked {
+			if _, ok := r.pending[mark]; !ok {
+				marks = append(marks, mark)
+			}
+		}
+		r.mu.Unlock()
+		for _, mark := range marks {
+			if err := r.Flush(mark); err != nil {
+				r.log.WithField("mark", mark).Warnf("flush: %v", err)
+			}
+		}
+	}
+}
+
+// sortTxs 按设备时间排序, 时间相同时按序号, 再按交易号
+func sortTxs(txs []Transaction) {
+	sort.SliceStable(txs, func(i, j int) bool {
+		ti, tj := txs[i].DeviceTime(), txs[j].DeviceTime()
+		if !ti.Equal(tj) {
+			return ti.Before(tj)
+		}
+		si, iok := txs[i].(Sequenced)
+		sj, jok := txs[j].(Sequenced)
+		if iok && jok && si.Seq() != sj.Seq() {
+			return si.Seq() < sj.Seq()
+		}
+		return txs[i].TxID() < txs[j].TxID()
+	})
+}
@@ -0,0 +1,205 @@
+package reconcile
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testTx struct {
+	id  string
+	seq uint64
+	t   time.Time
+}
+
+func (t testTx) TxID() string          { return t.id }
+func (t testTx) DeviceTime() time.Time { return t.t }
+func (t testTx) Seq() uint64           { return t.seq }
+
+var baseTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// seqTx 序号为seq的交易, 第i条按设备时间排在第i位
+func seqTx(i int, seq uint64) testTx {
+	return testTx{id: fmt.Sprintf("T%d-%d", i, seq), seq: seq, t: baseTime.Add(time.Duration(i) * time.Minute)}
+}
+
+// recorder 记录发出的事件, fail返回true时回调失败
+type recorder struct {
+	records []uint64
+	late    []uint64
+	gaps    []string
+	fail    func(e Event) bool
+}
+
+func (rc *recorder) handle(e Event) error {
+	if rc.fail != nil && rc.fail(e) {
+		return errors.New("handler failed")
+	}
+	switch e.Type {
+	case EventRecord:
+		rc.records = append(rc.records, e.Seq)
+		if e.Late {
+			rc.late = append(rc.late, e.Seq)
+		}
+	case EventGap:
+		rc.gaps = append(rc.gaps, fmt.Sprintf("%d-%d", e.GapFrom, e.GapTo))
+	}
+	return nil
+}
+
+func feedSeqs(t *testing.T, r *Reconciler, i *int, seqs []uint64) {
+	t.Helper()
+	for _, seq := range seqs {
+		if _, err := r.Feed("A", seqTx(*i, seq)); err != nil {
+			t.Fatal(err)
+		}
+		*i++
+	}
+}
+
+func TestGaps(t *testing.T) {
+	cases := []struct {
+		name     string
+		modulus  uint64
+		batches  [][]uint64
+		wantGaps []string
+		wantLate []uint64
+		wantLast uint64
+	}{
+		{name: "continuous", batches: [][]uint64{{1, 2, 3}, {4, 5}}, wantLast: 5},
+		{name: "gap in batch", batches: [][]uint64{{1, 2, 5}}, wantGaps: []string{"3-4"}, wantLast: 5},
+		{name: "gap across batches", batches: [][]uint64{{1, 2}, {6}}, wantGaps: []string{"3-5"}, wantLast: 6},
+		{name: "late record", batches: [][]uint64{{1, 2, 3}, {2}}, wantLate: []uint64{2}, wantLast: 3},
+		{name: "wrap continuous", modulus: 65536, batches: [][]uint64{{65534, 65535}, {0, 1}}, wantLast: 1},
+		{name: "wrap in batch", modulus: 65536, batches: [][]uint64{{65534, 65535, 0, 1}}, wantLast: 1},
+		{name: "wrap gap", modulus: 65536, batches: [][]uint64{{65534}, {1}}, wantGaps: []string{"65535-0"}, wantLast: 1},
+		{name: "wrap late", modulus: 65536, batches: [][]uint64{{65535, 0, 1}, {65535}}, wantLate: []uint64{65535}, wantLast: 1},
+		{name: "no modulus wrap is late", batches: [][]uint64{{65534, 65535}, {0}}, wantLate: []uint64{0}, wantLast: 65535},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rc := &recorder{}
+			st := NewMemoryStore(0)
+			r := New("test", st).SetSeqModulus(c.modulus).OnEvent(rc.handle)
+			i := 0
+			for _, b := range c.batches {
+				feedSeqs(t, r, &i, b)
+				if err := r.Flush("A"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !reflect.DeepEqual(rc.gaps, c.wantGaps) {
+				t.Fatalf("gaps = %v, want %v", rc.gaps, c.wantGaps)
+			}
+			if !reflect.DeepEqual(rc.late, c.wantLate) {
+				t.Fatalf("late = %v, want %v", rc.late, c.wantLate)
+			}
+			if last, _, _ := st.LastSeq("A"); last != c.wantLast {
+				t.Fatalf("last seq = %d, want %d", last, c.wantLast)
+			}
+		})
+	}
+}
+
+func TestDuplicate(t *testing.T) {
+	r := New("test", NewMemoryStore(0)).OnEvent((&recorder{}).handle)
+	tx := seqTx(0, 1)
+	cases := []struct {
+		name    string
+		flush   bool
+		wantDup bool
+	}{
+		{name: "first", wantDup: false},
+		{name: "pending", wantDup: true, flush: true},
+		{name: "emitted", wantDup: true},
+	}
+	for _, c := range cases {
+		dup, err := r.Feed("A", tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dup != c.wantDup {
+			t.Fatalf("%s: dup = %v, want %v", c.name, dup, c.wantDup)
+		}
+		if c.flush {
+			if err := r.Flush("A"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := r.Feed("A", testTx{}); err != ErrNoTxID {
+		t.Fatalf("err = %v, want ErrNoTxID", err)
+	}
+}
+
+func TestFailedRecordRequeued(t *testing.T) {
+	rc := &recorder{}
+	failing := true
+	rc.fail = func(e Event) bool { return failing && e.Seq == 3 }
+	r := New("test", NewMemoryStore(0)).OnEvent(rc.handle)
+	i := 0
+	feedSeqs(t, r, &i, []uint64{1, 2, 3, 4})
+	if err := r.Flush("A"); err == nil {
+		t.Fatal("flush with failing handler returned nil")
+	}
+	if n := r.Pending("A"); n != 2 {
+		t.Fatalf("pending = %d, want 2", n)
+	}
+	failing = false
+	if err := r.Flush("A"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint64{1, 2, 3, 4}; !reflect.DeepEqual(rc.records, want) {
+		t.Fatalf("records = %v, want %v", rc.records, want)
+	}
+}
+
+func TestFailedGapRechecked(t *testing.T) {
+	rc := &recorder{}
+	failing := true
+	rc.fail = func(e Event) bool { return failing && e.Type == EventGap }
+	st := NewMemoryStore(0)
+	r := New("test", st).OnEvent(rc.handle)
+	i := 0
+	feedSeqs(t, r, &i, []uint64{1, 2, 5, 6})
+	if err := r.Flush("A"); err == nil {
+		t.Fatal("flush with failing gap handler returned nil")
+	}
+	if last, _, _ := st.LastSeq("A"); last != 2 {
+		t.Fatalf("last seq = %d, want 2", last)
+	}
+	failing = false
+	if err := r.Flush("A"); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"3-4"}; !reflect.DeepEqual(rc.gaps, want) {
+		t.Fatalf("gaps = %v, want %v", rc.gaps, want)
+	}
+	if last, _, _ := st.LastSeq("A"); last != 6 {
+		t.Fatalf("last seq = %d, want 6", last)
+	}
+}
+
+func TestMemoryStoreExpire(t *testing.T) {
+	s := NewMemoryStore(20 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if err := s.MarkSeen("A", fmt.Sprint(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ok, _ := s.Seen("A", "0"); !ok {
+		t.Fatal("tx 0 not seen")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if err := s.MarkSeen("A", "new"); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := s.Seen("A", "0"); ok {
+		t.Fatal("tx 0 not expired")
+	}
+	if n := len(s.seen["A"].exp); n != 1 {
+		t.Fatalf("kept %d txs, want 1", n)
+	}
+}
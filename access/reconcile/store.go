@@ -0,0 +1,112 @@
+package reconcile
+
+import (
+	"sync"
+	"time"
+)
+
+// Store 保存已经发出的交易和设备最大序号
+type Store interface {
+	// Seen 交易是否已经发出
+	Seen(mark, txID string) (bool, error)
+
+	// MarkSeen 记录交易已经发出
+	MarkSeen(mark, txID string) error
+
+	// LastSeq 设备已经发出的最大序号, 没有记录时ok为false
+	LastSeq(mark string) (seq uint64, ok bool, err error)
+
+	// SetLastSeq 更新设备已经发出的最大序号
+	SetLastSeq(mark string, seq uint64) error
+}
+
+// MemoryStore 内存存储, 进程重启后记录丢失, 用于测试和单机部署
+type MemoryStore struct {
+	ttl time.Duration
+
+	mu   sync.Mutex
+	seen map[string]*memSeen
+	seqs map[string]uint64
+}
+
+// memSeen 设备已发出的交易, ttl固定, 按记录顺序过期
+type memSeen struct {
+	exp   map[string]time.Time // txID -> 过期时间
+	order []seenEntry          // 按过期时间从早到晚
+}
+
+type seenEntry struct {
+	txID string
+	exp  time.Time
+}
+
+// expire 从最早的记录开始清理过期的交易, 重复记录的交易以最后一次为准
+func (m *memSeen) expire(now time.Time) {
+	n := 0
+	for n < len(m.order) && now.After(m.order[n].exp) {
+		e := m.order[n]
+		if exp, ok := m.exp[e.txID]; ok && exp.Equal(e.exp) {
+			delete(m.exp, e.txID)
+		}
+		n++
+	}
+	// append扩容时只复制未过期的部分, 均摊O(1)
+	m.order = m.order[n:]
+}
+
+// NewMemoryStore 创建内存存储, 交易记录保留ttl, <=0时保留30天
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &MemoryStore{
+		ttl:  ttl,
+		seen: make(map[string]*memSeen),
+		seqs: make(map[string]uint64),
+	}
+}
+
+// Seen 交易是否已经发出
+func (s *MemoryStore) Seen(mark, txID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.seen[mark]
+	if !ok {
+		return false, nil
+	}
+	exp, ok := m.exp[txID]
+	return ok && !time.Now().After(exp), nil
+}
+
+// MarkSeen 记录交易已经发出, 同时清理设备过期的记录
+func (s *MemoryStore) MarkSeen(mark, txID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, ok := s.seen[mark]
+	if !ok {
+		m = &memSeen{exp: make(map[string]time.Time)}
+		s.seen[mark] = m
+	}
+	now := time.Now()
+	m.expire(now)
+	exp := now.Add(s.ttl)
+	m.exp[txID] = exp
+	m.order = append(m.order, seenEntry{txID: txID, exp: exp})
+	return nil
+}
+
+// LastSeq 设备已经发出的最大序号
+func (s *MemoryStore) LastSeq(mark string) (uint64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seq, ok := s.seqs[mark]
+	return seq, ok, nil
+}
+
+// SetLastSeq 更新设备已经发出的最大序号
+func (s *MemoryStore) SetLastSeq(mark string, seq uint64) error {
+	s.mu.Lock()
+	s.seqs[mark] = seq
+	s.mu.Unlock()
+	return nil
+}
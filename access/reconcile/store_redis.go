@@ -0,0 +1,62 @@
+package reconcile
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/zhuoqingbin/utils/redigo"
+)
+
+// RedisStore redis存储, 使用 redigo 连接池
+//
+//	{prefix}:{mark}:{txID}   string  交易已发出, 过期时间为ttl
+//	{prefix}:seq             hash    mark -> 最大序号
+type RedisStore struct {
+	prefix string
+	ttl    time.Duration
+}
+
+// NewRedisStore 创建redis存储, prefix为空时使用 access:reconcile
+// 交易记录保留ttl, <=0时保留30天
+func NewRedisStore(prefix string, ttl time.Duration) *RedisStore {
+	if prefix == "" {
+		prefix = "access:reconcile"
+	}
+	if ttl <= 0 {
+		ttl = defaultTTL
+	}
+	return &RedisStore{prefix: prefix, ttl: ttl}
+}
+
+func (s *RedisStore) key(mark, txID string) string {
+	return fmt.Sprintf("%s:%s:%s", s.prefix, mark, txID)
+}
+
+// Seen 交易是否已经发出
+func (s *RedisStore) Seen(mark, txID string) (bool, error) {
+	return redis.Bool(redigo.Do("EXISTS", s.key(mark, txID)))
+}
+
+// MarkSeen 记录交易已经发出
+func (s *RedisStore) MarkSeen(mark, txID string) error {
+	_, err := redigo.Do("SET", s.key(mark, txID), 1, "EX", int64(s.ttl/time.Second))
+	return err
+}
+
+// LastSeq 设备已经发出的最大序号
+func (s *RedisStore) LastSeq(mark string) (uint64, bool, error) {
+	seq, err := redis.Uint64(redigo.Do("HGET", s.prefix+":seq", mark))
+	if err == redis.ErrNil {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return seq, true, nil
+}
+
+// SetLastSeq 更新设备已经发出的最大序号
+func (s *RedisStore) SetLastSeq(mark string, seq uint64) error {
+	_, err := redigo.Do("HSET", s.prefix+":seq", mark, seq)
+	return err
+}
@@ -0,0 +1,127 @@
+package access
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Params 组件配置参数
+type Params map[string]interface{}
+
+// String 获取字符串参数
+func (p Params) String(key, def string) string {
+	if v, ok := p[key].(string); ok {
+		return v
+	}
+	return def
+}
+
+// Int 获取整型参数
+func (p Params) Int(key string, def int) int {
+	switch v := p[key].(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return def
+}
+
+// Bool 获取布尔参数
+func (p Params) Bool(key string, def bool) bool {
+	if v, ok := p[key].(bool); ok {
+		return v
+	}
+	return def
+}
+
+// DriverFactory 根据配置参数创建驱动
+type DriverFactory func(params Params) (driver.Driver, error)
+
+// ProtocolFactory 根据配置参数创建协议
+type ProtocolFactory func(params Params) (driver.Protocol, error)
+
+var (
+	registryMu sync.RWMutex
+	drivers    = make(map[string]DriverFactory)
+	protocols  = make(map[string]ProtocolFactory)
+)
+
+// RegisterDriver 注册驱动类型, 一般在驱动包的init中调用
+// 重复注册或factory为nil会panic
+func RegisterDriver(name string, f DriverFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f == nil {
+		panic("access: register driver factory is nil")
+	}
+	if _, dup := drivers[name]; dup {
+		panic("access: register driver twice for " + name)
+	}
+	drivers[name] = f
+}
+
+// RegisterProtocol 注册协议类型, 一般在协议包的init中调用
+// 重复注册或factory为nil会panic
+func RegisterProtocol(name string, f ProtocolFactory) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if f == nil {
+		panic("access: register protocol factory is nil")
+	}
+	if _, dup := protocols[name]; dup {
+		panic("access: register protocol twice for " + name)
+	}
+	protocols[name] = f
+}
+
+// NewDriver 根据注册的类型名创建驱动
+func NewDriver(name string, params Params) (driver.Driver, error) {
+	registryMu.RLock()
+	f, ok := drivers[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("access: unknown driver [%s] (registered: %v)", name, Drivers())
+	}
+	return f(params)
+}
+
+// NewProtocol 根据注册的类型名创建协议
+func NewProtocol(name string, params Params) (driver.Protocol, error) {
+	registryMu.RLock()
+	f, ok := protocols[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("access: unknown protocol [%s] (registered: %v)", name, Protocols())
+	}
+	return f(params)
+}
+
+// Drivers 已注册的驱动类型
+func Drivers() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	ret := make([]string, 0, len(drivers))
+	for name := range drivers {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Protocols 已注册的协议类型
+func Protocols() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	ret := make([]string, 0, len(protocols))
+	for name := range protocols {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret
+}
@@ -0,0 +1,329 @@
+package sim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/codec"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var (
+	// ErrNotConnected 设备未连接
+	ErrNotConnected = errors.New("sim: device not connected")
+	// ErrReplyTimeout 等待平台应答超时
+	ErrReplyTimeout = errors.New("sim: reply timeout")
+)
+
+// Config 模拟设备配置
+type Config struct {
+	Dial              Dialer
+	Script            Script
+	HeartbeatInterval time.Duration // 心跳间隔, <=0不发心跳
+	MeterInterval     time.Duration // 电表上报间隔, <=0不上报
+	ReplyTimeout      time.Duration // 等待平台应答超时, 默认10秒
+	WriteTimeout      time.Duration // 写超时, 默认5秒
+	Reconnect         time.Duration // 断开后重连间隔, <=0不重连
+}
+
+type pending struct {
+	op    string
+	start time.Time
+	done  chan struct{}
+}
+
+// Device 模拟设备
+type Device struct {
+	Mark  string
+	Index int
+
+	cfg   *Config
+	stats *Stats
+
+	mu      sync.Mutex
+	conn    Conn
+	pending map[string]*pending
+	data    map[string]interface{}
+}
+
+// NewDevice 创建模拟设备
+func NewDevice(index int, mark string, cfg *Config, stats *Stats) *Device {
+	c := *cfg
+	if c.ReplyTimeout <= 0 {
+		c.ReplyTimeout = 10 * time.Second
+	}
+	if c.WriteTimeout <= 0 {
+		c.WriteTimeout = 5 * time.Second
+	}
+	return &Device{
+		Mark:    mark,
+		Index:   index,
+		cfg:     &c,
+		stats:   stats,
+		pending: make(map[string]*pending),
+		data:    make(map[string]interface{}),
+	}
+}
+
+// Set 保存脚本数据(如序列号/枪状态)
+func (d *Device) Set(key string, v interface{}) {
+	d.mu.Lock()
+	d.data[key] = v
+	d.mu.Unlock()
+}
+
+// Get 获取脚本数据
+func (d *Device) Get(key string) interface{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.data[key]
+}
+
+// Encode 用脚本的设备侧协议编码上行报文, 协议没有实现 Encoder 时按 codec.Marshal 编码
+func (d *Device) Encode(v interface{}) (driver.Msg, error) {
+	if e, ok := driver.As[Encoder](d.cfg.Script.Protocol()); ok {
+		return e.Encode(d.Mark, v)
+	}
+	b, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return driver.NewMsg(d.Mark, b), nil
+}
+
+// Send 发送上行报文, 设置了Correlator时等待平台应答统计延时
+// 脚本在处理平台命令时可以用它主动上报(如启动充电后上报交易记录)
+func (d *Device) Send(op string, msg driver.Msg) error {
+	_, err := d.send(op, msg)
+	return err
+}
+
+func (d *Device) send(op string, msg driver.Msg) (p *pending, err error) {
+	d.mu.Lock()
+	conn := d.conn
+	d.mu.Unlock()
+	if conn == nil {
+		return nil, ErrNotConnected
+	}
+
+	if corr := d.cfg.Script.Correlator(); corr != nil {
+		key, err := corr.RequestKey(msg)
+		if err != nil {
+			return nil, err
+		}
+		p = &pending{op: op, start: time.Now(), done: make(chan struct{})}
+		d.mu.Lock()
+		d.pending[key] = p
+		d.mu.Unlock()
+	}
+	for _, b := range driver.MsgBytes(msg) {
+		if err := conn.WriteFrame(b, d.cfg.WriteTimeout); err != nil {
+			d.stats.Error(op, err)
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Run 运行设备脚本直到ctx结束, 配置了重连时断开后重连
+func (d *Device) Run(ctx context.Context) {
+	for {
+		if err := d.session(ctx); err != nil && ctx.Err() == nil {
+			d.stats.Error(OpConnect, err)
+		}
+		if d.cfg.Reconnect <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(d.cfg.Reconnect):
+		}
+	}
+}
+
+func (d *Device) session(ctx context.Context) error {
+	start := time.Now()
+	conn, err := d.cfg.Dial(d.cfg.Script.Split())
+	if err != nil {
+		return err
+	}
+	d.stats.Observe(OpConnect, time.Since(start))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	d.mu.Lock()
+	d.conn = conn
+	d.mu.Unlock()
+	defer func() {
+		d.mu.Lock()
+		d.conn = nil
+		d.pending = make(map[string]*pending)
+		d.mu.Unlock()
+		conn.Close()
+	}()
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer cancel()
+		for {
+			frame, err := conn.ReadFrame()
+			if err != nil {
+				readErr <- err
+				return
+			}
+			d.handle(ctx, frame)
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	if err := d.login(ctx); err != nil {
+		d.stats.Error(OpLogin, err)
+		return nil
+	}
+	d.stats.Online(1)
+	defer d.stats.Online(-1)
+
+	heartbeat, meter := d.timer(d.cfg.HeartbeatInterval), d.timer(d.cfg.MeterInterval)
+	defer heartbeat.Stop()
+	defer meter.Stop()
+	sweep := time.NewTicker(d.cfg.ReplyTimeout / 2)
+	defer sweep.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			select {
+			case err := <-readErr:
+				return fmt.Errorf("read: %w", err)
+			default:
+				return nil
+			}
+		case <-heartbeat.C:
+			heartbeat.Reset(d.cfg.HeartbeatInterval)
+			d.emit(OpHeartbeat, d.cfg.Script.Heartbeat)
+		case <-meter.C:
+			meter.Reset(d.cfg.MeterInterval)
+			d.emit(OpMeter, d.cfg.Script.Meter)
+		case now := <-sweep.C:
+			d.expire(now)
+		}
+	}
+}
+
+// timer 周期发送的定时器, 触发后由调用方 Reset(interval); 间隔<=0时返回不会触发的timer
+// 第一次触发按设备序号加上偏移, 打散各设备的发送时间
+func (d *Device) timer(interval time.Duration) *time.Timer {
+	if interval <= 0 {
+		t := time.NewTimer(time.Hour)
+		t.Stop()
+		return t
+	}
+	return time.NewTimer(interval + time.Duration(d.Index%100)*interval/100)
+}
+
+func (d *Device) login(ctx context.Context) error {
+	msg, err := d.cfg.Script.Login(d)
+	if err != nil {
+		return err
+	}
+	p, err := d.send(OpLogin, msg)
+	if err != nil || p == nil {
+		return err
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-p.done:
+		return nil
+	case <-time.After(d.cfg.ReplyTimeout):
+		d.stats.Timeout(OpLogin)
+		return ErrReplyTimeout
+	}
+}
+
+func (d *Device) emit(op string, f func(d *Device) (driver.Msg, error)) {
+	msg, err := f(d)
+	if err != nil {
+		d.stats.Error(op, err)
+		return
+	}
+	if msg == nil {
+		return
+	}
+	if _, err := d.send(op, msg); err != nil {
+		d.stats.Error(op, err)
+	}
+}
+
+func (d *Device) expire(now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, p := range d.pending {
+		if now.Sub(p.start) > d.cfg.ReplyTimeout {
+			delete(d.pending, key)
+			if p.op != OpLogin { // 登录超时在login中统计
+				d.stats.Timeout(p.op)
+			}
+		}
+	}
+}
+
+// handle 处理平台下发的一帧报文
+func (d *Device) handle(ctx context.Context, frame []byte) {
+	start := time.Now()
+	acctx := driver.NewACCtx(d.Mark, "sim", frame)
+	KeyDevice.Set(acctx, d)
+	tos, rets, err := d.cfg.Script.Protocol().Translate(driver.NewContext(ctx, acctx))
+	if aerr := acctx.RunAfterFuncs(); aerr != nil {
+		d.stats.Error(OpCommand, aerr)
+	}
+	if err != nil {
+		if !errors.Is(err, driver.ErrIgnore) {
+			d.stats.Error(OpCommand, err)
+		}
+		return
+	}
+
+	if corr := d.cfg.Script.Correlator(); corr != nil {
+		for _, to := range tos {
+			key, ok := corr.ReplyKey(to)
+			if !ok {
+				continue
+			}
+			d.mu.Lock()
+			p, ok := d.pending[key]
+			delete(d.pending, key)
+			d.mu.Unlock()
+			if ok {
+				d.stats.Observe(p.op, start.Sub(p.start))
+				close(p.done)
+			}
+		}
+	}
+
+	if len(rets) == 0 {
+		return
+	}
+	d.mu.Lock()
+	conn := d.conn
+	d.mu.Unlock()
+	if conn == nil {
+		return
+	}
+	for _, ret := range rets {
+		for _, b := range driver.MsgBytes(ret) {
+			if err := conn.WriteFrame(b, d.cfg.WriteTimeout); err != nil {
+				d.stats.Error(OpCommand, err)
+				return
+			}
+		}
+	}
+	d.stats.Observe(OpCommand, time.Since(start))
+}
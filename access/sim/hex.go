@@ -0,0 +1,276 @@
+package sim
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// HexFrame 十六进制报文模板, {mark} 替换为设备标记的ascii十六进制
+type HexFrame struct {
+	Frame string `json:"frame"`
+	Reply string `json:"reply,omitempty"` // 平台应答的十六进制前缀, 为空表示不等待应答
+}
+
+// HexReply 平台下发报文匹配 Match 前缀时回复 Reply
+type HexReply struct {
+	Match string `json:"match"`
+	Reply string `json:"reply"`
+}
+
+// HexSplit tcp按长度字段分帧, Size为0时一次读取的数据作为一帧
+type HexSplit struct {
+	Offset    int  `json:"offset"`     // 长度字段偏移
+	Size      int  `json:"size"`       // 长度字段字节数 1/2/4
+	Adjust    int  `json:"adjust"`     // 帧总长 = 长度字段值 + Adjust
+	BigEndian bool `json:"big_endian"` // 长度字段大端
+}
+
+// HexFixture 通用十六进制脚本的配置文件(json), 如 68|命令|长度|标记:
+//
+//	{
+//	  "split": {"offset": 2, "size": 1, "adjust": 3},
+//	  "login": {"frame": "68 01 0d {mark}", "reply": "6802"},
+//	  "heartbeat": {"frame": "68 03 0d {mark}", "reply": "6804"},
+//	  "replies": [{"match": "6805", "reply": "68 06 0d {mark}"}]
+//	}
+type HexFixture struct {
+	Split     HexSplit   `json:"split"`
+	Login     HexFrame   `json:"login"`
+	Heartbeat HexFrame   `json:"heartbeat"`
+	Meter     *HexFrame  `json:"meter,omitempty"`
+	Replies   []HexReply `json:"replies,omitempty"`
+}
+
+// HexScript 按十六进制报文模板模拟登录/心跳的通用脚本, 不需要为协议写代码即可压测
+type HexScript struct {
+	fixture HexFixture
+	proto   *hexProtocol
+	corr    driver.Correlator
+}
+
+// LoadHexScript 从json配置文件加载通用脚本
+func LoadHexScript(path string) (*HexScript, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f HexFixture
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("sim: parse %s: %w", path, err)
+	}
+	return NewHexScript(f)
+}
+
+// NewHexScript 创建通用脚本, 校验模板都是合法的十六进制
+// 上行报文要么都配置应答前缀, 要么都不配置
+func NewHexScript(f HexFixture) (*HexScript, error) {
+	frames := []*HexFrame{&f.Login, &f.Heartbeat}
+	if f.Meter != nil {
+		frames = append(frames, f.Meter)
+	}
+	p := &hexProtocol{}
+	waits := 0
+	for _, fr := range frames {
+		if fr.Frame == "" {
+			return nil, errors.New("sim: hex frame is empty")
+		}
+		if _, err := expandHex(fr.Frame, ""); err != nil {
+			return nil, err
+		}
+		if fr.Reply == "" {
+			continue
+		}
+		waits++
+		prefix, err := hex.DecodeString(fr.Reply)
+		if err != nil {
+			return nil, fmt.Errorf("sim: hex reply %q: %w", fr.Reply, err)
+		}
+		p.acks = append(p.acks, prefix)
+	}
+	if waits != 0 && waits != len(frames) {
+		return nil, errors.New("sim: hex frames must all or none have reply")
+	}
+	for _, r := range f.Replies {
+		match, err := hex.DecodeString(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("sim: hex match %q: %w", r.Match, err)
+		}
+		if _, err := expandHex(r.Reply, ""); err != nil {
+			return nil, err
+		}
+		p.replies = append(p.replies, hexReply{match: match, reply: r.Reply})
+	}
+	switch f.Split.Size {
+	case 0, 1, 2, 4:
+	default:
+		return nil, fmt.Errorf("sim: hex split size %d", f.Split.Size)
+	}
+
+	s := &HexScript{fixture: f, proto: p}
+	if waits > 0 {
+		s.corr = hexCorrelator{p}
+	}
+	return s, nil
+}
+
+// Login 登录报文
+func (s *HexScript) Login(d *Device) (driver.Msg, error) {
+	return d.Encode(&s.fixture.Login)
+}
+
+// Heartbeat 心跳报文
+func (s *HexScript) Heartbeat(d *Device) (driver.Msg, error) {
+	return d.Encode(&s.fixture.Heartbeat)
+}
+
+// Meter 电表数据报文, 没有配置时不上报
+func (s *HexScript) Meter(d *Device) (driver.Msg, error) {
+	if s.fixture.Meter == nil {
+		return nil, nil
+	}
+	return d.Encode(s.fixture.Meter)
+}
+
+// Protocol 设备侧协议
+func (s *HexScript) Protocol() driver.Protocol { return s.proto }
+
+// Correlator 按应答前缀关联
+func (s *HexScript) Correlator() driver.Correlator { return s.corr }
+
+// Split 按长度字段分帧
+func (s *HexScript) Split() bufio.SplitFunc {
+	sp := s.fixture.Split
+	if sp.Size == 0 {
+		return func(data []byte, atEOF bool) (int, []byte, error) {
+			if len(data) == 0 {
+				return 0, nil, nil
+			}
+			return len(data), data, nil
+		}
+	}
+	return func(data []byte, atEOF bool) (int, []byte, error) {
+		if len(data) < sp.Offset+sp.Size {
+			return 0, nil, nil
+		}
+		field := data[sp.Offset : sp.Offset+sp.Size]
+		var order binary.ByteOrder = binary.LittleEndian
+		if sp.BigEndian {
+			order = binary.BigEndian
+		}
+		var l int
+		switch sp.Size {
+		case 1:
+			l = int(field[0])
+		case 2:
+			l = int(order.Uint16(field))
+		case 4:
+			l = int(order.Uint32(field))
+		}
+		l += sp.Adjust
+		if l <= 0 {
+			return 0, nil, fmt.Errorf("sim: bad frame length %d", l)
+		}
+		if len(data) < l {
+			return 0, nil, nil
+		}
+		return l, data[:l], nil
+	}
+}
+
+// expandHex 替换模板中的 {mark} 后解码
+func expandHex(tpl, mark string) ([]byte, error) {
+	s := strings.ReplaceAll(tpl, "{mark}", hex.EncodeToString([]byte(mark)))
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	if err != nil {
+		return nil, fmt.Errorf("sim: hex frame %q: %w", tpl, err)
+	}
+	return b, nil
+}
+
+type hexReply struct {
+	match []byte
+	reply string
+}
+
+type hexProtocol struct {
+	acks    [][]byte
+	replies []hexReply
+}
+
+// Encode 编码 *HexFrame 模板, 期望的应答前缀放在消息源数据中用于关联
+func (p *hexProtocol) Encode(mark string, v interface{}) (driver.Msg, error) {
+	fr, ok := v.(*HexFrame)
+	if !ok {
+		return nil, fmt.Errorf("sim: hex protocol can not encode %T", v)
+	}
+	b, err := expandHex(fr.Frame, mark)
+	if err != nil {
+		return nil, err
+	}
+	reply, err := hex.DecodeString(fr.Reply)
+	if err != nil {
+		return nil, err
+	}
+	return driver.NewMsg(mark, b, hex.EncodeToString(reply)), nil
+}
+
+// Translate 平台下发的报文作为tos用于关联应答, 匹配 Replies 的回复作为rets
+func (p *hexProtocol) Translate(ctx context.Context) (tos []driver.Msg, rets []driver.Msg, err error) {
+	acctx := driver.FromContext(ctx)
+	frame, ok := acctx.Raw.([]byte)
+	if !ok {
+		return nil, nil, driver.ErrIgnore
+	}
+	tos = append(tos, driver.NewMsg(acctx.Mark, frame))
+	for _, r := range p.replies {
+		if !bytes.HasPrefix(frame, r.match) {
+			continue
+		}
+		b, err := expandHex(r.reply, acctx.Mark)
+		if err != nil {
+			return nil, nil, err
+		}
+		rets = append(rets, driver.NewMsg(acctx.Mark, b))
+	}
+	return tos, rets, nil
+}
+
+// hexCorrelator 上行报文按模板的应答前缀关联, 平台报文按最长匹配的应答前缀关联
+type hexCorrelator struct {
+	p *hexProtocol
+}
+
+func (c hexCorrelator) RequestKey(msg driver.Msg) (string, error) {
+	key, _ := msg.GetSource().(string)
+	if key == "" {
+		return "", errors.New("sim: hex request without reply prefix")
+	}
+	return key, nil
+}
+
+func (c hexCorrelator) ReplyKey(msg driver.Msg) (string, bool) {
+	bs := driver.MsgBytes(msg)
+	if len(bs) != 1 {
+		return "", false
+	}
+	var best []byte
+	for _, ack := range c.p.acks {
+		if bytes.HasPrefix(bs[0], ack) && len(ack) > len(best) {
+			best = ack
+		}
+	}
+	if best == nil {
+		return "", false
+	}
+	return hex.EncodeToString(best), true
+}
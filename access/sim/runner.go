@@ -0,0 +1,84 @@
+package sim
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Runner 批量运行模拟设备
+//
+//	s, _ := sim.GetScript("ykc")
+//	r := sim.NewRunner(&sim.Config{Dial: sim.TCPDialer("127.0.0.1:8767", 5*time.Second), Script: s,
+//		HeartbeatInterval: 10 * time.Second, MeterInterval: 15 * time.Second})
+//	r.SetCount(50000).SetRampUp(500)
+//	go r.Run(ctx)
+//	fmt.Println(r.Stats())
+type Runner struct {
+	cfg        *Config
+	count      int
+	rampUp     float64
+	markFormat string
+	stats      *Stats
+}
+
+// NewRunner 创建批量运行, 默认1个设备, 每秒启动100个, 设备标记格式 SIM%010d
+func NewRunner(cfg *Config) *Runner {
+	return &Runner{
+		cfg:        cfg,
+		count:      1,
+		rampUp:     100,
+		markFormat: "SIM%010d",
+		stats:      NewStats(),
+	}
+}
+
+// SetCount 设备数
+func (r *Runner) SetCount(n int) *Runner {
+	r.count = n
+	return r
+}
+
+// SetRampUp 每秒启动的设备数, <=0表示同时启动
+func (r *Runner) SetRampUp(perSecond float64) *Runner {
+	r.rampUp = perSecond
+	return r
+}
+
+// SetMarkFormat 设备标记格式, 参数为设备序号
+func (r *Runner) SetMarkFormat(format string) *Runner {
+	r.markFormat = format
+	return r
+}
+
+// Stats 统计数据
+func (r *Runner) Stats() *Stats {
+	return r.stats
+}
+
+// Run 启动所有设备, ctx结束后等待所有设备退出
+func (r *Runner) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	defer wg.Wait()
+
+	var interval time.Duration
+	if r.rampUp > 0 {
+		interval = time.Duration(float64(time.Second) / r.rampUp)
+	}
+	for i := 0; i < r.count; i++ {
+		if interval > 0 && i > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+		d := NewDevice(i, fmt.Sprintf(r.markFormat, i), r.cfg, r.stats)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.Run(ctx)
+		}()
+	}
+}
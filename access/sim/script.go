@@ -0,0 +1,87 @@
+package sim
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Script 模拟设备的协议脚本
+// 上行报文(登录/心跳/电表)由脚本通过 Device.Encode 编码成 driver.Msg, 平台下发的报文交给设备侧的 driver.Protocol 翻译:
+// rets 作为设备的应答发回平台, tos 作为收到的平台应答, 通过 Correlator 和上行请求关联统计延时
+type Script interface {
+	// Login 登录报文
+	Login(d *Device) (driver.Msg, error)
+
+	// Heartbeat 心跳报文
+	Heartbeat(d *Device) (driver.Msg, error)
+
+	// Meter 电表数据报文, 返回nil表示本次不上报
+	Meter(d *Device) (driver.Msg, error)
+
+	// Protocol 设备侧协议, Ctx.Raw为平台下发的一帧报文, KeyDevice为模拟设备
+	Protocol() driver.Protocol
+
+	// Correlator 上行请求和平台应答的关联, 返回nil表示不等待应答(登录发送成功即认为成功)
+	Correlator() driver.Correlator
+
+	// Split tcp分帧, websocket一条消息为一帧
+	Split() bufio.SplitFunc
+}
+
+// Encoder 设备侧协议可选实现, 把脚本构造的报文结构编码成上行帧
+// 未实现时 Device.Encode 用 codec.Marshal 编码
+type Encoder interface {
+	Encode(mark string, v interface{}) (driver.Msg, error)
+}
+
+// CtxDataDevice 设备侧协议上下文中的模拟设备
+const CtxDataDevice = "sim_device"
+
+// KeyDevice 设备侧协议上下文中的模拟设备
+var KeyDevice = driver.NewKey[*Device](CtxDataDevice)
+
+var (
+	scriptsMu sync.RWMutex
+	scripts   = make(map[string]Script)
+)
+
+// RegisterScript 注册协议脚本, 一般在脚本包的init中调用
+// 重复注册或s为nil会panic
+func RegisterScript(name string, s Script) {
+	scriptsMu.Lock()
+	defer scriptsMu.Unlock()
+	if s == nil {
+		panic("sim: register script " + name + " is nil")
+	}
+	if _, ok := scripts[name]; ok {
+		panic("sim: register script " + name + " twice")
+	}
+	scripts[name] = s
+}
+
+// GetScript 获取注册的协议脚本
+func GetScript(name string) (Script, error) {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+	s, ok := scripts[name]
+	if !ok {
+		return nil, fmt.Errorf("sim: unknown script %q", name)
+	}
+	return s, nil
+}
+
+// Scripts 已注册的协议脚本
+func Scripts() []string {
+	scriptsMu.RLock()
+	defer scriptsMu.RUnlock()
+	names := make([]string, 0, len(scripts))
+	for name := range scripts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
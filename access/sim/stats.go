@@ -0,0 +1,175 @@
+package sim
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 操作类型
+const (
+	OpConnect   = "connect"
+	OpLogin     = "login"
+	OpHeartbeat = "heartbeat"
+	OpMeter     = "meter"
+	OpCommand   = "command" // 应答平台下发的命令
+)
+
+// latencyBounds 延时分桶上限(毫秒), 最后一个桶为溢出
+var latencyBounds = []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000, 2000, 5000, 10000, 30000}
+
+type opStats struct {
+	count   uint64
+	errors  uint64
+	timeout uint64
+	sum     time.Duration
+	min     time.Duration
+	max     time.Duration
+	buckets []uint64
+}
+
+// OpReport 单个操作的统计
+type OpReport struct {
+	Op      string        `json:"op"`
+	Count   uint64        `json:"count"`
+	Errors  uint64        `json:"errors"`
+	Timeout uint64        `json:"timeout"`
+	Avg     time.Duration `json:"avg"`
+	Min     time.Duration `json:"min"`
+	Max     time.Duration `json:"max"`
+	P50     time.Duration `json:"p50"`
+	P90     time.Duration `json:"p90"`
+	P99     time.Duration `json:"p99"`
+}
+
+// Stats 延时和错误统计
+type Stats struct {
+	mu     sync.Mutex
+	ops    map[string]*opStats
+	online int64
+	errs   map[string]uint64 // 错误信息 -> 次数
+}
+
+// NewStats 创建统计
+func NewStats() *Stats {
+	return &Stats{ops: make(map[string]*opStats), errs: make(map[string]uint64)}
+}
+
+func (s *Stats) op(name string) *opStats {
+	o, ok := s.ops[name]
+	if !ok {
+		o = &opStats{min: math.MaxInt64, buckets: make([]uint64, len(latencyBounds)+1)}
+		s.ops[name] = o
+	}
+	return o
+}
+
+// Observe 记录一次成功的操作和延时
+func (s *Stats) Observe(op string, d time.Duration) {
+	ms := float64(d) / float64(time.Millisecond)
+	idx := sort.SearchFloat64s(latencyBounds, ms)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o := s.op(op)
+	o.count++
+	o.sum += d
+	if d < o.min {
+		o.min = d
+	}
+	if d > o.max {
+		o.max = d
+	}
+	o.buckets[idx]++
+}
+
+// Error 记录一次失败的操作
+func (s *Stats) Error(op string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.op(op).errors++
+	msg := err.Error()
+	if len(s.errs) < 100 {
+		s.errs[op+": "+msg]++
+	} else if _, ok := s.errs[op+": "+msg]; ok {
+		s.errs[op+": "+msg]++
+	}
+}
+
+// Timeout 记录一次应答超时
+func (s *Stats) Timeout(op string) {
+	s.mu.Lock()
+	s.op(op).timeout++
+	s.mu.Unlock()
+}
+
+// Online 在线设备数增减
+func (s *Stats) Online(delta int64) {
+	s.mu.Lock()
+	s.online += delta
+	s.mu.Unlock()
+}
+
+func (o *opStats) quantile(q float64) time.Duration {
+	target := uint64(math.Ceil(float64(o.count) * q))
+	var n uint64
+	for i, c := range o.buckets {
+		n += c
+		if n >= target {
+			if i >= len(latencyBounds) {
+				return o.max
+			}
+			d := time.Duration(latencyBounds[i] * float64(time.Millisecond))
+			if d > o.max {
+				return o.max
+			}
+			return d
+		}
+	}
+	return o.max
+}
+
+// Report 各操作统计, 按操作名排序
+func (s *Stats) Report() (online int64, ops []OpReport, errs map[string]uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, o := range s.ops {
+		r := OpReport{Op: name, Count: o.count, Errors: o.errors, Timeout: o.timeout}
+		if o.count > 0 {
+			r.Avg = o.sum / time.Duration(o.count)
+			r.Min, r.Max = o.min, o.max
+			r.P50, r.P90, r.P99 = o.quantile(0.5), o.quantile(0.9), o.quantile(0.99)
+		}
+		ops = append(ops, r)
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].Op < ops[j].Op })
+	errs = make(map[string]uint64, len(s.errs))
+	for k, v := range s.errs {
+		errs[k] = v
+	}
+	return s.online, ops, errs
+}
+
+// String 文本格式的统计, 分位数为分桶上限的近似值
+func (s *Stats) String() string {
+	online, ops, errs := s.Report()
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "online=%d\n", online)
+	fmt.Fprintf(b, "%-10s %10s %8s %8s %10s %10s %10s %10s %10s\n", "op", "count", "errors", "timeout", "avg", "p50", "p90", "p99", "max")
+	for _, r := range ops {
+		fmt.Fprintf(b, "%-10s %10d %8d %8d %10s %10s %10s %10s %10s\n", r.Op, r.Count, r.Errors, r.Timeout,
+			r.Avg.Round(time.Microsecond), r.P50, r.P90, r.P99, r.Max.Round(time.Microsecond))
+	}
+	keys := make([]string, 0, len(errs))
+	for k := range errs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(b, "  %6d  %s\n", errs[k], k)
+	}
+	return b.String()
+}
@@ -0,0 +1,92 @@
+package sim
+
+import (
+	"bufio"
+	"net"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// Conn 模拟设备到接入层的连接
+type Conn interface {
+	// ReadFrame 读取一帧报文
+	ReadFrame() ([]byte, error)
+	// WriteFrame 写入一帧报文
+	WriteFrame(b []byte, timeout time.Duration) error
+	Close() error
+}
+
+// Dialer 建立连接
+type Dialer func(split bufio.SplitFunc) (Conn, error)
+
+// TCPDialer tcp连接, 按脚本的Split分帧
+func TCPDialer(addr string, timeout time.Duration) Dialer {
+	return func(split bufio.SplitFunc) (Conn, error) {
+		c, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(c)
+		sc.Buffer(make([]byte, 4096), 1<<20)
+		if split != nil {
+			sc.Split(split)
+		}
+		return &tcpConn{Conn: c, sc: sc}, nil
+	}
+}
+
+type tcpConn struct {
+	net.Conn
+	sc *bufio.Scanner
+}
+
+func (c *tcpConn) ReadFrame() ([]byte, error) {
+	if !c.sc.Scan() {
+		if err := c.sc.Err(); err != nil {
+			return nil, err
+		}
+		return nil, net.ErrClosed
+	}
+	return append([]byte(nil), c.sc.Bytes()...), nil
+}
+
+func (c *tcpConn) WriteFrame(b []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		c.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	_, err := c.Write(b)
+	return err
+}
+
+// WSDialer websocket连接, 二进制消息
+// subprotocol 如 ocpp1.6, 为空不设置
+func WSDialer(url, subprotocol, origin string) Dialer {
+	return func(bufio.SplitFunc) (Conn, error) {
+		ws, err := websocket.Dial(url, subprotocol, origin)
+		if err != nil {
+			return nil, err
+		}
+		return &wsConn{ws: ws}, nil
+	}
+}
+
+type wsConn struct {
+	ws *websocket.Conn
+}
+
+func (c *wsConn) ReadFrame() (b []byte, err error) {
+	err = websocket.Message.Receive(c.ws, &b)
+	return
+}
+
+func (c *wsConn) WriteFrame(b []byte, timeout time.Duration) error {
+	if timeout > 0 {
+		c.ws.SetWriteDeadline(time.Now().Add(timeout))
+	}
+	return websocket.Message.Send(c.ws, b)
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
@@ -0,0 +1,102 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// sessionValuePeerMarks 会话私有数据中保存客户端证书允许的设备标记
+const sessionValuePeerMarks = "tls_peer_marks"
+
+// CtxDataPeerMarks 没有会话的驱动把客户端证书允许的设备标记写入 Ctx.Data
+const CtxDataPeerMarks = "tls_peer_marks"
+
+// KeyPeerMarks 客户端证书允许的设备标记
+var KeyPeerMarks = driver.NewKey[[]string](CtxDataPeerMarks)
+
+// MarkFunc 从客户端证书获取允许登录的设备标记
+type MarkFunc func(cert *x509.Certificate) []string
+
+// DefaultMarks 证书CN和SAN(DNS/email/URI)
+func DefaultMarks(cert *x509.Certificate) []string {
+	marks := make([]string, 0, 1+len(cert.DNSNames))
+	if cert.Subject.CommonName != "" {
+		marks = append(marks, cert.Subject.CommonName)
+	}
+	marks = append(marks, cert.DNSNames...)
+	marks = append(marks, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		marks = append(marks, u.String())
+	}
+	return marks
+}
+
+// SetMarkFunc 设置证书到设备标记的映射
+func (m *Manager) SetMarkFunc(f MarkFunc) *Manager {
+	m.markFunc = f
+	return m
+}
+
+// PeerMarks 连接的客户端证书允许的设备标记, 没有客户端证书返回nil
+func (m *Manager) PeerMarks(cs tls.ConnectionState) []string {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+	return m.markFunc(cs.PeerCertificates[0])
+}
+
+// Bind 握手完成后把客户端证书允许的设备标记保存到会话
+func (m *Manager) Bind(s *driver.Session, conn *tls.Conn) error {
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	if marks := m.PeerMarks(conn.ConnectionState()); marks != nil {
+		s.SetValue(sessionValuePeerMarks, marks)
+	}
+	return nil
+}
+
+func peerMarks(acctx *driver.Ctx) []string {
+	if s := acctx.GetSession(); s != nil {
+		if v, ok := s.Value(sessionValuePeerMarks); ok {
+			return v.([]string)
+		}
+	}
+	return KeyPeerMarks.Value(acctx)
+}
+
+// Interceptor 翻译拦截器, 登录的设备标记和客户端证书不一致时断开连接
+// 没有客户端证书的连接不检查
+func (m *Manager) Interceptor() driver.TranslateInterceptor {
+	check := func(acctx *driver.Ctx) error {
+		marks := peerMarks(acctx)
+		if marks == nil || acctx.Mark == "" {
+			return nil
+		}
+		for _, mark := range marks {
+			if mark == acctx.Mark {
+				return nil
+			}
+		}
+		tlsCounter.WithLabelValues(m.name, "mark_mismatch").Inc()
+		acctx.Log.Warnf("tls: mark %s not in client certificate %v", acctx.Mark, marks)
+		return fmt.Errorf("%w: mark %s mismatch client certificate", driver.ErrKick, acctx.Mark)
+	}
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		if err = check(acctx); err != nil {
+			return nil, nil, err
+		}
+		if tos, rets, err = handler(ctx); err != nil {
+			return
+		}
+		// 登录报文翻译后才有设备标记
+		if err = check(acctx); err != nil {
+			return nil, nil, err
+		}
+		return
+	}
+}
@@ -0,0 +1,336 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrRevoked 客户端证书已吊销
+	ErrRevoked = errors.New("tlsconf: certificate revoked")
+	// ErrNoCertificate 没有可用的服务端证书
+	ErrNoCertificate = errors.New("tlsconf: no certificate")
+
+	tlsCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_tls_total",
+		Help: "tls termination count by name/event(handshake_revoked/mark_mismatch/reload_ok/reload_error)",
+	}, []string{"name", "event"})
+)
+
+// Cert 证书文件
+// ServerNames 为该证书服务的SNI, 支持 *.example.com, 为空时从证书的SAN中获取
+type Cert struct {
+	CertFile    string   `yaml:"cert_file"`
+	KeyFile     string   `yaml:"key_file"`
+	ServerNames []string `yaml:"server_names"`
+}
+
+// Config tls配置
+type Config struct {
+	Certs        []Cert `yaml:"certs"`          // 第一个为默认证书(SNI没有匹配或客户端没有SNI)
+	ClientCAFile string `yaml:"client_ca_file"` // 客户端证书CA, 为空表示不要求客户端证书
+	ClientAuth   bool   `yaml:"client_auth"`    // true 必须提供客户端证书, false 提供了才校验
+	CRLFile      string `yaml:"crl_file"`       // 证书吊销列表(PEM或DER), 为空不检查
+	MinVersion   uint16 `yaml:"min_version"`    // 默认TLS1.2
+}
+
+type state struct {
+	certs    map[string]*tls.Certificate // server name -> cert
+	def      *tls.Certificate
+	clientCA *x509.CertPool
+	revoked  map[string]struct{} // issuer + serial
+	modTimes map[string]time.Time
+}
+
+// Manager tls证书管理, 支持证书/CA/CRL热加载
+// 加载失败时保留旧的配置; 已经建立的连接不受影响, 新连接使用新证书
+//
+//	m, err := tlsconf.NewManager("ykc", tlsconf.Config{Certs: []tlsconf.Cert{{CertFile: "server.pem", KeyFile: "server.key"}},
+//		ClientCAFile: "ca.pem", ClientAuth: true, CRLFile: "ca.crl"})
+//	go m.Run(ctx)
+//	ln := tls.NewListener(rawLn, m.TLSConfig())
+type Manager struct {
+	name string
+	cfg  Config
+	log  *logrus.Entry
+
+	mu    sync.RWMutex
+	state *state
+
+	markFunc MarkFunc
+}
+
+// NewManager 创建证书管理并加载证书
+func NewManager(name string, cfg Config) (*Manager, error) {
+	if len(cfg.Certs) == 0 {
+		return nil, ErrNoCertificate
+	}
+	if cfg.MinVersion == 0 {
+		cfg.MinVersion = tls.VersionTLS12
+	}
+	m := &Manager{
+		name:     name,
+		cfg:      cfg,
+		log:      logrus.WithField("module", "tlsconf").WithField("name", name),
+		markFunc: DefaultMarks,
+	}
+	if err := m.Reload(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Manager) files() []string {
+	var files []string
+	for _, c := range m.cfg.Certs {
+		files = append(files, c.CertFile, c.KeyFile)
+	}
+	if m.cfg.ClientCAFile != "" {
+		files = append(files, m.cfg.ClientCAFile)
+	}
+	if m.cfg.CRLFile != "" {
+		files = append(files, m.cfg.CRLFile)
+	}
+	return files
+}
+
+// Reload 重新加载所有文件
+func (m *Manager) Reload() error {
+	st, err := m.load()
+	if err != nil {
+		tlsCounter.WithLabelValues(m.name, "reload_error").Inc()
+		return err
+	}
+	m.mu.Lock()
+	m.state = st
+	m.mu.Unlock()
+	tlsCounter.WithLabelValues(m.name, "reload_ok").Inc()
+	return nil
+}
+
+func (m *Manager) load() (*state, error) {
+	st := &state{
+		certs:    make(map[string]*tls.Certificate),
+		revoked:  make(map[string]struct{}),
+		modTimes: make(map[string]time.Time),
+	}
+	for _, f := range m.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+		st.modTimes[f] = fi.ModTime()
+	}
+
+	for i, c := range m.cfg.Certs {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("tlsconf: load %s: %w", c.CertFile, err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return nil, fmt.Errorf("tlsconf: parse %s: %w", c.CertFile, err)
+		}
+		if i == 0 {
+			st.def = &cert
+		}
+		names := c.ServerNames
+		if len(names) == 0 {
+			names = cert.Leaf.DNSNames
+		}
+		for _, name := range names {
+			st.certs[strings.ToLower(name)] = &cert
+		}
+	}
+
+	if m.cfg.ClientCAFile == "" {
+		return st, nil
+	}
+	b, err := ioutil.ReadFile(m.cfg.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	st.clientCA = x509.NewCertPool()
+	var cas []*x509.Certificate
+	for rest := b; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("tlsconf: parse client ca: %w", err)
+		}
+		st.clientCA.AddCert(ca)
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, fmt.Errorf("tlsconf: no certificate in %s", m.cfg.ClientCAFile)
+	}
+
+	if m.cfg.CRLFile != "" {
+		if err := loadCRL(m.cfg.CRLFile, cas, st.revoked); err != nil {
+			return nil, err
+		}
+	}
+	return st, nil
+}
+
+func revokedKey(issuer []byte, serial *big.Int) string {
+	return string(issuer) + "|" + serial.String()
+}
+
+// loadCRL 加载吊销列表, 吊销列表需要由客户端CA签发
+func loadCRL(path string, cas []*x509.Certificate, revoked map[string]struct{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var ders [][]byte
+	for rest := b; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		ders = append(ders, block.Bytes)
+	}
+	if len(ders) == 0 {
+		ders = append(ders, b)
+	}
+
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return fmt.Errorf("tlsconf: parse crl: %w", err)
+		}
+		var issuer *x509.Certificate
+		for _, ca := range cas {
+			if crl.CheckSignatureFrom(ca) == nil {
+				issuer = ca
+				break
+			}
+		}
+		if issuer == nil {
+			return fmt.Errorf("tlsconf: crl %s not signed by client ca", path)
+		}
+		for _, rc := range crl.RevokedCertificates {
+			revoked[revokedKey(issuer.RawSubject, rc.SerialNumber)] = struct{}{}
+		}
+	}
+	return nil
+}
+
+func (m *Manager) current() *state {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.state
+}
+
+// getCertificate 按SNI选择证书, 支持一级通配符
+func (m *Manager) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	st := m.current()
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if name != "" {
+		if c, ok := st.certs[name]; ok {
+			return c, nil
+		}
+		if idx := strings.IndexByte(name, '.'); idx > 0 {
+			if c, ok := st.certs["*"+name[idx:]]; ok {
+				return c, nil
+			}
+		}
+	}
+	if st.def == nil {
+		return nil, ErrNoCertificate
+	}
+	return st.def, nil
+}
+
+// verifyPeer 检查客户端证书是否吊销
+func (m *Manager) verifyPeer(rawCerts [][]byte, chains [][]*x509.Certificate) error {
+	st := m.current()
+	if len(st.revoked) == 0 {
+		return nil
+	}
+	for _, chain := range chains {
+		for _, c := range chain {
+			if _, ok := st.revoked[revokedKey(c.RawIssuer, c.SerialNumber)]; ok {
+				tlsCounter.WithLabelValues(m.name, "handshake_revoked").Inc()
+				return fmt.Errorf("%w: serial %s", ErrRevoked, c.SerialNumber)
+			}
+		}
+	}
+	return nil
+}
+
+// TLSConfig 监听使用的tls配置, 每次握手使用最新加载的证书/CA
+func (m *Manager) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     m.cfg.MinVersion,
+		GetCertificate: m.getCertificate,
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			st := m.current()
+			c := &tls.Config{
+				MinVersion:     m.cfg.MinVersion,
+				GetCertificate: m.getCertificate,
+			}
+			if st.clientCA != nil {
+				c.ClientCAs = st.clientCA
+				c.ClientAuth = tls.VerifyClientCertIfGiven
+				if m.cfg.ClientAuth {
+					c.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+				c.VerifyPeerCertificate = m.verifyPeer
+			}
+			return c, nil
+		},
+	}
+}
+
+// Run 定时检查文件修改时间, 有变化时重新加载
+func (m *Manager) Run(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		if !m.changed() {
+			continue
+		}
+		if err := m.Reload(); err != nil {
+			m.log.Errorf("reload: %v", err)
+			continue
+		}
+		m.log.Infof("reloaded")
+	}
+}
+
+func (m *Manager) changed() bool {
+	st := m.current()
+	for _, f := range m.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+		if !fi.ModTime().Equal(st.modTimes[f]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,519 @@
+package tlsconf
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// testCA 测试用CA, 证书和密钥都在内存中生成
+type testCA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	serial int64
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key, serial: 1}
+}
+
+// issue 签发证书, 返回证书和密钥
+func (ca *testCA) issue(t *testing.T, cn string, dns []string, client bool) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca.serial++
+	usage := x509.ExtKeyUsageServerAuth
+	if client {
+		usage = x509.ExtKeyUsageClientAuth
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(ca.serial),
+		Subject:      pkix.Name{CommonName: cn},
+		DNSNames:     dns,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// crl 吊销列表(DER)
+func (ca *testCA) crl(t *testing.T, serials ...*big.Int) []byte {
+	t.Helper()
+	tmpl := &x509.RevocationList{
+		Number:     big.NewInt(time.Now().UnixNano()),
+		ThisUpdate: time.Now().Add(-time.Minute),
+		NextUpdate: time.Now().Add(time.Hour),
+	}
+	for _, s := range serials {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: s, RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func pemBytes(typ string, der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der})
+}
+
+func writeFile(t *testing.T, path string, b []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, b, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeKeyPair 写入证书和密钥文件
+func writeKeyPair(t *testing.T, dir, name string, cert *x509.Certificate, key *ecdsa.PrivateKey) Cert {
+	t.Helper()
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Cert{CertFile: filepath.Join(dir, name+".pem"), KeyFile: filepath.Join(dir, name+".key")}
+	writeFile(t, c.CertFile, pemBytes("CERTIFICATE", cert.Raw))
+	writeFile(t, c.KeyFile, pemBytes("EC PRIVATE KEY", kb))
+	return c
+}
+
+func clientCert(cert *x509.Certificate, key *ecdsa.PrivateKey) tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{cert.Raw}, PrivateKey: key, Leaf: cert}
+}
+
+// dial 建立一个本地tls连接, 返回服务端连接(已握手)和客户端连接
+// 服务端握手失败时返回服务端的错误
+func dial(t *testing.T, m *Manager, cli *tls.Config) (*tls.Conn, *tls.Conn, error) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	type result struct {
+		conn *tls.Conn
+		err  error
+	}
+	cc := make(chan result, 1)
+	go func() {
+		c, err := tls.Dial("tcp", ln.Addr().String(), cli)
+		cc <- result{c, err}
+	}()
+	raw, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := tls.Server(raw, m.TLSConfig())
+	raw.SetDeadline(time.Now().Add(5 * time.Second))
+	err = sc.Handshake()
+	raw.SetDeadline(time.Time{})
+	cr := <-cc
+	if err != nil {
+		sc.Close()
+		if cr.conn != nil {
+			cr.conn.Close()
+		}
+		return nil, nil, err
+	}
+	if cr.err != nil {
+		sc.Close()
+		t.Fatalf("client handshake: %v", cr.err)
+	}
+	t.Cleanup(func() {
+		sc.Close()
+		cr.conn.Close()
+	})
+	return sc, cr.conn, nil
+}
+
+// fixture 一个CA, 默认服务端证书 a.example.com, 通配证书 *.b.example.com, 一个客户端证书 dev1
+type fixture struct {
+	dir      string
+	ca       *testCA
+	cfg      Config
+	def      *x509.Certificate
+	wildcard *x509.Certificate
+	client   tls.Certificate
+	roots    *x509.CertPool
+}
+
+func newFixture(t *testing.T) *fixture {
+	t.Helper()
+	f := &fixture{dir: t.TempDir(), ca: newTestCA(t), roots: x509.NewCertPool()}
+	f.roots.AddCert(f.ca.cert)
+
+	var key *ecdsa.PrivateKey
+	f.def, key = f.ca.issue(t, "a.example.com", []string{"a.example.com"}, false)
+	c1 := writeKeyPair(t, f.dir, "a", f.def, key)
+	f.wildcard, key = f.ca.issue(t, "b.example.com", []string{"*.b.example.com"}, false)
+	c2 := writeKeyPair(t, f.dir, "b", f.wildcard, key)
+
+	cert, key := f.ca.issue(t, "dev1", []string{"dev1.local"}, true)
+	f.client = clientCert(cert, key)
+
+	f.cfg = Config{
+		Certs:        []Cert{c1, c2},
+		ClientCAFile: filepath.Join(f.dir, "ca.pem"),
+		ClientAuth:   true,
+	}
+	writeFile(t, f.cfg.ClientCAFile, pemBytes("CERTIFICATE", f.ca.cert.Raw))
+	return f
+}
+
+func (f *fixture) clientConfig(serverName string, cert ...tls.Certificate) *tls.Config {
+	return &tls.Config{ServerName: serverName, RootCAs: f.roots, Certificates: cert}
+}
+
+func TestSNI(t *testing.T) {
+	f := newFixture(t)
+	m, err := NewManager("sni", f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		want *x509.Certificate
+	}{
+		{"a.example.com", f.def},
+		{"A.Example.com.", f.def},
+		{"x.b.example.com", f.wildcard},
+		{"b.example.com", f.def}, // 通配符只匹配一级
+		{"x.y.b.example.com", f.def},
+		{"unknown.test", f.def},
+		{"", f.def},
+	}
+	for _, c := range cases {
+		got, err := m.getCertificate(&tls.ClientHelloInfo{ServerName: c.name})
+		if err != nil {
+			t.Fatalf("%q: %v", c.name, err)
+		}
+		if got.Leaf.SerialNumber.Cmp(c.want.SerialNumber) != 0 {
+			t.Errorf("%q: got cert %s, want %s", c.name, got.Leaf.Subject.CommonName, c.want.Subject.CommonName)
+		}
+	}
+
+	// 真实握手, 客户端校验服务端证书的SAN
+	_, cc, err := dial(t, m, f.clientConfig("dev.b.example.com", f.client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cc.ConnectionState().PeerCertificates[0]; got.SerialNumber.Cmp(f.wildcard.SerialNumber) != 0 {
+		t.Errorf("handshake got cert %s, want wildcard", got.Subject.CommonName)
+	}
+}
+
+func TestServerNamesOverrideSAN(t *testing.T) {
+	f := newFixture(t)
+	f.cfg.Certs[1].ServerNames = []string{"charger.test"}
+	m, err := NewManager("names", f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, _ := m.getCertificate(&tls.ClientHelloInfo{ServerName: "charger.test"})
+	if got.Leaf.SerialNumber.Cmp(f.wildcard.SerialNumber) != 0 {
+		t.Errorf("server_names not used")
+	}
+	got, _ = m.getCertificate(&tls.ClientHelloInfo{ServerName: "x.b.example.com"})
+	if got.Leaf.SerialNumber.Cmp(f.def.SerialNumber) != 0 {
+		t.Errorf("SAN should be ignored when server_names set")
+	}
+}
+
+func TestClientAuthRequired(t *testing.T) {
+	f := newFixture(t)
+	m, err := NewManager("auth", f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := dial(t, m, f.clientConfig("a.example.com")); err == nil {
+		t.Fatal("handshake without client certificate should fail")
+	}
+
+	// 其它CA签发的客户端证书
+	other := newTestCA(t)
+	cert, key := other.issue(t, "dev1", nil, true)
+	if _, _, err := dial(t, m, f.clientConfig("a.example.com", clientCert(cert, key))); err == nil {
+		t.Fatal("handshake with untrusted client certificate should fail")
+	}
+}
+
+func TestDefaultMarks(t *testing.T) {
+	ca := newTestCA(t)
+	cert, _ := ca.issue(t, "dev1", []string{"dev1.local"}, true)
+	cert.EmailAddresses = []string{"dev1@example.com"}
+	u, _ := url.Parse("urn:charger:dev1")
+	cert.URIs = []*url.URL{u}
+
+	want := []string{"dev1", "dev1.local", "dev1@example.com", "urn:charger:dev1"}
+	got := DefaultMarks(cert)
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+
+	cert.Subject.CommonName = ""
+	if got := DefaultMarks(cert); len(got) != 3 || got[0] != "dev1.local" {
+		t.Errorf("empty CN: got %v", got)
+	}
+}
+
+func TestBindAndInterceptor(t *testing.T) {
+	f := newFixture(t)
+	m, err := NewManager("mark", f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, _, err := dial(t, m, f.clientConfig("a.example.com", f.client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := m.PeerMarks(sc.ConnectionState()); len(got) != 2 || got[0] != "dev1" || got[1] != "dev1.local" {
+		t.Fatalf("peer marks: %v", got)
+	}
+
+	s := driver.NewSessionManager("mark", driver.DupKickOld).Open("1", sc.RemoteAddr().String(), nil)
+	if err := m.Bind(s, sc); err != nil {
+		t.Fatal(err)
+	}
+
+	translate := func(mark string) error {
+		acctx := driver.NewACCtx("", "mark", []byte{0x68})
+		driver.KeySession.Set(acctx, s)
+		_, _, err := m.Interceptor()(context.Background(), acctx, func(ctx context.Context) ([]driver.Msg, []driver.Msg, error) {
+			acctx.Mark = mark // 登录报文翻译后设置设备标记
+			return nil, nil, nil
+		})
+		return err
+	}
+	for _, mark := range []string{"dev1", "dev1.local"} {
+		if err := translate(mark); err != nil {
+			t.Errorf("login %s: %v", mark, err)
+		}
+	}
+	if err := translate("dev2"); !errors.Is(err, driver.ErrKick) {
+		t.Errorf("login dev2: want ErrKick, got %v", err)
+	}
+
+	// 已经登录的设备标记和证书不一致, 翻译前就拒绝
+	acctx := driver.NewACCtx("dev2", "mark", []byte{0x68})
+	driver.KeySession.Set(acctx, s)
+	called := false
+	_, _, err = m.Interceptor()(context.Background(), acctx, func(ctx context.Context) ([]driver.Msg, []driver.Msg, error) {
+		called = true
+		return nil, nil, nil
+	})
+	if !errors.Is(err, driver.ErrKick) || called {
+		t.Errorf("mismatch before translate: err %v, handler called %v", err, called)
+	}
+}
+
+func TestInterceptorCtxData(t *testing.T) {
+	m := &Manager{name: "data", markFunc: DefaultMarks}
+	run := func(marks []string, mark string) error {
+		acctx := driver.NewACCtx(mark, "data", []byte{0x68})
+		if marks != nil {
+			KeyPeerMarks.Set(acctx, marks)
+		}
+		_, _, err := m.Interceptor()(context.Background(), acctx, func(ctx context.Context) ([]driver.Msg, []driver.Msg, error) {
+			return nil, nil, nil
+		})
+		return err
+	}
+	if err := run([]string{"dev1"}, "dev1"); err != nil {
+		t.Errorf("match: %v", err)
+	}
+	if err := run([]string{"dev1"}, "dev2"); !errors.Is(err, driver.ErrKick) {
+		t.Errorf("mismatch: want ErrKick, got %v", err)
+	}
+	// 没有客户端证书不检查
+	if err := run(nil, "dev2"); err != nil {
+		t.Errorf("no certificate: %v", err)
+	}
+}
+
+func TestCRL(t *testing.T) {
+	for _, format := range []string{"pem", "der"} {
+		t.Run(format, func(t *testing.T) {
+			f := newFixture(t)
+			revokedCert, key := f.ca.issue(t, "dev2", nil, true)
+			revoked := clientCert(revokedCert, key)
+
+			der := f.ca.crl(t, revokedCert.SerialNumber)
+			if format == "pem" {
+				der = pemBytes("X509 CRL", der)
+			}
+			f.cfg.CRLFile = filepath.Join(f.dir, "ca.crl")
+			writeFile(t, f.cfg.CRLFile, der)
+
+			m, err := NewManager("crl", f.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := dial(t, m, f.clientConfig("a.example.com", revoked)); !errors.Is(err, ErrRevoked) {
+				t.Fatalf("revoked certificate: want ErrRevoked, got %v", err)
+			}
+			if _, _, err := dial(t, m, f.clientConfig("a.example.com", f.client)); err != nil {
+				t.Fatalf("valid certificate: %v", err)
+			}
+		})
+	}
+}
+
+func TestCRLWrongIssuer(t *testing.T) {
+	f := newFixture(t)
+	other := newTestCA(t)
+	f.cfg.CRLFile = filepath.Join(f.dir, "other.crl")
+	writeFile(t, f.cfg.CRLFile, other.crl(t, big.NewInt(2)))
+	if _, err := NewManager("crl", f.cfg); err == nil {
+		t.Fatal("crl signed by another ca should be rejected")
+	}
+}
+
+// echo 检查连接还能收发数据
+func echo(t *testing.T, sc, cc *tls.Conn) {
+	t.Helper()
+	go func() {
+		b := make([]byte, 4)
+		if _, err := io.ReadFull(sc, b); err == nil {
+			sc.Write(b)
+		}
+	}()
+	cc.SetDeadline(time.Now().Add(5 * time.Second))
+	defer cc.SetDeadline(time.Time{})
+	if _, err := cc.Write([]byte("ping")); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	b := make([]byte, 4)
+	if _, err := io.ReadFull(cc, b); err != nil || string(b) != "ping" {
+		t.Fatalf("read: %q %v", b, err)
+	}
+}
+
+func TestReload(t *testing.T) {
+	f := newFixture(t)
+	f.cfg.CRLFile = filepath.Join(f.dir, "ca.crl")
+	writeFile(t, f.cfg.CRLFile, f.ca.crl(t))
+	m, err := NewManager("reload", f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, cc, err := dial(t, m, f.clientConfig("a.example.com", f.client))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 换新的服务端证书, 同时吊销已连接的客户端证书
+	next, key := f.ca.issue(t, "a.example.com", []string{"a.example.com"}, false)
+	writeKeyPair(t, f.dir, "a", next, key)
+	writeFile(t, f.cfg.CRLFile, f.ca.crl(t, f.client.Leaf.SerialNumber))
+	future := time.Now().Add(time.Minute)
+	for _, file := range m.files() {
+		os.Chtimes(file, future, future)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go m.Run(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for m.changed() {
+		if time.Now().After(deadline) {
+			t.Fatal("not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 已经建立的连接不受影响
+	echo(t, sc, cc)
+
+	// 新连接使用新证书和新吊销列表
+	if _, _, err := dial(t, m, f.clientConfig("a.example.com", f.client)); !errors.Is(err, ErrRevoked) {
+		t.Fatalf("revoked after reload: want ErrRevoked, got %v", err)
+	}
+	cert, key := f.ca.issue(t, "dev3", nil, true)
+	_, cc2, err := dial(t, m, f.clientConfig("a.example.com", clientCert(cert, key)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cc2.ConnectionState().PeerCertificates[0]; got.SerialNumber.Cmp(next.SerialNumber) != 0 {
+		t.Errorf("new connection got old server certificate")
+	}
+}
+
+func TestReloadErrorKeepsOld(t *testing.T) {
+	f := newFixture(t)
+	m, err := NewManager("reload", f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, f.cfg.Certs[0].CertFile, []byte("broken"))
+	if err := m.Reload(); err == nil {
+		t.Fatal("reload broken certificate should fail")
+	}
+	_, cc, err := dial(t, m, f.clientConfig("a.example.com", f.client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cc.ConnectionState().PeerCertificates[0]; got.SerialNumber.Cmp(f.def.SerialNumber) != 0 {
+		t.Errorf("old certificate not kept")
+	}
+}
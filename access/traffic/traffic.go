@@ -0,0 +1,205 @@
+package traffic
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// ErrNotFound 设备没有流量记录
+var ErrNotFound = errors.New("traffic: mark not found")
+
+// 指标不带mark标签, 命令码标签数量超过 maxCmdLabels 后归为 other, 控制基数
+const maxCmdLabels = 256
+
+var (
+	bytesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_traffic_bytes_total",
+		Help: "traffic bytes by driver/direction/cmd",
+	}, []string{"driver", "direction", "cmd"})
+	framesCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_traffic_frames_total",
+		Help: "traffic frames by driver/direction/cmd",
+	}, []string{"driver", "direction", "cmd"})
+	marksGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_traffic_marks",
+		Help: "marks with traffic records by driver",
+	}, []string{"driver"})
+)
+
+// Snapshot 设备流量快照
+type Snapshot struct {
+	Mark    string           `json:"mark"`
+	Since   time.Time        `json:"since"`
+	Total   Stats            `json:"total"`
+	Windows map[Window]Stats `json:"windows"`
+	Cmds    map[string]Stats `json:"cmds"`
+}
+
+type markStats struct {
+	mu      sync.Mutex
+	since   time.Time
+	total   Stats
+	windows []*ring
+	cmds    map[string]*Stats
+}
+
+func newMarkStats(now time.Time) *markStats {
+	ms := &markStats{since: now, cmds: make(map[string]*Stats)}
+	for _, ws := range windowSlots {
+		ms.windows = append(ms.windows, newRing(ws.slot, ws.count))
+	}
+	return ms
+}
+
+// Accounting 驱动流量统计, 64位计数, 按设备/方向/命令统计, 带1m/1h/1d滚动窗口
+//
+//	acc := traffic.New("ykc")
+//	pipeline.Intercept(acc.Interceptor())
+//	// 驱动的 TraficSize 直接返回 acc.TraficSize(mark), Send成功后 acc.Record(mark, "", driver.DirOut, n)
+type Accounting struct {
+	driver string
+
+	mu    sync.RWMutex
+	marks map[string]*markStats
+
+	labelMu sync.Mutex
+	cmds    map[string]struct{}
+}
+
+// New 创建流量统计
+func New(driverName string) *Accounting {
+	return &Accounting{
+		driver: driverName,
+		marks:  make(map[string]*markStats),
+		cmds:   make(map[string]struct{}),
+	}
+}
+
+func (a *Accounting) cmdLabel(cmd string) string {
+	if cmd == "" {
+		return "unknown"
+	}
+	a.labelMu.Lock()
+	defer a.labelMu.Unlock()
+	if _, ok := a.cmds[cmd]; ok {
+		return cmd
+	}
+	if len(a.cmds) >= maxCmdLabels {
+		return "other"
+	}
+	a.cmds[cmd] = struct{}{}
+	return cmd
+}
+
+// Record 记录一帧报文
+func (a *Accounting) Record(mark, cmd string, dir driver.Direction, n int) {
+	bytesCounter.WithLabelValues(a.driver, dir.String(), a.cmdLabel(cmd)).Add(float64(n))
+	framesCounter.WithLabelValues(a.driver, dir.String(), a.cmdLabel(cmd)).Inc()
+	if mark == "" {
+		return
+	}
+
+	now := time.Now()
+	a.mu.RLock()
+	ms, ok := a.marks[mark]
+	a.mu.RUnlock()
+	if !ok {
+		a.mu.Lock()
+		if ms, ok = a.marks[mark]; !ok {
+			ms = newMarkStats(now)
+			a.marks[mark] = ms
+			marksGauge.WithLabelValues(a.driver).Set(float64(len(a.marks)))
+		}
+		a.mu.Unlock()
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.total.add(dir, n)
+	for _, r := range ms.windows {
+		r.add(now, dir, n)
+	}
+	if cmd != "" {
+		cs, ok := ms.cmds[cmd]
+		if !ok {
+			cs = &Stats{}
+			ms.cmds[cmd] = cs
+		}
+		cs.add(dir, n)
+	}
+}
+
+// Get 获取设备流量快照
+func (a *Accounting) Get(mark string) (Snapshot, bool) {
+	a.mu.RLock()
+	ms, ok := a.marks[mark]
+	a.mu.RUnlock()
+	if !ok {
+		return Snapshot{}, false
+	}
+
+	now := time.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	snap := Snapshot{
+		Mark:    mark,
+		Since:   ms.since,
+		Total:   ms.total,
+		Windows: make(map[Window]Stats, len(ms.windows)),
+		Cmds:    make(map[string]Stats, len(ms.cmds)),
+	}
+	for i, r := range ms.windows {
+		snap.Windows[windowSlots[i].w] = r.sum(now)
+	}
+	for cmd, cs := range ms.cmds {
+		snap.Cmds[cmd] = *cs
+	}
+	return snap, true
+}
+
+// Remove 清理设备的流量记录(设备断开后调用)
+func (a *Accounting) Remove(mark string) {
+	a.mu.Lock()
+	delete(a.marks, mark)
+	marksGauge.WithLabelValues(a.driver).Set(float64(len(a.marks)))
+	a.mu.Unlock()
+}
+
+// TraficSize 兼容 driver.Driver.TraficSize, 超过int32的计数返回 math.MaxInt32
+func (a *Accounting) TraficSize(mark string) (recv, send int32, err error) {
+	snap, ok := a.Get(mark)
+	if !ok {
+		return 0, 0, ErrNotFound
+	}
+	return clampInt32(snap.Total.RecvBytes), clampInt32(snap.Total.SendBytes), nil
+}
+
+func clampInt32(v uint64) int32 {
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	return int32(v)
+}
+
+// Interceptor 翻译拦截器, 统计上行报文和协议的应答报文
+func (a *Accounting) Interceptor() driver.TranslateInterceptor {
+	return func(ctx context.Context, acctx *driver.Ctx, handler driver.TranslateHandler) (tos []driver.Msg, rets []driver.Msg, err error) {
+		tos, rets, err = handler(ctx)
+		if raw := acctx.RawBytes(); raw != nil {
+			a.Record(acctx.Mark, acctx.GetCmd(), driver.DirIn, len(raw))
+		}
+		for _, ret := range rets {
+			for _, b := range driver.MsgBytes(ret) {
+				a.Record(ret.GetMark(), "", driver.DirOut, len(b))
+			}
+		}
+		return
+	}
+}
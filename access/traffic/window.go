@@ -0,0 +1,84 @@
+package traffic
+
+import (
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// Stats 流量统计
+type Stats struct {
+	RecvBytes  uint64 `json:"recv_bytes"`
+	SendBytes  uint64 `json:"send_bytes"`
+	RecvFrames uint64 `json:"recv_frames"`
+	SendFrames uint64 `json:"send_frames"`
+}
+
+func (s *Stats) add(dir driver.Direction, n int) {
+	switch dir {
+	case driver.DirIn:
+		s.RecvBytes += uint64(n)
+		s.RecvFrames++
+	case driver.DirOut:
+		s.SendBytes += uint64(n)
+		s.SendFrames++
+	}
+}
+
+func (s *Stats) merge(o Stats) {
+	s.RecvBytes += o.RecvBytes
+	s.SendBytes += o.SendBytes
+	s.RecvFrames += o.RecvFrames
+	s.SendFrames += o.SendFrames
+}
+
+// Window 滚动统计窗口
+type Window string
+
+const (
+	Window1m Window = "1m"
+	Window1h Window = "1h"
+	Window1d Window = "1d"
+)
+
+// windowSlots 窗口分片: 窗口越长分片越粗, 控制每个设备的内存占用
+var windowSlots = []struct {
+	w     Window
+	slot  time.Duration
+	count int
+}{
+	{Window1m, 10 * time.Second, 6},
+	{Window1h, 5 * time.Minute, 12},
+	{Window1d, time.Hour, 24},
+}
+
+// ring 环形分片计数, 每个分片记录所属的时间段, 过期分片在写入时重置
+type ring struct {
+	slot  time.Duration
+	stats []Stats
+	epoch []int64
+}
+
+func newRing(slot time.Duration, count int) *ring {
+	return &ring{slot: slot, stats: make([]Stats, count), epoch: make([]int64, count)}
+}
+
+func (r *ring) add(now time.Time, dir driver.Direction, n int) {
+	e := now.UnixNano() / int64(r.slot)
+	idx := int(e % int64(len(r.stats)))
+	if r.epoch[idx] != e {
+		r.epoch[idx] = e
+		r.stats[idx] = Stats{}
+	}
+	r.stats[idx].add(dir, n)
+}
+
+func (r *ring) sum(now time.Time) (ret Stats) {
+	e := now.UnixNano() / int64(r.slot)
+	for idx := range r.stats {
+		if e-r.epoch[idx] < int64(len(r.stats)) {
+			ret.merge(r.stats[idx])
+		}
+	}
+	return
+}
@@ -0,0 +1,323 @@
+package writeq
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+var (
+	// ErrQueueFull 写队列已满, 消息被丢弃
+	ErrQueueFull = errors.New("writeq: queue full")
+	// ErrClosed 写队列已关闭
+	ErrClosed = errors.New("writeq: queue closed")
+
+	depthGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_writeq_depth_frames",
+		Help: "pending frames in write queues by driver",
+	}, []string{"driver"})
+	bytesGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "access_writeq_depth_bytes",
+		Help: "pending bytes in write queues by driver",
+	}, []string{"driver"})
+	dropCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_writeq_drops_total",
+		Help: "dropped frames by driver/reason(drop_oldest/drop_newest/block_timeout/kick/closed)",
+	}, []string{"driver", "reason"})
+	writeCounter = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "access_writeq_writes_total",
+		Help: "socket writes by driver/result",
+	}, []string{"driver", "result"})
+)
+
+// Policy 队列满时的处理策略
+type Policy string
+
+const (
+	PolicyBlock      Policy = "block"       // 阻塞发送方, 直到有空间或超过 BlockTimeout
+	PolicyDropOldest Policy = "drop_oldest" // 丢弃最早的消息
+	PolicyDropNewest Policy = "drop_newest" // 丢弃新消息
+	PolicyKick       Policy = "kick"        // 断开连接
+)
+
+// Config 写队列配置
+type Config struct {
+	MaxFrames    int           // 最多排队的帧数, <=0不限制
+	MaxBytes     int           // 最多排队的字节数, <=0不限制
+	Policy       Policy        // 默认 PolicyDropOldest
+	BlockTimeout time.Duration // PolicyBlock 最长阻塞时间, <=0表示直到队列关闭
+	WriteTimeout time.Duration // 单次写超时, <=0不设置
+	Coalesce     bool          // 多帧合并为一次写(writev), websocket等按消息发送的连接不能开启
+}
+
+// Writer 连接, net.Conn 和 websocket.Conn 都满足
+type Writer interface {
+	Write(b []byte) (int, error)
+	SetWriteDeadline(t time.Time) error
+}
+
+type item struct {
+	frames [][]byte
+	size   int
+}
+
+// Queue 一个连接的有界写队列, 由独立协程写连接
+// 一条消息的多帧([][]byte)作为整体排队和丢弃, 不会只发送一部分
+//
+//	q := writeq.New("ykc", conn, cfg, func(reason string) { conn.Close() })
+//	defer q.Close()
+//	err := q.Send(msg)
+type Queue struct {
+	driver string
+	w      Writer
+	cfg    Config
+	kick   func(reason string)
+
+	mu     sync.Mutex
+	items  []*item
+	frames int
+	bytes  int
+	closed bool
+	idle   bool
+	empty  chan struct{} // 队列为空且没有写入中的数据时关闭
+	notify chan struct{}
+	space  chan struct{}
+	done   chan struct{}
+}
+
+// New 创建写队列并启动写协程, kick在写失败或 PolicyKick 时调用
+func New(driverName string, w Writer, cfg Config, kick func(reason string)) *Queue {
+	if cfg.Policy == "" {
+		cfg.Policy = PolicyDropOldest
+	}
+	q := &Queue{
+		driver: driverName,
+		w:      w,
+		cfg:    cfg,
+		kick:   kick,
+		idle:   true,
+		empty:  make(chan struct{}),
+		notify: make(chan struct{}, 1),
+		space:  make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+	close(q.empty)
+	go q.loop()
+	return q
+}
+
+func (q *Queue) full(it *item) bool {
+	if len(q.items) == 0 {
+		return false // 至少能放一条消息
+	}
+	return (q.cfg.MaxFrames > 0 && q.frames+len(it.frames) > q.cfg.MaxFrames) ||
+		(q.cfg.MaxBytes > 0 && q.bytes+it.size > q.cfg.MaxBytes)
+}
+
+func (q *Queue) push(it *item) {
+	q.items = append(q.items, it)
+	q.frames += len(it.frames)
+	q.bytes += it.size
+	depthGauge.WithLabelValues(q.driver).Add(float64(len(it.frames)))
+	bytesGauge.WithLabelValues(q.driver).Add(float64(it.size))
+	if q.idle {
+		q.idle, q.empty = false, make(chan struct{})
+	}
+	select {
+	case q.notify <- struct{}{}:
+	default:
+	}
+}
+
+// take 取出队头的消息, 锁内调用
+func (q *Queue) take(n int) []*item {
+	its := q.items[:n]
+	q.items = q.items[n:]
+	var frames, size int
+	for _, it := range its {
+		frames += len(it.frames)
+		size += it.size
+	}
+	q.frames -= frames
+	q.bytes -= size
+	depthGauge.WithLabelValues(q.driver).Sub(float64(frames))
+	bytesGauge.WithLabelValues(q.driver).Sub(float64(size))
+	select {
+	case q.space <- struct{}{}:
+	default:
+	}
+	return its
+}
+
+// Send 消息入队
+func (q *Queue) Send(msg driver.Msg) error {
+	it := &item{frames: driver.MsgBytes(msg)}
+	for _, f := range it.frames {
+		it.size += len(f)
+	}
+	if len(it.frames) == 0 {
+		return nil
+	}
+
+	var deadline <-chan time.Time
+	if q.cfg.Policy == PolicyBlock && q.cfg.BlockTimeout > 0 {
+		t := time.NewTimer(q.cfg.BlockTimeout)
+		defer t.Stop()
+		deadline = t.C
+	}
+	for {
+		q.mu.Lock()
+		if q.closed {
+			q.mu.Unlock()
+			dropCounter.WithLabelValues(q.driver, "closed").Add(float64(len(it.frames)))
+			return ErrClosed
+		}
+		if !q.full(it) {
+			q.push(it)
+			q.mu.Unlock()
+			return nil
+		}
+
+		switch q.cfg.Policy {
+		case PolicyDropNewest:
+			q.mu.Unlock()
+			dropCounter.WithLabelValues(q.driver, "drop_newest").Add(float64(len(it.frames)))
+			return ErrQueueFull
+		case PolicyDropOldest:
+			for len(q.items) > 0 && q.full(it) {
+				old := q.take(1)[0]
+				dropCounter.WithLabelValues(q.driver, "drop_oldest").Add(float64(len(old.frames)))
+			}
+			q.push(it)
+			q.mu.Unlock()
+			return nil
+		case PolicyKick:
+			q.mu.Unlock()
+			dropCounter.WithLabelValues(q.driver, "kick").Add(float64(len(it.frames)))
+			q.fail("write queue full")
+			return ErrQueueFull
+		}
+
+		// PolicyBlock
+		q.mu.Unlock()
+		select {
+		case <-q.space:
+		case <-q.done:
+		case <-deadline:
+			dropCounter.WithLabelValues(q.driver, "block_timeout").Add(float64(len(it.frames)))
+			return ErrQueueFull
+		}
+	}
+}
+
+func (q *Queue) loop() {
+	for {
+		select {
+		case <-q.done:
+			return
+		case <-q.notify:
+		}
+		for q.writeBatch() {
+		}
+	}
+}
+
+// writeBatch 写一批消息, 队列为空或关闭时返回false
+func (q *Queue) writeBatch() bool {
+	q.mu.Lock()
+	if q.closed || len(q.items) == 0 {
+		if !q.idle {
+			q.idle = true
+			close(q.empty)
+		}
+		q.mu.Unlock()
+		return false
+	}
+	n := 1
+	if q.cfg.Coalesce {
+		n = len(q.items)
+	}
+	its := q.take(n)
+	q.mu.Unlock()
+
+	if q.cfg.WriteTimeout > 0 {
+		q.w.SetWriteDeadline(time.Now().Add(q.cfg.WriteTimeout))
+	}
+	var err error
+	if q.cfg.Coalesce {
+		var bufs net.Buffers
+		for _, it := range its {
+			bufs = append(bufs, it.frames...)
+		}
+		_, err = bufs.WriteTo(q.w)
+	} else {
+		for _, f := range its[0].frames {
+			if _, err = q.w.Write(f); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		writeCounter.WithLabelValues(q.driver, "error").Inc()
+		q.fail("write: " + err.Error())
+		return false
+	}
+	writeCounter.WithLabelValues(q.driver, "ok").Inc()
+	return true
+}
+
+func (q *Queue) fail(reason string) {
+	if q.close() && q.kick != nil {
+		q.kick(reason)
+	}
+}
+
+// Flush 等待队列中的消息写完
+func (q *Queue) Flush(ctx context.Context) error {
+	q.mu.Lock()
+	empty := q.empty
+	q.mu.Unlock()
+	select {
+	case <-empty:
+		return nil
+	case <-q.done:
+		return ErrClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Len 排队的帧数和字节数
+func (q *Queue) Len() (frames, bytes int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.frames, q.bytes
+}
+
+func (q *Queue) close() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return false
+	}
+	q.closed = true
+	depthGauge.WithLabelValues(q.driver).Sub(float64(q.frames))
+	bytesGauge.WithLabelValues(q.driver).Sub(float64(q.bytes))
+	if q.frames > 0 {
+		dropCounter.WithLabelValues(q.driver, "closed").Add(float64(q.frames))
+	}
+	q.items, q.frames, q.bytes = nil, 0, 0
+	close(q.done)
+	return true
+}
+
+// Close 关闭队列, 未发送的消息丢弃, 需要发送完时先调用 Flush
+func (q *Queue) Close() {
+	q.close()
+}
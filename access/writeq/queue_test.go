@@ -0,0 +1,236 @@
+package writeq
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// testWriter 记录写入的数据, gate 不为nil时每次写入等待放行
+type testWriter struct {
+	mu      sync.Mutex
+	written []string
+	err     error
+	gate    chan struct{}
+	started chan struct{}
+}
+
+func newTestWriter(gated bool) *testWriter {
+	w := &testWriter{started: make(chan struct{}, 16)}
+	if gated {
+		w.gate = make(chan struct{})
+	}
+	return w
+}
+
+func (w *testWriter) Write(b []byte) (int, error) {
+	w.started <- struct{}{}
+	if w.gate != nil {
+		<-w.gate
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err != nil {
+		return 0, w.err
+	}
+	w.written = append(w.written, string(b))
+	return len(b), nil
+}
+
+func (w *testWriter) SetWriteDeadline(time.Time) error { return nil }
+
+func (w *testWriter) frames() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.written...)
+}
+
+func msg(frames ...string) driver.Msg {
+	bs := make([][]byte, 0, len(frames))
+	for _, f := range frames {
+		bs = append(bs, []byte(f))
+	}
+	return driver.NewMsg("A", bs)
+}
+
+func TestPolicy(t *testing.T) {
+	cases := []struct {
+		name        string
+		policy      Policy
+		timeout     time.Duration
+		wantErr     error
+		wantKick    bool
+		wantWritten []string
+	}{
+		{name: "drop oldest", policy: PolicyDropOldest, wantWritten: []string{"0", "b1", "b2", "c"}},
+		{name: "drop newest", policy: PolicyDropNewest, wantErr: ErrQueueFull, wantWritten: []string{"0", "a", "b1", "b2"}},
+		{name: "kick", policy: PolicyKick, wantErr: ErrQueueFull, wantKick: true},
+		{name: "block timeout", policy: PolicyBlock, timeout: 10 * time.Millisecond, wantErr: ErrQueueFull, wantWritten: []string{"0", "a", "b1", "b2"}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			w := newTestWriter(true)
+			var kicked string
+			q := New("test", w, Config{MaxFrames: 3, Policy: c.policy, BlockTimeout: c.timeout}, func(reason string) { kicked = reason })
+			defer q.Close()
+
+			if err := q.Send(msg("0")); err != nil {
+				t.Fatal(err)
+			}
+			<-w.started // 写协程阻塞在第一条消息
+			for _, m := range []driver.Msg{msg("a"), msg("b1", "b2")} {
+				if err := q.Send(m); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := q.Send(msg("c")); err != c.wantErr {
+				t.Fatalf("err = %v, want %v", err, c.wantErr)
+			}
+			if (kicked != "") != c.wantKick {
+				t.Fatalf("kicked = %q, want kick %v", kicked, c.wantKick)
+			}
+
+			close(w.gate)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := q.Flush(ctx); c.wantKick {
+				if err != ErrClosed {
+					t.Fatalf("flush err = %v, want ErrClosed", err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := w.frames(); !reflect.DeepEqual(got, c.wantWritten) {
+				t.Fatalf("written = %v, want %v", got, c.wantWritten)
+			}
+		})
+	}
+}
+
+func TestBlockUntilSpace(t *testing.T) {
+	w := newTestWriter(true)
+	q := New("test", w, Config{MaxFrames: 1, Policy: PolicyBlock}, nil)
+	defer q.Close()
+
+	q.Send(msg("0"))
+	<-w.started
+	q.Send(msg("a"))
+
+	sent := make(chan error, 1)
+	go func() { sent <- q.Send(msg("b")) }()
+	select {
+	case err := <-sent:
+		t.Fatalf("send returned %v before space", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(w.gate)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.frames(), []string{"0", "a", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("written = %v, want %v", got, want)
+	}
+}
+
+func TestMaxBytesKeepsOneMessage(t *testing.T) {
+	w := newTestWriter(true)
+	q := New("test", w, Config{MaxBytes: 2, Policy: PolicyDropNewest}, nil)
+	defer q.Close()
+
+	q.Send(msg("0"))
+	<-w.started
+	// 队列为空时超过限制的消息也能入队
+	if err := q.Send(msg("long")); err != nil {
+		t.Fatal(err)
+	}
+	if frames, bytes := q.Len(); frames != 1 || bytes != 4 {
+		t.Fatalf("len = %d/%d, want 1/4", frames, bytes)
+	}
+	if err := q.Send(msg("x")); err != ErrQueueFull {
+		t.Fatalf("err = %v, want ErrQueueFull", err)
+	}
+	close(w.gate)
+}
+
+func TestWriteErrorKicks(t *testing.T) {
+	w := newTestWriter(false)
+	w.err = errors.New("broken pipe")
+	kicked := make(chan string, 1)
+	q := New("test", w, Config{}, func(reason string) { kicked <- reason })
+
+	q.Send(msg("a"))
+	select {
+	case reason := <-kicked:
+		if reason != "write: broken pipe" {
+			t.Fatalf("reason = %q", reason)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not kicked")
+	}
+	if err := q.Send(msg("b")); err != ErrClosed {
+		t.Fatalf("err = %v, want ErrClosed", err)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	w := newTestWriter(true)
+	q := New("test", w, Config{Coalesce: true}, nil)
+	defer q.Close()
+
+	q.Send(msg("0"))
+	<-w.started
+	q.Send(msg("a1", "a2"))
+	q.Send(msg("b"))
+	close(w.gate)
+	if err := q.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := w.frames(), []string{"0", "a1", "a2", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("written = %v, want %v", got, want)
+	}
+}
+
+func TestSet(t *testing.T) {
+	s := NewSet()
+	if err := s.Send(msg("a")); err != ErrOffline {
+		t.Fatalf("err = %v, want ErrOffline", err)
+	}
+
+	w1, w2 := newTestWriter(false), newTestWriter(false)
+	q1 := New("test", w1, Config{}, nil)
+	q2 := New("test", w2, Config{}, nil)
+	defer q1.Close()
+	defer q2.Close()
+	s.Add("A", q1)
+	if old := s.Add("A", q2); old != q1 {
+		t.Fatal("Add did not return replaced queue")
+	}
+	// 旧连接关闭时不能删除新连接的队列
+	s.Remove("A", q1)
+	if s.Get("A") != q2 {
+		t.Fatal("replaced queue removed new one")
+	}
+
+	if err := s.Send(msg("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(w1.frames()) != 0 || len(w2.frames()) != 1 {
+		t.Fatalf("written %v %v", w1.frames(), w2.frames())
+	}
+	s.Remove("A", q2)
+	if s.Get("A") != nil {
+		t.Fatal("queue not removed")
+	}
+}
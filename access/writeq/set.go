@@ -0,0 +1,79 @@
+package writeq
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/zhuoqingbin/utils/access/driver"
+)
+
+// ErrOffline 设备没有写队列(不在线)
+var ErrOffline = errors.New("writeq: device offline")
+
+// Set 驱动所有连接的写队列, 按设备标记查找
+//
+//	qs := writeq.NewSet()
+//	qs.Add(mark, writeq.New("ykc", conn, cfg, kick))  // 登录成功后
+//	qs.Remove(mark, q)                                // 连接关闭后
+//	func (d *TCPDriver) Send(msg driver.Msg) error { return d.queues.Send(msg) }
+//	drainer.OnFlush(qs.Flush)
+type Set struct {
+	mu     sync.RWMutex
+	queues map[string]*Queue
+}
+
+// NewSet 创建写队列集合
+func NewSet() *Set {
+	return &Set{queues: make(map[string]*Queue)}
+}
+
+// Add 登记设备的写队列, 返回被替换的旧队列
+func (s *Set) Add(mark string, q *Queue) (old *Queue) {
+	s.mu.Lock()
+	old = s.queues[mark]
+	s.queues[mark] = q
+	s.mu.Unlock()
+	return
+}
+
+// Remove 删除设备的写队列, q不是当前登记的队列时不删除(已被新连接替换)
+func (s *Set) Remove(mark string, q *Queue) {
+	s.mu.Lock()
+	if s.queues[mark] == q {
+		delete(s.queues, mark)
+	}
+	s.mu.Unlock()
+}
+
+// Get 设备的写队列
+func (s *Set) Get(mark string) *Queue {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.queues[mark]
+}
+
+// Send 发送消息到设备的写队列
+func (s *Set) Send(msg driver.Msg) error {
+	q := s.Get(msg.GetMark())
+	if q == nil {
+		return ErrOffline
+	}
+	return q.Send(msg)
+}
+
+// Flush 等待所有队列写完, 用于优雅关闭
+func (s *Set) Flush(ctx context.Context) error {
+	s.mu.RLock()
+	qs := make([]*Queue, 0, len(s.queues))
+	for _, q := range s.queues {
+		qs = append(qs, q)
+	}
+	s.mu.RUnlock()
+	for _, q := range qs {
+		if err := q.Flush(ctx); err != nil && err != ErrClosed {
+			return err
+		}
+	}
+	return nil
+}
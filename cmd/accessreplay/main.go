@@ -0,0 +1,83 @@
+// accessreplay 回放 access/capture 抓取的报文, 对比协议应答和抓包记录
+//
+// 协议需要通过 access.RegisterProtocol 注册: 在 plugin.go 中匿名导入协议包, 或者用 --plugin 加载编译为插件的协议包
+// 回放使用 access.Pipeline 组装链路, 对比协议的应答和翻译中通过驱动Send的报文
+//
+//	accessreplay --file=ykc.jsonl --protocol=ykc --plugin=ykc.so --speed=10 --diff_only
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/zhuoqingbin/utils/access"
+	"github.com/zhuoqingbin/utils/access/capture"
+	"github.com/zhuoqingbin/utils/flags"
+	"github.com/zhuoqingbin/utils/lg"
+)
+
+var (
+	file       = flags.StringRequired("file", "capture file(jsonl)")
+	protocol   = flags.StringRequired("protocol", "registered protocol type")
+	plugins    = flags.Slice("plugin", nil, "protocol plugins(go build -buildmode=plugin) registering protocols in init")
+	driverName = flags.String("driver", "", "driver name set to ctx, default same as protocol")
+	speed      = flags.Float64("speed", 1, "replay speed, 1 is original, <=0 as fast as possible")
+	diffOnly   = flags.Bool("diff_only", false, "only print mismatched frames")
+)
+
+func main() {
+	flags.Parse()
+
+	if err := loadPlugins(plugins()); err != nil {
+		lg.Fatalf("%v", err)
+	}
+	p, err := access.NewProtocol(protocol(), nil)
+	if err != nil {
+		lg.Fatalf("%v, registered: %s", err, strings.Join(access.Protocols(), ","))
+	}
+	name := driverName()
+	if name == "" {
+		name = protocol()
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	total, mismatched, err := capture.NewReplayer(name, p).SetSpeed(speed()).Replay(ctx, file(), func(res *capture.Result) {
+		match := res.Match()
+		if match && diffOnly() {
+			return
+		}
+		fmt.Printf("%s %s cmd=%s in=%s", res.In.Time.Format("2006-01-02 15:04:05.000"), res.In.Mark, res.In.Cmd, hex.EncodeToString(res.In.Data))
+		if res.Err != nil {
+			fmt.Printf(" err=%v", res.Err)
+		}
+		if match {
+			fmt.Println(" OK")
+			return
+		}
+		fmt.Println(" DIFF")
+		out := res.Output()
+		for i := 0; i < len(res.Expected) || i < len(out); i++ {
+			var want, got string
+			if i < len(res.Expected) {
+				want = hex.EncodeToString(res.Expected[i])
+			}
+			if i < len(out) {
+				got = hex.EncodeToString(out[i])
+			}
+			fmt.Printf("  - want %s\n  + got  %s\n", want, got)
+		}
+	})
+	fmt.Printf("total=%d mismatched=%d\n", total, mismatched)
+	if err != nil {
+		lg.Fatalf("replay: %v", err)
+	}
+	if mismatched > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,108 @@
+// accesssim 模拟设备压测接入层, 定时输出延时和错误统计
+//
+// 内置 hex 脚本按 --fixture 的十六进制报文模板模拟登录/心跳(见 fixtures/example.json 和 sim.HexFixture)
+// 其他协议脚本通过 sim.RegisterScript 注册: 在 plugin.go 中匿名导入脚本包, 或者用 --plugin 加载编译为插件的脚本包
+//
+//	accesssim --fixture=fixtures/example.json --addr=127.0.0.1:8767 --count=100
+//	accesssim --script=ykc --plugin=ykc.so --addr=127.0.0.1:8767 --count=50000 --ramp_up=1000
+//	accesssim --script=ocpp16 --ws_url=ws://127.0.0.1:8080/ocpp/ --ws_protocol=ocpp1.6 --count=1000
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/zhuoqingbin/utils/access/sim"
+	"github.com/zhuoqingbin/utils/flags"
+	"github.com/zhuoqingbin/utils/lg"
+)
+
+var (
+	script     = flags.String("script", scriptHex, "registered sim script, hex uses --fixture")
+	fixture    = flags.String("fixture", "", "hex script fixture json")
+	plugins    = flags.Slice("plugin", nil, "script plugins(go build -buildmode=plugin) registering scripts in init")
+	addr       = flags.String("addr", "", "tcp driver address")
+	wsURL      = flags.String("ws_url", "", "websocket driver url, used when addr is empty")
+	wsProtocol = flags.String("ws_protocol", "", "websocket subprotocol")
+	wsOrigin   = flags.String("ws_origin", "http://localhost/", "websocket origin")
+	count      = flags.Int("count", 1, "device count")
+	rampUp     = flags.Float64("ramp_up", 100, "devices started per second, <=0 start all at once")
+	markFormat = flags.String("mark_format", "SIM%010d", "device mark format with device index")
+	heartbeat  = flags.Duration("heartbeat", 10*time.Second, "heartbeat interval")
+	meter      = flags.Duration("meter", 15*time.Second, "meter values interval, 0 disable")
+	timeout    = flags.Duration("reply_timeout", 10*time.Second, "reply timeout")
+	reconnect  = flags.Duration("reconnect", 5*time.Second, "reconnect interval, 0 disable")
+	duration   = flags.Duration("duration", 0, "run duration, 0 until interrupted")
+	report     = flags.Duration("report", 10*time.Second, "stats report interval")
+)
+
+// scriptHex 内置的十六进制模板脚本
+const scriptHex = "hex"
+
+func main() {
+	flags.Parse()
+
+	if err := loadPlugins(plugins()); err != nil {
+		lg.Fatalf("%v", err)
+	}
+	if fixture() != "" {
+		hs, err := sim.LoadHexScript(fixture())
+		if err != nil {
+			lg.Fatalf("%v", err)
+		}
+		sim.RegisterScript(scriptHex, hs)
+	}
+	s, err := sim.GetScript(script())
+	if err != nil {
+		if script() == scriptHex {
+			lg.Fatalf("Missing config:[fixture] for script hex")
+		}
+		lg.Fatalf("%v, registered: %s", err, strings.Join(sim.Scripts(), ","))
+	}
+
+	cfg := &sim.Config{
+		Script:            s,
+		HeartbeatInterval: heartbeat(),
+		MeterInterval:     meter(),
+		ReplyTimeout:      timeout(),
+		Reconnect:         reconnect(),
+	}
+	switch {
+	case addr() != "":
+		cfg.Dial = sim.TCPDialer(addr(), 5*time.Second)
+	case wsURL() != "":
+		cfg.Dial = sim.WSDialer(wsURL(), wsProtocol(), wsOrigin())
+	default:
+		lg.Fatalf("Missing config:[addr] or [ws_url]")
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+	if duration() > 0 {
+		ctx, cancel = context.WithTimeout(ctx, duration())
+		defer cancel()
+	}
+
+	r := sim.NewRunner(cfg).SetCount(count()).SetRampUp(rampUp()).SetMarkFormat(markFormat())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.Run(ctx)
+	}()
+
+	t := time.NewTicker(report())
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			fmt.Printf("---- %s final\n%s", time.Now().Format("15:04:05"), r.Stats())
+			return
+		case <-t.C:
+			fmt.Printf("---- %s\n%s", time.Now().Format("15:04:05"), r.Stats())
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+// 脚本注册: 脚本包在init中调用 sim.RegisterScript, 有两种方式接入:
+//  1. 在这个文件中匿名导入脚本包后重新编译
+//  2. 把脚本包编译为插件(go build -buildmode=plugin), 运行时用 --plugin 加载, 不需要修改源码
+//
+//	import _ "xxx/sim/ykc"
+
+import (
+	"fmt"
+	"plugin"
+)
+
+// loadPlugins 加载插件, 插件加载时执行其中包的init完成注册
+func loadPlugins(paths []string) error {
+	for _, path := range paths {
+		if path == "" {
+			continue
+		}
+		if _, err := plugin.Open(path); err != nil {
+			return fmt.Errorf("load plugin %s: %w", path, err)
+		}
+	}
+	return nil
+}